@@ -1,6 +1,6 @@
 module github.com/cisco-pxgrid/cloud-sdk-go
 
-go 1.16
+go 1.18
 
 require (
 	github.com/cisco-pxgrid/websocket v1.0.1
@@ -9,8 +9,16 @@ require (
 	github.com/goccy/go-json v0.9.11
 	github.com/google/uuid v1.3.0
 	github.com/jarcoal/httpmock v1.1.0
-	github.com/klauspost/compress v1.15.1 // indirect
+	github.com/klauspost/compress v1.15.1
 	github.com/rs/xid v1.2.1
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/time v0.3.0
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/net v0.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,38 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"github.com/cisco-pxgrid/websocket"
+)
+
+// Abort tears the connection down immediately. Unlike disconnect and Drain, it doesn't delete
+// the subscriptions on the server, wait for the callbacks to return or for the close handshake to
+// complete. The subscriber contexts are cancelled, the in-flight publishes fail and ErrAborted is
+// sent to the Error channel.
+func (c *internalConnection) Abort() {
+	c.closeOnce.Do(func() {
+		c.logger().Warnf("Aborting PubSub connection")
+		close(c.closed)
+		c.ctxCancel()
+
+		c.subs.Lock()
+		for stream, sub := range c.subs.table {
+			delete(c.subs.table, stream)
+			sub.ctxCancel()
+		}
+		c.subs.Unlock()
+
+		c.mu.Lock()
+		ws := c.ws
+		c.mu.Unlock()
+		if ws != nil {
+			// the close handshake completes in the background
+			go ws.Close(websocket.StatusGoingAway, "aborted")
+		}
+
+		c.Error <- ErrAborted
+		close(c.Error)
+	})
+}
@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Abort(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	// the callback blocks until the end of the test
+	release := make(chan struct{})
+	defer close(release)
+	blocked := make(chan struct{}, 1)
+	subID, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {
+		select {
+		case blocked <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	require.NoError(t, err)
+	require.True(t, test.PublishRaw("test-stream",
+		rpc.PublishParams{MsgID: "msg-1", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload"))}))
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the callback")
+	}
+
+	// the publish is never answered by the server
+	published := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+		published <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	c.Abort()
+	require.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	require.True(t, c.isDisconnected())
+	require.Empty(t, c.SubscriptionSnapshot())
+	// the subscription isn't deleted on the server
+	require.True(t, test.HasSubscription(subID))
+
+	select {
+	case err = <-published:
+		require.ErrorIs(t, err, ErrNotConnected)
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the publish to fail")
+	}
+	select {
+	case err = <-c.Error:
+		require.ErrorIs(t, err, ErrAborted)
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the connection to close")
+	}
+
+	// aborting again is a no-op
+	c.Abort()
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	defaultNackRedeliveryDelay = 1 * time.Second
+	defaultAckTimeout          = 30 * time.Second
+)
+
+// AckMode determines when the consume context of a subscription advances
+type AckMode int
+
+const (
+	// AckModeAuto advances the consume context with every consume response, regardless of whether
+	// the messages were processed
+	AckModeAuto AckMode = iota
+	// AckModeManual advances the consume context once every message of the consumed batch is
+	// acked. Messages delivered to an AckSubscriptionCallback must be acked with Message.Ack, the
+	// callback returning doesn't ack them. Messages delivered to a SubscriptionCallback are acked
+	// when the callback returns. If the batch isn't fully acked within Config.AckTimeout, the
+	// batch is consumed again and the unacked messages are redelivered with Redeliveries
+	// incremented, the acked ones are skipped.
+	AckModeManual
+)
+
+// AckSubscriptionCallback is the callback that's invoked when a message/error is received for a
+// subscription created with SubscribeWithAck.
+//
+// err is set to a non-nil error in case of an error. msg is nil if the error isn't associated with
+// a message.
+// msg is the received message, which should be settled with either Ack or Nack. A message that
+// is neither acked nor nacked when the callback returns is acked.
+type AckSubscriptionCallback func(err error, msg *Message)
+
+// Message is a message delivered to an AckSubscriptionCallback
+type Message struct {
+	ID           string            // message ID
+	Headers      map[string]string // headers associated with the message
+	Payload      []byte            // message payload
+	Redeliveries int               // number of times the message was redelivered after a Nack
+
+	raw     string // payload as consumed, before decoding
+	ctx     context.Context
+	sub     *subscription
+	settled bool
+	mu      sync.Mutex
+
+	timestamp time.Time // time the server accepted the message, zero if unknown
+	decoded   bool      // Payload was decoded by SubscribeOptions.Filter
+	offset    int64     // offset of the message in the partition, -1 if unknown
+}
+
+// Context returns the context of the consume span started by Config.Propagator, or the
+// background context without Propagator.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Ack commits the message. Calling Ack or Nack on a settled message has no effect.
+func (m *Message) Ack() {
+	if m.settle() && m.sub != nil {
+		m.sub.ack(m.ID)
+	}
+}
+
+// Nack requests redelivery of the message after Config.NackRedeliveryDelay. Once the message was
+// redelivered Config.MaxRedeliveries times, it's delivered to the callback one last time along
+// with ErrRedeliveryLimit instead. Calling Ack or Nack on a settled message has no effect.
+func (m *Message) Nack() {
+	if m.settle() && m.sub != nil {
+		m.sub.nack(m)
+	}
+}
+
+// settle marks the message as settled, it returns false if the message was already settled
+func (m *Message) settle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.settled {
+		return false
+	}
+	m.settled = true
+	return true
+}
+
+// batch tracks the acks of the consumed batch with AckModeManual
+type batch struct {
+	pending   map[string]bool // IDs of the messages that weren't acked yet
+	acked     map[string]bool // IDs of the acked messages, skipped if the batch is consumed again
+	delivered map[string]int  // number of times a message of the batch was delivered
+	done      chan struct{}   // closed once every message is acked
+	sync.Mutex
+}
+
+// start starts tracking the messages of a consumed batch. It returns the messages that weren't
+// acked yet with their number of redeliveries set.
+func (b *batch) start(msgs []*Message) []*Message {
+	b.Lock()
+	defer b.Unlock()
+	if b.acked == nil {
+		b.acked = map[string]bool{}
+		b.delivered = map[string]int{}
+	}
+	b.pending = map[string]bool{}
+	b.done = make(chan struct{})
+	var unacked []*Message
+	for _, m := range msgs {
+		if b.acked[m.ID] {
+			continue
+		}
+		m.Redeliveries = b.delivered[m.ID]
+		b.delivered[m.ID]++
+		b.pending[m.ID] = true
+		unacked = append(unacked, m)
+	}
+	if len(b.pending) == 0 {
+		close(b.done)
+	}
+	return unacked
+}
+
+// ack marks the message as acked
+func (b *batch) ack(id string) {
+	b.Lock()
+	defer b.Unlock()
+	if !b.pending[id] {
+		return
+	}
+	delete(b.pending, id)
+	b.acked[id] = true
+	if len(b.pending) == 0 {
+		close(b.done)
+	}
+}
+
+// reset forgets the messages once the consume context advanced
+func (b *batch) reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.pending = nil
+	b.acked = nil
+	b.delivered = nil
+}
+
+// ack marks the message as acked in the current batch with AckModeManual
+func (sub *subscription) ack(id string) {
+	if sub.ackMode == AckModeManual {
+		sub.batch.ack(id)
+	}
+}
+
+// waitAcked waits until every message of the current batch is acked, redelivering the nacked
+// messages meanwhile. It returns false if the batch wasn't acked within Config.AckTimeout.
+func (sub *subscription) waitAcked(tick time.Duration) bool {
+	sub.batch.Lock()
+	done := sub.batch.done
+	sub.batch.Unlock()
+	timeout := time.NewTimer(sub.ackTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-done:
+			return true
+		case <-time.After(tick):
+			sub.redeliver()
+		case <-timeout.C:
+			sub.dropRedeliveries()
+			return false
+		case <-sub.ctx.Done():
+			return false
+		}
+	}
+}
+
+// dropRedeliveries drops the pending redeliveries of the messages of the current batch as the
+// batch is consumed again
+func (sub *subscription) dropRedeliveries() {
+	sub.batch.Lock()
+	pendingIDs := sub.batch.pending
+	sub.batch.Unlock()
+	sub.redeliveries.Lock()
+	defer sub.redeliveries.Unlock()
+	queue := sub.redeliveries.queue[:0]
+	for _, r := range sub.redeliveries.queue {
+		if !pendingIDs[r.msg.ID] {
+			queue = append(queue, r)
+		}
+	}
+	sub.redeliveries.queue = queue
+}
+
+// redelivery is a nacked message waiting to be redelivered
+type redelivery struct {
+	msg *Message
+	due time.Time
+	err error
+}
+
+// nack schedules the redelivery of the message
+func (sub *subscription) nack(m *Message) {
+	r := redelivery{
+		msg: &Message{
+			ID:           m.ID,
+			Headers:      m.Headers,
+			Payload:      m.Payload,
+			Redeliveries: m.Redeliveries + 1,
+			sub:          sub,
+		},
+		due: time.Now().Add(sub.nackDelay),
+	}
+	if sub.maxRedeliveries > 0 && m.Redeliveries >= sub.maxRedeliveries {
+		sub.logger.Warnf("Message %s of stream %s reached the redelivery limit", m.ID, sub.stream)
+		r.msg.Redeliveries = m.Redeliveries
+		r.msg.settled = true
+		r.due = time.Now()
+		r.err = ErrRedeliveryLimit
+	}
+	sub.redeliveries.Lock()
+	sub.redeliveries.queue = append(sub.redeliveries.queue, r)
+	sub.redeliveries.Unlock()
+}
+
+// redeliver delivers the nacked messages whose redelivery delay has passed
+func (sub *subscription) redeliver() {
+	now := time.Now()
+	sub.redeliveries.Lock()
+	var due []redelivery
+	pending := sub.redeliveries.queue[:0]
+	for _, r := range sub.redeliveries.queue {
+		if now.Before(r.due) {
+			pending = append(pending, r)
+		} else {
+			due = append(due, r)
+		}
+	}
+	sub.redeliveries.queue = pending
+	sub.redeliveries.Unlock()
+
+	for _, r := range due {
+		sub.logger.Debugf("Redelivering message %s of stream %s, redeliveries: %d", r.msg.ID, sub.stream, r.msg.Redeliveries)
+		sub.deliver(r.err, r.msg)
+		if r.err != nil {
+			// the message reached the redelivery limit and is settled
+			sub.ack(r.msg.ID)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+type delivery struct {
+	err error
+	msg *Message
+	at  time.Time
+}
+
+func Test_Nack(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval:        10 * time.Millisecond,
+		NackRedeliveryDelay: 200 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	deliveries := make(chan delivery, 10)
+	_, err := c.subscribeWithAck("test-stream", "", func(err error, msg *Message) {
+		deliveries <- delivery{err: err, msg: msg, at: time.Now()}
+		if msg.Redeliveries == 0 {
+			msg.Nack()
+		} else {
+			msg.Ack()
+		}
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", map[string]string{"key": "value"}, []byte("test payload"))
+	require.NoError(t, err)
+
+	first := <-deliveries
+	require.NoError(t, first.err)
+	require.Equal(t, 0, first.msg.Redeliveries)
+
+	select {
+	case second := <-deliveries:
+		require.NoError(t, second.err)
+		require.Equal(t, 1, second.msg.Redeliveries)
+		require.Equal(t, first.msg.ID, second.msg.ID)
+		require.Equal(t, "value", second.msg.Headers["key"])
+		require.Equal(t, []byte("test payload"), second.msg.Payload)
+		require.GreaterOrEqual(t, second.at.Sub(first.at), 200*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		t.Fatal("nacked message wasn't redelivered")
+	}
+
+	// acked message isn't redelivered
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery: %+v", d)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func Test_NackRedeliveryLimit(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval:        10 * time.Millisecond,
+		NackRedeliveryDelay: 10 * time.Millisecond,
+		MaxRedeliveries:     2,
+	})
+	defer c.disconnect()
+
+	deliveries := make(chan delivery, 10)
+	_, err := c.subscribeWithAck("test-stream", "", func(err error, msg *Message) {
+		deliveries <- delivery{err: err, msg: msg}
+		msg.Nack()
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		d := <-deliveries
+		require.NoError(t, d.err)
+		require.Equal(t, i, d.msg.Redeliveries)
+	}
+	d := <-deliveries
+	require.ErrorIs(t, d.err, ErrRedeliveryLimit)
+	require.Equal(t, 2, d.msg.Redeliveries)
+
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery: %+v", d)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_AckInvalidPayload(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval:        10 * time.Millisecond,
+		NackRedeliveryDelay: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	deliveries := make(chan delivery, 10)
+	_, err := c.subscribeWithAck("test-stream", "", func(err error, msg *Message) {
+		deliveries <- delivery{err: err, msg: msg}
+		if err != nil {
+			// nacking the invalid message has no effect
+			msg.Nack()
+		}
+	})
+	require.NoError(t, err)
+
+	// single batch with an invalid message in the middle
+	require.True(t, test.PublishRaw("test-stream",
+		rpc.PublishParams{MsgID: "msg-1", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload 1"))},
+		rpc.PublishParams{MsgID: "msg-2", Stream: "test-stream", Payload: "not base64!"},
+		rpc.PublishParams{MsgID: "msg-3", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload 3"))},
+	))
+
+	d := <-deliveries
+	require.NoError(t, d.err)
+	require.Equal(t, "msg-1", d.msg.ID)
+	require.Equal(t, []byte("payload 1"), d.msg.Payload)
+	d = <-deliveries
+	require.ErrorIs(t, d.err, ErrInvalidPayload)
+	require.Equal(t, "msg-2", d.msg.ID)
+	require.Nil(t, d.msg.Payload)
+	d = <-deliveries
+	require.NoError(t, d.err)
+	require.Equal(t, "msg-3", d.msg.ID)
+	require.Equal(t, []byte("payload 3"), d.msg.Payload)
+
+	// the consume context advanced, the next message is delivered and nothing is redelivered
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("payload 4"))
+	require.NoError(t, err)
+	d = <-deliveries
+	require.NoError(t, d.err)
+	require.Equal(t, []byte("payload 4"), d.msg.Payload)
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery: %+v", d)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func Test_AckModeManualPartialAck(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		AckMode:      AckModeManual,
+		AckTimeout:   200 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	deliveries := make(chan delivery, 10)
+	unacked := make(chan *Message, 10)
+	_, err := c.subscribeWithAck("test-stream", "", func(err error, msg *Message) {
+		deliveries <- delivery{err: err, msg: msg}
+		if msg.ID == "msg-2" {
+			// returning doesn't ack the message
+			unacked <- msg
+			return
+		}
+		msg.Ack()
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return c.consumeContext("test-stream") != ""
+	}, time.Second, 10*time.Millisecond)
+	consumeCtx := c.consumeContext("test-stream")
+
+	require.True(t, test.PublishRaw("test-stream",
+		rpc.PublishParams{MsgID: "msg-1", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload 1"))},
+		rpc.PublishParams{MsgID: "msg-2", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload 2"))},
+		rpc.PublishParams{MsgID: "msg-3", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload 3"))},
+	))
+	for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+		d := <-deliveries
+		require.NoError(t, d.err)
+		require.Equal(t, id, d.msg.ID)
+		require.Equal(t, 0, d.msg.Redeliveries)
+	}
+	<-unacked
+	require.Equal(t, consumeCtx, c.consumeContext("test-stream"))
+
+	// only the unacked message is redelivered once the ack timeout elapsed
+	d := <-deliveries
+	require.NoError(t, d.err)
+	require.Equal(t, "msg-2", d.msg.ID)
+	require.Equal(t, []byte("payload 2"), d.msg.Payload)
+	require.Equal(t, 1, d.msg.Redeliveries)
+	require.Equal(t, consumeCtx, c.consumeContext("test-stream"))
+
+	// acking the last message advances the consume context
+	(<-unacked).Ack()
+	require.Eventually(t, func() bool {
+		return c.consumeContext("test-stream") != consumeCtx
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery: %+v", d)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func Test_AckModeManualSubscribe(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		AckMode:      AckModeManual,
+		AckTimeout:   200 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	// messages delivered to a SubscriptionCallback are acked once the callback returns
+	payloads := make(chan []byte, 10)
+	_, err := c.subscribe("test-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		payloads <- payload
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("test payload"), <-payloads)
+
+	select {
+	case p := <-payloads:
+		t.Fatalf("unexpected delivery: %s", p)
+	case <-time.After(400 * time.Millisecond):
+	}
+}
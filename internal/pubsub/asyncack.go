@@ -0,0 +1,66 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"sync/atomic"
+)
+
+// AsyncAckPolicy determines what happens to the result of a PublishAsync when the result channel
+// isn't ready to receive it
+type AsyncAckPolicy int
+
+const (
+	// AsyncAckDropAndLog drops the result and logs a warning
+	AsyncAckDropAndLog AsyncAckPolicy = iota
+	// AsyncAckBlock waits until the result is received, the publish is canceled or the connection
+	// is closed
+	AsyncAckBlock
+	// AsyncAckBufferUpTo waits like AsyncAckBlock for up to Config.AsyncAckBufferSize results at a
+	// time, further results are dropped and logged
+	AsyncAckBufferUpTo
+)
+
+// deliverAsyncAck delivers the publish result to the result channel according to the
+// AsyncAckPolicy. It's invoked with ack locked.
+func (c *internalConnection) deliverAsyncAck(ack *pubResultAck, pr *PublishResult) {
+	select {
+	case ack.ch <- pr:
+		return
+	default:
+	}
+
+	switch c.config.AsyncAckPolicy {
+	case AsyncAckBlock:
+		go c.waitAsyncAck(ack, pr)
+		return
+	case AsyncAckBufferUpTo:
+		if atomic.AddInt32(&c.asyncAcks, 1) <= int32(c.config.AsyncAckBufferSize) {
+			go func() {
+				defer atomic.AddInt32(&c.asyncAcks, -1)
+				c.waitAsyncAck(ack, pr)
+			}()
+			return
+		}
+		atomic.AddInt32(&c.asyncAcks, -1)
+	}
+	c.logger().Warnf("Dropping publish result of message %s, the result channel is full", pr.ID)
+}
+
+// waitAsyncAck blocks until the publish result is received, the publish is canceled or the
+// connection is closed
+func (c *internalConnection) waitAsyncAck(ack *pubResultAck, pr *PublishResult) {
+	// ack stays locked while waiting so that the channel isn't used once cancel returns
+	ack.Lock()
+	defer ack.Unlock()
+	if ack.ch == nil {
+		return
+	}
+	select {
+	case ack.ch <- pr:
+	case <-ack.canceled:
+	case <-c.closed:
+		c.logger().Warnf("Dropping publish result of message %s, the connection is closed", pr.ID)
+	}
+}
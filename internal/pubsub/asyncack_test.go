@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+// publishAsyncSlowReader publishes n messages and returns the results read after the responses
+// were received by the connection
+func publishAsyncSlowReader(t *testing.T, config Config, n int) ([]*PublishResult, *testLogger) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	logger := &testLogger{}
+	config.Logger = logger
+	c := newTestConnection(t, s, config)
+	defer c.disconnect()
+
+	result := make(chan *PublishResult)
+	ids := map[string]bool{}
+	for i := 0; i < n; i++ {
+		id, cancel, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+		require.NoError(t, err)
+		defer cancel()
+		ids[id] = true
+	}
+
+	// slow reader
+	time.Sleep(300 * time.Millisecond)
+	var results []*PublishResult
+	for {
+		select {
+		case r := <-result:
+			require.True(t, ids[r.ID])
+			require.NoError(t, r.Error)
+			results = append(results, r)
+		case <-time.After(200 * time.Millisecond):
+			return results, logger
+		}
+	}
+}
+
+func Test_AsyncAckDropAndLog(t *testing.T) {
+	results, logger := publishAsyncSlowReader(t, Config{}, 3)
+	require.Empty(t, results)
+	require.True(t, logger.contains("Dropping publish result"))
+}
+
+func Test_AsyncAckBlock(t *testing.T) {
+	results, logger := publishAsyncSlowReader(t, Config{
+		AsyncAckPolicy: AsyncAckBlock,
+	}, 3)
+	require.Len(t, results, 3)
+	require.False(t, logger.contains("Dropping publish result"))
+}
+
+func Test_AsyncAckBufferUpTo(t *testing.T) {
+	results, logger := publishAsyncSlowReader(t, Config{
+		AsyncAckPolicy:     AsyncAckBufferUpTo,
+		AsyncAckBufferSize: 2,
+	}, 5)
+	require.Len(t, results, 2)
+	require.True(t, logger.contains("Dropping publish result"))
+}
+
+func Test_AsyncAckBlockCanceled(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		AsyncAckPolicy: AsyncAckBlock,
+	})
+	defer c.disconnect()
+
+	result := make(chan *PublishResult)
+	_, cancel, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+
+	// the blocked delivery gives up once canceled, the channel can be closed safely
+	cancel()
+	close(result)
+	time.Sleep(100 * time.Millisecond)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	// tokenExpiryMargin is the time before the expiry after which a cached token isn't used anymore
+	tokenExpiryMargin = 10 * time.Second
+	// tokenRefreshRetryDelay is the delay before retrying a failed background refresh
+	tokenRefreshRetryDelay = 5 * time.Second
+)
+
+// tokenCache caches the token of Config.AuthTokenProviderWithExpiry until it's about to expire
+type tokenCache struct {
+	provider   func() ([]byte, time.Time, error)
+	token      []byte
+	obtainedAt time.Time
+	expiresAt  time.Time // zero if the token doesn't expire
+	sync.Mutex
+}
+
+// get returns the cached token, the token is refreshed if it's about to expire
+func (t *tokenCache) get() ([]byte, error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.token != nil && (t.expiresAt.IsZero() || time.Now().Before(t.expiresAt.Add(-tokenExpiryMargin))) {
+		return t.token, nil
+	}
+	return t.refreshLocked()
+}
+
+// refresh obtains a new token from the provider
+func (t *tokenCache) refresh() ([]byte, error) {
+	t.Lock()
+	defer t.Unlock()
+	return t.refreshLocked()
+}
+
+func (t *tokenCache) refreshLocked() ([]byte, error) {
+	token, expiresAt, err := t.provider()
+	if err != nil {
+		return nil, err
+	}
+	t.token = token
+	t.obtainedAt = time.Now()
+	t.expiresAt = expiresAt
+	return token, nil
+}
+
+// refreshAt returns the time at which the token should be refreshed in the background, once 80%
+// of its lifetime passed. ok is false if the token doesn't expire.
+func (t *tokenCache) refreshAt() (at time.Time, ok bool) {
+	t.Lock()
+	defer t.Unlock()
+	if t.token == nil {
+		return time.Now(), true
+	}
+	if t.expiresAt.IsZero() {
+		return time.Time{}, false
+	}
+	return t.obtainedAt.Add(t.expiresAt.Sub(t.obtainedAt) * 4 / 5), true
+}
+
+// tokenRefresher refreshes the auth token before it expires, until the connection is closed
+func (c *internalConnection) tokenRefresher() {
+	defer c.wg.Done()
+	for {
+		var wait <-chan time.Time
+		if at, ok := c.tokens.refreshAt(); ok {
+			wait = time.After(time.Until(at))
+		}
+		select {
+		case <-wait:
+			if _, err := c.tokens.refresh(); err != nil {
+				c.logger().Warnf("Failed to refresh the auth token, retrying in %v: %v", tokenRefreshRetryDelay, err)
+				select {
+				case <-time.After(tokenRefreshRetryDelay):
+				case <-c.closed:
+					return
+				}
+				continue
+			}
+			c.logger().Debugf("Refreshed the auth token")
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// restRequest sends the REST request built by send with the auth header set along with
+// Config.RestHeaders and Config.UserAgent, op describes the request in the errors. If the server
+// responds with 401 Unauthorized and the token is provided by Config.AuthTokenProviderWithExpiry,
+// the token is refreshed and the request is sent once more.
+func (c *internalConnection) restRequest(op string, send func(r *resty.Request) (*resty.Response, error)) (*resty.Response, error) {
+	authValue, err := c.authHeader.provider()
+	if err != nil {
+		c.logger().Errorf("Failed to obtain auth header: %v", err)
+		return nil, fmt.Errorf("failed to obtain auth header: %w", err)
+	}
+	resp, err := send(c.newRestRequest(authValue))
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if resp.StatusCode() != http.StatusUnauthorized || c.tokens == nil {
+		return resp, nil
+	}
+
+	c.logger().Infof("Received unexpected response '%s', refreshing the auth token", resp.Status())
+	authValue, err = c.tokens.refresh()
+	if err != nil {
+		c.logger().Errorf("Failed to refresh the auth token: %v", err)
+		return resp, nil
+	}
+	resp, err = send(c.newRestRequest(authValue))
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,102 @@
+package pubsub
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+// countingTokenProvider returns token-1, token-2, ... expiring after lifetime
+func countingTokenProvider(calls *int32, lifetime time.Duration) func() ([]byte, time.Time, error) {
+	return func() ([]byte, time.Time, error) {
+		n := atomic.AddInt32(calls, 1)
+		return []byte("token-" + strconv.Itoa(int(n))), time.Now().Add(lifetime), nil
+	}
+}
+
+func Test_AuthTokenCached(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ValidAuthToken: func(token string) bool {
+			return token == "token-1"
+		},
+	})
+	defer s.Close()
+
+	var calls int32
+	c := newTestConnection(t, s, Config{
+		AuthTokenProviderWithExpiry: countingTokenProvider(&calls, time.Hour),
+	})
+	defer c.disconnect()
+
+	for _, stream := range []string{"stream-1", "stream-2", "stream-3"} {
+		_, err := c.subscribe(stream, "", func(error, string, map[string]string, []byte) {})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func Test_AuthTokenBackgroundRefresh(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	defaultMargin := tokenExpiryMargin
+	tokenExpiryMargin = 0
+	defer func() { tokenExpiryMargin = defaultMargin }()
+
+	var calls int32
+	c := newTestConnection(t, s, Config{
+		AuthTokenProviderWithExpiry: countingTokenProvider(&calls, 200*time.Millisecond),
+	})
+	defer c.disconnect()
+
+	// the token is refreshed before it expires without any request
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) >= 3
+	}, time.Second, 10*time.Millisecond)
+	token, err := c.authHeader.provider()
+	require.NoError(t, err)
+	require.Equal(t, "token-"+strconv.Itoa(int(atomic.LoadInt32(&calls))), string(token))
+}
+
+func Test_AuthTokenRefreshOnUnauthorized(t *testing.T) {
+	var valid atomic.Value
+	valid.Store(func(token string) bool { return token != "token-1" })
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ValidAuthToken: func(token string) bool {
+			return valid.Load().(func(string) bool)(token)
+		},
+	})
+	defer s.Close()
+
+	// the first token is rejected when connecting
+	var calls int32
+	c := newTestConnection(t, s, Config{
+		AuthTokenProviderWithExpiry: countingTokenProvider(&calls, time.Hour),
+	})
+	defer c.disconnect()
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the REST request is retried with a new token
+	valid.Store(func(token string) bool { return token != "token-2" })
+	_, err := c.subscribe("stream-1", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// the REST request is retried only once
+	valid.Store(func(token string) bool { return false })
+	_, err = c.subscribe("stream-2", "", func(error, string, map[string]string, []byte) {})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "401")
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/log"
+	"github.com/google/uuid"
+)
+
+// Headers of the messages of a payload published with PublishChunked
+const (
+	// HeaderChunkID identifies the payload the chunk belongs to
+	HeaderChunkID = "chunk-id"
+	// HeaderChunkIndex is the position of the chunk in the payload, starting at 0
+	HeaderChunkIndex = "chunk-index"
+	// HeaderChunkLast is set to "true" on the last chunk of the payload
+	HeaderChunkLast = "chunk-last"
+)
+
+var defaultChunkSize = 64 * 1024
+
+// ReaderCallback is the callback of SubscribeReader. payload yields the payload of the message,
+// which is reassembled from its chunks as they're consumed for a payload published with
+// PublishChunked. headers are the headers of the first chunk.
+type ReaderCallback func(err error, id string, headers map[string]string, payload io.Reader)
+
+// PublishChunked publishes the payload read from r to the stream as a sequence of messages of up
+// to chunkSize bytes, which SubscribeReader reassembles. Every chunk carries the headers along with
+// the chunk headers. The chunks are published one at a time, each waiting for the response of the
+// previous one, so the payload is never held in memory as a whole. The ID of the chunked payload
+// is returned. If chunkSize isn't positive, 64 KiB chunks are published.
+func (c *internalConnection) PublishChunked(ctx context.Context, stream string, headers map[string]string, r io.Reader, chunkSize int) (string, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	id := uuid.NewString()
+	buf := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := io.ReadFull(r, buf)
+	for index := 0; ; index++ {
+		switch err {
+		case nil, io.ErrUnexpectedEOF:
+		case io.EOF:
+			// empty payload, published as a single empty chunk
+		default:
+			return id, fmt.Errorf("failed to read chunk %d: %w", index, err)
+		}
+		// read ahead to know if the chunk is the last one
+		last := err != nil
+		var nextN int
+		var nextErr error
+		if !last {
+			nextN, nextErr = io.ReadFull(r, next)
+			last = nextErr == io.EOF
+		}
+
+		h := make(map[string]string, len(headers)+3)
+		for k, v := range headers {
+			h[k] = v
+		}
+		h[HeaderChunkID] = id
+		h[HeaderChunkIndex] = strconv.Itoa(index)
+		if last {
+			h[HeaderChunkLast] = "true"
+		}
+		result, pubErr := c.Publish(ctx, stream, h, buf[:n])
+		if pubErr == nil {
+			pubErr = result.Error
+		}
+		if pubErr != nil {
+			return id, fmt.Errorf("failed to publish chunk %d: %w", index, pubErr)
+		}
+		if last {
+			return id, nil
+		}
+		buf, next = next, buf
+		n, err = nextN, nextErr
+	}
+}
+
+// SubscribeReader subscribes to a DxHub Pubsub Stream and delivers the payloads as an io.Reader.
+// The chunks of a payload published with PublishChunked are streamed to a single invocation of
+// handler, in a separate goroutine, as they're consumed: consuming waits until handler has read
+// the chunk, so the payload is never buffered as a whole. Reading fails with ErrInvalidChunk if
+// a chunk is missing. Other messages are delivered as is. A *SubscriptionError is returned on
+// failure.
+func (c *Connection) SubscribeReader(stream string, handler ReaderCallback, opts ...SubscribeOption) error {
+	return c.Subscribe(stream, readerCallback(c.logger(), handler), opts...)
+}
+
+// chunkedPayload is a payload being reassembled by readerCallback
+type chunkedPayload struct {
+	w    *io.PipeWriter
+	next int // index of the next chunk
+}
+
+// readerCallback returns the SubscriptionCallback reassembling the chunked payloads for
+// SubscribeReader. The callbacks of a subscription are invoked sequentially, so the payloads
+// don't need to be protected by a lock.
+func readerCallback(logger log.SDKLogger, handler ReaderCallback) SubscriptionCallback {
+	payloads := map[string]*chunkedPayload{}
+	return func(err error, id string, headers map[string]string, payload []byte) {
+		chunkID, ok := headers[HeaderChunkID]
+		if err != nil || !ok {
+			handler(err, id, headers, bytes.NewReader(payload))
+			return
+		}
+		index, convErr := strconv.Atoi(headers[HeaderChunkIndex])
+		if convErr != nil {
+			index = -1
+		}
+
+		p := payloads[chunkID]
+		if p == nil {
+			if index != 0 {
+				logger.Warnf("Dropping chunk %s of payload %s, the previous chunks are missing", headers[HeaderChunkIndex], chunkID)
+				return
+			}
+			r, w := io.Pipe()
+			p = &chunkedPayload{w: w}
+			payloads[chunkID] = p
+			go func() {
+				handler(nil, id, headers, r)
+				// unblocks the writes of the chunks the handler didn't read
+				r.Close()
+			}()
+		}
+		if index != p.next {
+			p.w.CloseWithError(fmt.Errorf("%w: expected chunk %d of payload %s, got %s", ErrInvalidChunk, p.next, chunkID, headers[HeaderChunkIndex]))
+			delete(payloads, chunkID)
+			return
+		}
+		p.next++
+		if _, err := p.w.Write(payload); err != nil && err != io.ErrClosedPipe {
+			logger.Warnf("Failed to stream chunk %d of payload %s: %v", index, chunkID, err)
+		}
+		if headers[HeaderChunkLast] == "true" {
+			p.w.Close()
+			delete(payloads, chunkID)
+		}
+	}
+}
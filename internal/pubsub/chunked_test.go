@@ -0,0 +1,90 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscribeReader(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	type delivery struct {
+		headers map[string]string
+		payload []byte
+	}
+	deliveries := make(chan delivery, 2)
+	err := c.SubscribeReader("test-stream", func(err error, _ string, headers map[string]string, payload io.Reader) {
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(payload)
+		require.NoError(t, err)
+		deliveries <- delivery{headers, b}
+	})
+	require.NoError(t, err)
+
+	// 10 chunks, the last one partial
+	payload := bytes.Repeat([]byte("0123456789"), 95)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	id, err := c.PublishChunked(ctx, "test-stream", map[string]string{"name": "file.txt"}, bytes.NewReader(payload), 100)
+	require.NoError(t, err)
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("not chunked"))
+	require.NoError(t, err)
+
+	// the reassembled payload is delivered concurrently with the following messages
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-deliveries:
+			if d.headers[HeaderChunkID] == "" {
+				require.Equal(t, []byte("not chunked"), d.payload)
+				continue
+			}
+			require.Equal(t, id, d.headers[HeaderChunkID])
+			require.Equal(t, "file.txt", d.headers["name"])
+			require.Equal(t, payload, d.payload)
+		case <-time.After(2 * time.Second):
+			t.Fatal("payload not received")
+		}
+	}
+}
+
+func Test_ReaderCallbackMissingChunk(t *testing.T) {
+	logger := &testLogger{}
+	readErr := make(chan error, 1)
+	callback := readerCallback(logger, func(err error, _ string, _ map[string]string, payload io.Reader) {
+		_, err = ioutil.ReadAll(payload)
+		readErr <- err
+	})
+	chunk := func(index string) map[string]string {
+		return map[string]string{HeaderChunkID: "payload-1", HeaderChunkIndex: index}
+	}
+
+	// a payload without its first chunk is dropped
+	callback(nil, "msg-1", map[string]string{HeaderChunkID: "payload-0", HeaderChunkIndex: "1"}, []byte("b"))
+	require.True(t, logger.contains("the previous chunks are missing"))
+
+	callback(nil, "msg-2", chunk("0"), []byte("a"))
+	callback(nil, "msg-3", chunk("2"), []byte("c"))
+	select {
+	case err := <-readErr:
+		require.True(t, errors.Is(err, ErrInvalidChunk))
+	case <-time.After(time.Second):
+		t.Fatal("reading didn't fail")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultCircuitBreakerThreshold = 10
+	defaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
+// CircuitState is the state of the circuit breaker of a subscription, which suspends the consume
+// requests of a stream that keeps failing, see Config.CircuitBreakerThreshold
+type CircuitState int32
+
+const (
+	// CircuitClosed consumes normally
+	CircuitClosed CircuitState = iota
+	// CircuitOpen suspends the consume requests for Config.CircuitBreakerCooldown
+	CircuitOpen
+	// CircuitHalfOpen sends a single consume request to test whether the stream recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker tracks the consecutive consume failures of a subscription. It's only updated by
+// the subscriber goroutine, the state can be read concurrently.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int   // consecutive consume failures
+	state     int32 // CircuitState
+}
+
+func newCircuitBreaker(config Config) *circuitBreaker {
+	return &circuitBreaker{threshold: config.CircuitBreakerThreshold, cooldown: config.CircuitBreakerCooldown}
+}
+
+// State returns the current state of the breaker
+func (b *circuitBreaker) State() CircuitState {
+	return CircuitState(atomic.LoadInt32(&b.state))
+}
+
+func (b *circuitBreaker) set(state CircuitState) {
+	atomic.StoreInt32(&b.state, int32(state))
+}
+
+// failed records a consume failure and returns true if it opens the closed circuit. A failed test
+// request of the half-open circuit opens it again.
+func (b *circuitBreaker) failed() bool {
+	b.failures++
+	switch b.State() {
+	case CircuitClosed:
+		if b.failures >= b.threshold {
+			b.set(CircuitOpen)
+			return true
+		}
+	case CircuitHalfOpen:
+		b.set(CircuitOpen)
+	}
+	return false
+}
+
+// succeeded records a successful consume request and returns true if it closes the circuit
+func (b *circuitBreaker) succeeded() bool {
+	b.failures = 0
+	if b.State() == CircuitClosed {
+		return false
+	}
+	b.set(CircuitClosed)
+	return true
+}
+
+// trial half-opens the circuit once the cool-down of the open circuit elapsed, before the next
+// consume request
+func (b *circuitBreaker) trial() {
+	if b.State() == CircuitOpen {
+		b.set(CircuitHalfOpen)
+	}
+}
+
+// circuitState returns the state of the circuit breaker of the subscription of the stream, see
+// Subscription.CircuitState
+func (c *internalConnection) circuitState(stream string) CircuitState {
+	c.subs.Lock()
+	sub, ok := c.subs.table[stream]
+	c.subs.Unlock()
+	if !ok || sub.breaker == nil {
+		return CircuitClosed
+	}
+	return sub.breaker.State()
+}
+
+// CircuitState returns the state of the circuit breaker of the subscription, CircuitClosed if the
+// stream isn't subscribed anymore. The breaker starts closed on every reconnect.
+func (s *Subscription) CircuitState() CircuitState {
+	return s.conn.current().circuitState(s.stream)
+}
@@ -0,0 +1,82 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(Config{CircuitBreakerThreshold: 2, CircuitBreakerCooldown: time.Second})
+	require.Equal(t, CircuitClosed, b.State())
+	require.False(t, b.failed())
+	require.False(t, b.succeeded())
+	require.False(t, b.failed())
+	require.True(t, b.failed())
+	require.Equal(t, CircuitOpen, b.State())
+
+	// a failed test request opens the circuit again without notifying
+	b.trial()
+	require.Equal(t, CircuitHalfOpen, b.State())
+	require.False(t, b.failed())
+	require.Equal(t, CircuitOpen, b.State())
+
+	b.trial()
+	require.True(t, b.succeeded())
+	require.Equal(t, CircuitClosed, b.State())
+	require.Equal(t, "closed", b.State().String())
+}
+
+func Test_SubscriptionCircuit(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeFailures:   4,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval:            10 * time.Millisecond,
+		ConsumeErrorThreshold:   1,
+		MaxConsumeBackoff:       10 * time.Millisecond,
+		CircuitBreakerThreshold: 3,
+		CircuitBreakerCooldown:  200 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	errs := make(chan error, 10)
+	received := make(chan string, 1)
+	err := c.Subscribe("circuit-stream", func(err error, id string, _ map[string]string, _ []byte) {
+		if err != nil {
+			errs <- err
+			return
+		}
+		received <- id
+	})
+	require.NoError(t, err)
+	sub := c.Subscription("circuit-stream")
+	require.NotNil(t, sub)
+
+	// opens after the third error
+	require.Eventually(t, func() bool { return sub.CircuitState() == CircuitOpen }, time.Second, 5*time.Millisecond)
+	require.True(t, test.PublishRaw("circuit-stream", rpc.PublishParams{MsgID: "msg-1", Payload: base64.StdEncoding.EncodeToString([]byte("payload"))}))
+
+	// the fourth request fails after the first cool-down, the fifth one closes the circuit
+	select {
+	case id := <-received:
+		require.Equal(t, "msg-1", id)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+	require.Equal(t, CircuitClosed, sub.CircuitState())
+
+	// the errors before the opening are delivered, then a single ErrCircuitOpen
+	require.Len(t, errs, 3)
+	require.NotErrorIs(t, <-errs, ErrCircuitOpen)
+	require.NotErrorIs(t, <-errs, ErrCircuitOpen)
+	require.ErrorIs(t, <-errs, ErrCircuitOpen)
+}
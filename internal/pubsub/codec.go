@@ -0,0 +1,82 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	json "github.com/goccy/go-json"
+)
+
+// Codec serializes the values published by PublishObject and deserializes the payloads consumed
+// by SubscribeObject, e.g. with protobuf or msgpack. Implementations must be safe for concurrent
+// use.
+type Codec interface {
+	// Marshal returns the encoding of v
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into v, which is a value returned by the factory of SubscribeObject
+	Unmarshal(data []byte, v interface{}) error
+
+	// ContentType is the media type of the encoding, set as the content-type header of the
+	// published messages
+	ContentType() string
+}
+
+// JSONCodec is the Codec that encodes the values with JSON, the default of Config.Codec
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+// PublishObject publishes the encoding of v with Config.Codec to the stream like Publish, with the
+// content-type header set to the media type of the codec.
+func (c *Connection) PublishObject(ctx context.Context, stream string, v interface{}) (*PublishResult, error) {
+	codec := c.config.Codec
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	return c.Publish(ctx, stream, map[string]string{HeaderContentType: codec.ContentType()}, payload)
+}
+
+// SubscribeObject subscribes to a DxHub Pubsub Stream like Subscribe and decodes the payload of
+// every message with Config.Codec into a new value returned by factory, which must be a pointer,
+// for handler. A message that can't be decoded, or whose content-type header is set to another
+// media type than the codec's, is delivered with a nil value and an error, which wraps
+// ErrUnsupportedContentType in the latter case.
+func (c *Connection) SubscribeObject(stream string, factory func() interface{}, handler func(v interface{}, headers map[string]string, err error), opts ...SubscribeOption) error {
+	return c.Subscribe(stream, objectCallback(c.config.Codec, factory, handler), opts...)
+}
+
+// objectCallback returns the SubscriptionCallback that decodes the payloads with the codec for
+// handler
+func objectCallback(codec Codec, factory func() interface{}, handler func(v interface{}, headers map[string]string, err error)) SubscriptionCallback {
+	return func(err error, id string, headers map[string]string, payload []byte) {
+		if err != nil {
+			handler(nil, headers, err)
+			return
+		}
+		if ct, ok := headers[HeaderContentType]; ok && ct != codec.ContentType() {
+			handler(nil, headers, fmt.Errorf("message %s: %w: %s", id, ErrUnsupportedContentType, ct))
+			return
+		}
+		v := factory()
+		if err := codec.Unmarshal(payload, v); err != nil {
+			handler(nil, headers, fmt.Errorf("failed to unmarshal message %s: %w", id, err))
+			return
+		}
+		handler(v, headers, nil)
+	}
+}
@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+// counterCodec encodes *int values as decimal text
+type counterCodec struct{}
+
+func (counterCodec) Marshal(v interface{}) ([]byte, error) {
+	n, ok := v.(int)
+	if !ok {
+		return nil, errors.New("not an int")
+	}
+	return []byte(strconv.Itoa(n)), nil
+}
+
+func (counterCodec) Unmarshal(data []byte, v interface{}) error {
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		return err
+	}
+	*v.(*int) = n
+	return nil
+}
+
+func (counterCodec) ContentType() string {
+	return "text/x-counter"
+}
+
+type objectDelivery struct {
+	v       interface{}
+	headers map[string]string
+	err     error
+}
+
+func receiveObject(t *testing.T, ch chan objectDelivery) objectDelivery {
+	select {
+	case d := <-ch:
+		return d
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+	return objectDelivery{}
+}
+
+func Test_Codec(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// JSON by default
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+	deliveries := make(chan objectDelivery, 2)
+	handler := func(v interface{}, headers map[string]string, err error) {
+		deliveries <- objectDelivery{v, headers, err}
+	}
+	err := c.SubscribeObject("codec-json", func() interface{} { return &testEvent{} }, handler)
+	require.NoError(t, err)
+	_, err = c.PublishObject(ctx, "codec-json", testEvent{Name: "a", Count: 1})
+	require.NoError(t, err)
+	d := receiveObject(t, deliveries)
+	require.NoError(t, d.err)
+	require.Equal(t, &testEvent{Name: "a", Count: 1}, d.v)
+	require.Equal(t, ContentTypeJSON, d.headers[HeaderContentType])
+
+	// custom codec
+	custom := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond, Codec: counterCodec{}})
+	defer custom.Disconnect()
+	err = custom.SubscribeObject("codec-custom", func() interface{} { return new(int) }, handler)
+	require.NoError(t, err)
+	_, err = custom.PublishObject(ctx, "codec-custom", 42)
+	require.NoError(t, err)
+	d = receiveObject(t, deliveries)
+	require.NoError(t, d.err)
+	require.Equal(t, 42, *d.v.(*int))
+	require.Equal(t, "text/x-counter", d.headers[HeaderContentType])
+
+	_, err = custom.PublishObject(ctx, "codec-custom", "not an int")
+	require.Error(t, err)
+
+	// messages of another codec are rejected
+	_, err = c.PublishObject(ctx, "codec-custom", 7)
+	require.NoError(t, err)
+	d = receiveObject(t, deliveries)
+	require.ErrorIs(t, d.err, ErrUnsupportedContentType)
+	require.Nil(t, d.v)
+
+	// payloads that can't be decoded
+	_, err = custom.Publish(ctx, "codec-custom", nil, []byte("seven"))
+	require.NoError(t, err)
+	d = receiveObject(t, deliveries)
+	require.Error(t, d.err)
+	require.Nil(t, d.v)
+}
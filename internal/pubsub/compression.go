@@ -0,0 +1,128 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression represents the compression applied to published payloads
+type Compression int
+
+const (
+	// CompressionNone publishes payloads as is
+	CompressionNone Compression = iota
+	// CompressionGzip gzip compresses payloads before publishing
+	CompressionGzip
+	// CompressionZstd zstd compresses payloads before publishing
+	CompressionZstd
+)
+
+const (
+	headerContentEncoding = "content-encoding"
+	contentEncodingGzip   = "gzip"
+	contentEncodingZstd   = "zstd"
+)
+
+// supportedCompressions are the content encodings the subscriptions can decompress, advertised to
+// the server when subscribing so that it may compress the consumed payloads
+var supportedCompressions = []string{contentEncodingGzip, contentEncodingZstd}
+
+// the zstd encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// compress compresses the payload and sets the content-encoding header accordingly. headers may
+// be returned as a new map, the supplied map is never modified. Empty payloads and payloads that
+// already carry a content-encoding header are returned unchanged.
+func compress(compression Compression, headers map[string]string, payload []byte) (map[string]string, []byte, error) {
+	if compression == CompressionNone || len(payload) == 0 {
+		return headers, payload, nil
+	}
+	if _, ok := headers[headerContentEncoding]; ok {
+		// already encoded by the caller
+		return headers, payload, nil
+	}
+	var encoding string
+	var compressed []byte
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, nil, fmt.Errorf("failed to compress payload: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, nil, fmt.Errorf("failed to compress payload: %v", err)
+		}
+		encoding, compressed = contentEncodingGzip, buf.Bytes()
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, nil, fmt.Errorf("failed to compress payload: %v", err)
+		}
+		encoding, compressed = contentEncodingZstd, zstdEncoder.EncodeAll(payload, nil)
+	default:
+		return nil, nil, fmt.Errorf("unknown compression: %d", compression)
+	}
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[headerContentEncoding] = encoding
+	return h, compressed, nil
+}
+
+// decompress decompresses the payload based on its content-encoding header. The content-encoding
+// header is removed from headers once the payload is decompressed. A payload with an unknown
+// encoding is returned as is with known set to false, the header is left to the application.
+func decompress(headers map[string]string, payload []byte) (p []byte, known bool, err error) {
+	switch headers[headerContentEncoding] {
+	case "":
+		return payload, true, nil
+	case contentEncodingGzip:
+		if len(payload) > 0 {
+			r, err := gzip.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				return nil, true, fmt.Errorf("failed to decompress payload: %v", err)
+			}
+			defer r.Close()
+			if payload, err = io.ReadAll(r); err != nil {
+				return nil, true, fmt.Errorf("failed to decompress payload: %v", err)
+			}
+		}
+	case contentEncodingZstd:
+		if len(payload) > 0 {
+			if err := initZstd(); err != nil {
+				return nil, true, fmt.Errorf("failed to decompress payload: %v", err)
+			}
+			if payload, err = zstdDecoder.DecodeAll(payload, nil); err != nil {
+				return nil, true, fmt.Errorf("failed to decompress payload: %v", err)
+			}
+		}
+	default:
+		return payload, false, nil
+	}
+	delete(headers, headerContentEncoding)
+	return payload, true, nil
+}
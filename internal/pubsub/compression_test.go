@@ -0,0 +1,168 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CompressionRoundTrip(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	for _, tc := range []struct {
+		name        string
+		compression Compression
+	}{
+		{name: "none", compression: CompressionNone},
+		{name: "gzip", compression: CompressionGzip},
+		{name: "zstd", compression: CompressionZstd},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConnection(t, s, Config{
+				PollInterval: 10 * time.Millisecond,
+				Compression:  tc.compression,
+			})
+			defer c.disconnect()
+
+			type message struct {
+				headers map[string]string
+				payload []byte
+			}
+			stream := "compression-" + tc.name
+			subCh := make(chan message, 3)
+			_, err := c.subscribe(stream, "",
+				func(e error, _ string, headers map[string]string, payload []byte) {
+					require.NoError(t, e)
+					subCh <- message{headers: headers, payload: payload}
+				})
+			require.NoError(t, err)
+
+			var gzipped bytes.Buffer
+			w := gzip.NewWriter(&gzipped)
+			_, _ = w.Write([]byte("already compressed"))
+			_ = w.Close()
+
+			large := []byte(strings.Repeat("compressible payload ", 1000))
+			published := []message{
+				{headers: nil, payload: large},
+				{headers: map[string]string{"key": "value"}, payload: []byte{}},
+				{headers: map[string]string{headerContentEncoding: contentEncodingGzip}, payload: gzipped.Bytes()},
+			}
+			for _, m := range published {
+				ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+				r, err := c.Publish(ctx, stream, m.headers, m.payload)
+				cancel()
+				require.NoError(t, err)
+				require.NoError(t, r.Error)
+			}
+
+			expected := [][]byte{large, {}, []byte("already compressed")}
+			for _, e := range expected {
+				select {
+				case m := <-subCh:
+					require.Equal(t, len(e), len(m.payload))
+					require.Equal(t, string(e), string(m.payload))
+					_, ok := m.headers[headerContentEncoding]
+					require.False(t, ok, "content-encoding header should be removed")
+				case <-time.After(time.Second):
+					require.FailNow(t, "Consume timed out")
+				}
+			}
+			// caller's headers must not be modified
+			require.Equal(t, map[string]string{"key": "value"}, published[1].headers)
+		})
+	}
+}
+
+func Test_CompressionNegotiation(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.disconnect()
+
+	type message struct {
+		headers map[string]string
+		payload []byte
+	}
+	subCh := make(chan message, 3)
+	_, err := c.subscribe("negotiated-stream", "", func(e error, _ string, headers map[string]string, payload []byte) {
+		require.NoError(t, e)
+		subCh <- message{headers: headers, payload: payload}
+	})
+	require.NoError(t, err)
+	req, ok := test.GetSubscriptionRequest("negotiated-stream")
+	require.True(t, ok)
+	require.Equal(t, []string{"gzip", "zstd"}, req.SupportedCompressions)
+
+	// raw payloads aren't decompressed, no compression is advertised
+	_, err = c.subscribe("negotiated-raw-stream", "", func(error, string, map[string]string, []byte) {}, WithRawPayloads())
+	require.NoError(t, err)
+	req, ok = test.GetSubscriptionRequest("negotiated-raw-stream")
+	require.True(t, ok)
+	require.Empty(t, req.SupportedCompressions)
+
+	// payloads compressed by the server
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	_, _ = w.Write([]byte("gzip payload"))
+	_ = w.Close()
+	require.NoError(t, initZstd())
+	zstded := zstdEncoder.EncodeAll([]byte("zstd payload"), nil)
+	encode := base64.StdEncoding.EncodeToString
+	require.True(t, test.PublishRaw("negotiated-stream",
+		rpc.PublishParams{MsgID: "msg-1", Payload: encode(gzipped.Bytes()), Headers: map[string]string{headerContentEncoding: "gzip"}},
+		rpc.PublishParams{MsgID: "msg-2", Payload: encode(zstded), Headers: map[string]string{headerContentEncoding: "zstd"}},
+		rpc.PublishParams{MsgID: "msg-3", Payload: encode([]byte("br payload")), Headers: map[string]string{headerContentEncoding: "br"}}))
+
+	for _, e := range []message{
+		{payload: []byte("gzip payload"), headers: map[string]string{}},
+		{payload: []byte("zstd payload"), headers: map[string]string{}},
+		// unknown encodings are delivered as is
+		{payload: []byte("br payload"), headers: map[string]string{headerContentEncoding: "br"}},
+	} {
+		select {
+		case m := <-subCh:
+			require.Equal(t, string(e.payload), string(m.payload))
+			require.Equal(t, e.headers, m.headers)
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+	}
+}
+
+func Test_CompressionNone(t *testing.T) {
+	headers := map[string]string{"key": "value"}
+	h, p, err := compress(CompressionNone, headers, []byte("payload"))
+	require.NoError(t, err)
+	require.Equal(t, headers, h)
+	require.Equal(t, []byte("payload"), p)
+
+	h, p, err = compress(CompressionGzip, headers, nil)
+	require.NoError(t, err)
+	require.Equal(t, headers, h)
+	require.Empty(t, p)
+}
+
+func Test_DecompressInvalidPayload(t *testing.T) {
+	for _, encoding := range []string{contentEncodingGzip, contentEncodingZstd} {
+		_, known, err := decompress(map[string]string{headerContentEncoding: encoding}, []byte("not compressed"))
+		require.True(t, known)
+		require.Error(t, err)
+	}
+}
@@ -51,12 +51,15 @@ package pubsub
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	rpc "github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
@@ -64,27 +67,37 @@ import (
 	"github.com/cisco-pxgrid/cloud-sdk-go/log"
 	"github.com/cisco-pxgrid/websocket"
 	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 )
 
 var (
-	defaultTimeout      = 15 * time.Second
-	pingPeriod          = 55 * time.Second
-	pongWait            = 60 * time.Second
-	defaultPollInterval = 1 * time.Second
-	handlersExpiration  = 3 * time.Minute
-	webSocketScheme     = "wss"
-	httpScheme          = "https"
-	apiPaths            = struct {
+	defaultTimeout                  = 15 * time.Second
+	pingPeriod                      = 55 * time.Second
+	pongWait                        = 60 * time.Second
+	defaultPollInterval             = 1 * time.Second
+	handlersExpiration              = 3 * time.Minute
+	defaultBlockingHandlerThreshold = 1 * time.Second
+	httpScheme                      = "https"
+	apiPaths                        = struct {
 		subscriptions string
+		streams       string
 		pubsub        string
 	}{
 		subscriptions: "/api/dxhub/v1/registry/subscriptions",
+		streams:       "/api/dxhub/v1/registry/streams",
 		pubsub:        "/api/v2/pubsub",
 	}
 	maxMessageSize int64 = 51 * 1024 * 1024 // 51mb. DxHub max message size is 50mb. An extra mb as a buffer.
 
 )
 
+// webSocketSchemes maps the supported HTTP schemes to the scheme of the WebSocket connection
+var webSocketSchemes = map[string]string{
+	"https": "wss",
+	"http":  "ws",
+}
+
 const (
 	headerStrApiKey    = "X-Api-Key"
 	headerStrAuthToken = "X-Auth-Token"
@@ -95,9 +108,20 @@ type Config struct {
 	// GroupID specifies the group ID of the PubSub client
 	GroupID string
 
+	// EphemeralGroup makes NewConnection append a random suffix to GroupID so that short-lived
+	// clients don't share consumer group state with anyone else. Subscriptions of an ephemeral
+	// group are always deleted from the server when the connection is closed, including on
+	// consume timeouts, and are re-created on reconnect.
+	EphemeralGroup bool
+
 	// Domain should be set to the cloud domain of the region where Application wants to connect to.
 	Domain string
 
+	// Scheme is the scheme of the REST API, either "https" or "http". The WebSocket connection uses
+	// "wss" or "ws" respectively. "http" is meant for tests and local servers.
+	// Default is "https".
+	Scheme string
+
 	// APIKeyProvider returns the API Key for the Application. Either APIKeyProvider or the
 	// AuthTokenProvider must be set in the config. APIKeyProvider takes precedence over the
 	// AuthTokenProvider.
@@ -107,20 +131,340 @@ type Config struct {
 	// or the AuthTokenProvider must be set in the config.
 	AuthTokenProvider func() ([]byte, error)
 
+	// AuthTokenProviderWithExpiry returns a short-lived Auth Token along with its expiry, it can be
+	// set instead of AuthTokenProvider. The token is cached and refreshed in the background before
+	// it expires, a zero expiry means the token doesn't expire. When a REST request is rejected
+	// with 401 Unauthorized, the token is refreshed and the request is retried once.
+	// AuthTokenProviderWithExpiry takes precedence over AuthTokenProvider.
+	AuthTokenProviderWithExpiry func() (token []byte, expiresAt time.Time, err error)
+
 	// PollInterval defines the interval between consecutive read requests to the server.
 	// Default is 1 second.
 	PollInterval time.Duration
 
+	// MinPollInterval and MaxPollInterval enable adaptive polling when MaxPollInterval is set. The
+	// interval drops to MinPollInterval when a consume response contains messages and doubles with
+	// every empty consume response, starting from PollInterval, up to MaxPollInterval.
+	// Default is 0 for both, which means the interval is always PollInterval.
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+
+	// PollJitter randomizes every sleep between consume requests by up to the given fraction of the
+	// poll interval, e.g. 0.1 for ±10%, so that subscribers sharing the same interval spread their
+	// requests. Must be in the range [0, 1). Default is 0, which means no jitter.
+	PollJitter float64
+
+	// ConsumeBatchSize is the maximum number of messages in each consume response, overridden per
+	// subscription by SubscribeOptions.BatchSize. Smaller batches reduce the latency of the callback,
+	// larger batches improve the throughput. A response that reaches the batch size is followed by
+	// the next consume request without waiting for the poll interval. The server caps the batch size
+	// at its own maximum, larger values return at most that many messages. Must not be negative.
+	// Default is 0, which means the server decides.
+	ConsumeBatchSize int
+
+	// CreditReplenishThreshold is the number of credits that must be available before a subscription
+	// with SubscribeOptions.InitialCredits sends a new consume request.
+	// Default is 0, which means half of the initial credits of the subscription.
+	CreditReplenishThreshold int
+
+	// NackRedeliveryDelay is the delay after which a nacked message is redelivered to the
+	// AckSubscriptionCallback. Redeliveries happen on the first poll after the delay.
+	// Default is 1 second.
+	NackRedeliveryDelay time.Duration
+
+	// MaxRedeliveries is the number of times a nacked message is redelivered. A message that is
+	// nacked once more is delivered with ErrRedeliveryLimit and isn't redelivered anymore.
+	// Default is 0, which means no limit.
+	MaxRedeliveries int
+
+	// AckMode determines when the consume context of a subscription advances. AckModeManual
+	// provides at-least-once delivery, see AckModeManual for details.
+	// Default is AckModeAuto.
+	AckMode AckMode
+
+	// AckTimeout is the time AckModeManual waits for the messages of a consumed batch to be acked
+	// before consuming the batch again.
+	// Default is 30 seconds.
+	AckTimeout time.Duration
+
+	// MinProtocolVersion is the minimum protocol version accepted by the connection. Connecting to
+	// a server that only supports older versions fails with ErrProtocolUnsupported, newer
+	// versions are negotiated whenever the server supports them.
+	// Default is ProtocolV1.
+	MinProtocolVersion int
+
+	// DebugDetectBlockingHandlers logs a warning whenever a subscription callback doesn't return
+	// within BlockingHandlerThreshold, to catch callbacks that block the subscriber, e.g. with
+	// blocking I/O. It's meant for development and shouldn't be enabled in production.
+	DebugDetectBlockingHandlers bool
+
+	// BlockingHandlerThreshold is the callback duration after which DebugDetectBlockingHandlers
+	// logs a warning.
+	// Default is 1 second.
+	BlockingHandlerThreshold time.Duration
+
+	// StreamDiscoveryInterval is the interval at which SubscribeDynamic lists the streams.
+	// Default is 30 seconds.
+	StreamDiscoveryInterval time.Duration
+
+	// IdleConnectionTimeout closes the connection once there have been no subscriptions, operations
+	// and activity for the timeout. The connection is reopened by the next operation, e.g. Publish.
+	// Default is 0, which means the connection is never closed for being idle.
+	IdleConnectionTimeout time.Duration
+
+	// SubscriptionSetupRetries is the number of times the creation and deletion of a subscription
+	// are retried with exponential backoff after a 5xx response or a network error. Retries stop
+	// early when the connection is closed or the next attempt would pass its context deadline.
+	// Default is 0, which means no retries.
+	SubscriptionSetupRetries int
+
+	// ConsumeErrorThreshold is the number of consecutive retryable consume errors, see IsRetryable,
+	// after which the error is delivered to the subscription callback. In the meantime the consume
+	// requests are retried with a delay that starts at the poll interval and doubles after each
+	// error up to MaxConsumeBackoff. The error is delivered again after every ConsumeErrorThreshold
+	// further errors. Other consume errors are delivered right away.
+	// Default is 3, 1 delivers every error.
+	ConsumeErrorThreshold int
+
+	// MaxConsumeBackoff caps the delay between the consume requests retried after retryable errors.
+	// Default is 5 seconds.
+	MaxConsumeBackoff time.Duration
+
+	// CircuitBreakerThreshold is the number of consecutive consume errors of a subscription after
+	// which its circuit breaker opens: the consume requests are suspended for
+	// CircuitBreakerCooldown and ErrCircuitOpen is delivered once to the subscription callback. A
+	// single consume request is sent after the cool-down, the circuit closes if it succeeds and
+	// opens again otherwise. The errors aren't delivered while the circuit isn't closed, see
+	// Subscription.CircuitState.
+	// Default is 10.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCooldown is the time the consume requests are suspended by an open circuit.
+	// Default is 30 seconds.
+	CircuitBreakerCooldown time.Duration
+
+	// DecodeErrorHandler is invoked instead of the subscription callback with the raw payload of a
+	// consumed message that can't be decoded, e.g. to route it to a dead-letter store. The error
+	// wraps ErrInvalidPayload.
+	// Default is nil, which means the callback is invoked with the error.
+	DecodeErrorHandler func(stream, msgID string, raw string, err error)
+
+	// PanicHandler is invoked when a subscription callback panics. The panic is recovered, logged
+	// with its stack trace and the subscription keeps delivering the following messages. A message
+	// delivered to an AckSubscriptionCallback that panics is nacked unless it's settled.
+	// Default is nil, which means the panic is only logged.
+	PanicHandler func(err *PanicError)
+
+	// SourceMetadata is added to the headers of every message published by Publish and
+	// PublishAsync, e.g. to identify the service, version and host that published the message.
+	// Headers supplied to the publish call with the same keys take precedence.
+	SourceMetadata map[string]string
+
+	// DefaultHeaders is added to the headers of every message published by Publish and
+	// PublishAsync, e.g. the tenant and app identity. They take precedence over SourceMetadata,
+	// headers supplied to the publish call with the same keys take precedence over them.
+	DefaultHeaders map[string]string
+
+	// StreamHeaders is added to the headers of every message published to the stream it's indexed
+	// by, taking precedence over DefaultHeaders for that stream. Headers supplied to the publish
+	// call with the same keys take precedence over them.
+	StreamHeaders map[string]map[string]string
+
+	// MaxPayloadBytes limits the size of a published payload. Publish and PublishAsync fail with
+	// ErrPayloadTooLarge without contacting the server if the payload is larger.
+	// Default is 0, which means no limit.
+	MaxPayloadBytes int
+
+	// MaxHeaderBytes limits the combined size of the keys and values of the headers of a published
+	// message. Publish and PublishAsync fail with ErrHeadersTooLarge without contacting the server
+	// if the headers are larger.
+	// Default is 0, which means no limit.
+	MaxHeaderBytes int
+
+	// Compression specifies the compression applied to the payloads by Publish and PublishAsync.
+	// Compressed payloads are marked with the content-encoding header and are decompressed
+	// transparently before being handed to the SubscriptionCallback. The subscriptions advertise
+	// the encodings they decompress, gzip and zstd, so that the server may compress the payloads
+	// it delivers.
+	// Default is CompressionNone.
+	Compression Compression
+
+	// PayloadEncoding is the base64 variant the payloads are encoded with by Publish and decoded
+	// with before being handed to the SubscriptionCallback. Publishers and subscribers of a stream
+	// must use the same encoding unless PayloadEncodingFallback is set.
+	// Default is PayloadEncodingStd.
+	PayloadEncoding PayloadEncoding
+
+	// PayloadEncodingFallback decodes consumed payloads with the other base64 variants if they
+	// can't be decoded with PayloadEncoding. A payload that's valid in more than one variant is
+	// always decoded with PayloadEncoding.
+	PayloadEncodingFallback bool
+
+	// Codec serializes the values of PublishObject and SubscribeObject.
+	// Default is JSONCodec.
+	Codec Codec
+
+	// Mode restricts the connection to publishing or subscribing. The operations it doesn't allow
+	// fail immediately, without contacting the server.
+	// Default is ModeReadWrite.
+	Mode Mode
+
+	// MaxInFlightPublishes limits the number of publishes waiting for their response from the
+	// server. Once reached, Publish waits for a response up to its context and PublishAsync fails
+	// with ErrTooManyInFlight.
+	// Default is 0, which means no limit.
+	MaxInFlightPublishes int
+
+	// PublishRateLimit is the maximum number of publishes per second. Publish waits until the rate
+	// allows the publish up to its context and fails with ErrRateLimited if the wait would exceed
+	// the deadline of the context, PublishAsync waits up to the closing of the connection. It can be
+	// changed at runtime with SetPublishRateLimit and is preserved across reconnects.
+	// Default is 0, which means no limit.
+	PublishRateLimit rate.Limit
+
+	// PublishBurst is the number of publishes allowed at once beyond PublishRateLimit.
+	// Default is 1.
+	PublishBurst int
+
+	// AckCorrelationTTL is the time after which a request whose response never arrives, e.g. a
+	// publish ack lost on a slow network, is evicted. The entry is evicted between AckCorrelationTTL
+	// and twice AckCorrelationTTL after the request is sent and the publish fails with ErrAckTimeout.
+	// Default is 3 minutes.
+	AckCorrelationTTL time.Duration
+
+	// DefaultRPCTimeout is the time after which a publish whose context has no deadline, e.g. any
+	// PublishAsync, fails with ErrAckTimeout if its response didn't arrive. The pending response is
+	// dropped. Consume requests have their own timeout.
+	// Default is 0, which means the publish is only bounded by AckCorrelationTTL.
+	DefaultRPCTimeout time.Duration
+
+	// AsyncAckPolicy determines what happens to the result of a PublishAsync when the result
+	// channel isn't ready to receive it, e.g. because the caller is slow to read the results.
+	// Default is AsyncAckDropAndLog.
+	AsyncAckPolicy AsyncAckPolicy
+
+	// AsyncAckBufferSize is the number of results AsyncAckBufferUpTo waits for at a time.
+	AsyncAckBufferSize int
+
+	// OnQuotaLow is invoked when the remaining quota reported by the server drops below
+	// QuotaLowThreshold.
+	OnQuotaLow func(remaining int)
+
+	// QuotaLowThreshold is the remaining quota below which OnQuotaLow is invoked.
+	// Default is 10.
+	QuotaLowThreshold int
+
+	// ReconnectPolicy controls how the connection is re-established after a consume timeout.
+	ReconnectPolicy ReconnectPolicy
+
+	// OutboxSize is the number of publishes held in memory while the connection is being
+	// re-established. The held publishes are sent once reconnected, and held again if the connection
+	// is lost while they're sent. Publish waits for their result up to its context. Publish fails
+	// with ErrOutboxFull while OutboxSize publishes are held. The held publishes fail with
+	// ErrNotConnected if the connection can't be re-established.
+	// Default is 0, which means Publish fails while reconnecting.
+	OutboxSize int
+
+	// OutboxFlushTimeout bounds the time spent sending the held publishes once reconnected, the
+	// publishes without result by then fail with context.DeadlineExceeded. The held publishes are
+	// sent in order, and publishes made in the meantime are held behind them so that the messages
+	// of a stream are published in order.
+	// Default is 0, which means the held publishes are only bounded by the context of Publish.
+	OutboxFlushTimeout time.Duration
+
+	// OnStateChange is invoked whenever the state of the connection changes.
+	OnStateChange func(state ConnectionState)
+
+	// SubscriptionCheckInterval is the interval at which the subscriptions are checked against the
+	// server, e.g. to detect subscriptions that expired on the server. The subscriptions that no
+	// longer exist are recreated and SubscriptionRecreatedHandler is invoked.
+	// Default is 0, which means the subscriptions aren't checked.
+	SubscriptionCheckInterval time.Duration
+
+	// SubscriptionRecreatedHandler is invoked when a subscription is recreated, see
+	// SubscriptionCheckInterval.
+	SubscriptionRecreatedHandler SubscriptionRecreatedHandler
+
+	// BaseContext optionally specifies a function that returns the base context for all the
+	// operations performed by the connection. Cancelling the base context closes the connection.
+	// Default is context.Background.
+	BaseContext func() context.Context
+
+	// HTTPClient is the HTTP client used for the REST requests and the WebSocket connection, e.g.
+	// to configure proxies and timeouts. The client itself isn't modified, Transport, TLSConfig and
+	// LocalAddr are applied to a copy. The connection pool of a client whose transport isn't an
+	// *http.Transport isn't instrumented and TLSConfig and LocalAddr aren't applied to it.
+	// Default is a client with the default transport of resty.
+	HTTPClient *http.Client
+
+	// Transport is the transport of the HTTP client, it takes precedence over the transport of
+	// HTTPClient. The transport is cloned, the supplied one isn't modified.
 	Transport *http.Transport
+
+	// TLSConfig is the TLS configuration of the HTTP client, e.g. to trust custom CAs. It takes
+	// precedence over the TLS configuration of Transport.
+	TLSConfig *tls.Config
+
+	// UserAgent is the User-Agent header of the REST requests, e.g. to identify the application in
+	// the server logs.
+	// Default is cloud-sdk-go/<version>, with the version of the SDK module the application is
+	// built with.
+	UserAgent string
+
+	// RestHeaders are headers added to all the REST requests, e.g. tracing or routing headers.
+	// UserAgent takes precedence over a User-Agent header, and the auth headers, X-Api-Key and
+	// X-Auth-Token, are never taken from RestHeaders.
+	RestHeaders map[string]string
+
+	// Logger is used for the log messages of the connection, e.g. to add context fields such as
+	// the connection ID.
+	// Default is the global log.Logger.
+	Logger log.SDKLogger
+
+	// Metrics receives the metrics of the connection.
+	// Default discards the metrics.
+	Metrics MetricsCollector
+
+	// Propagator propagates the trace context of the context passed to Publish to the message
+	// headers and starts a span linked to it before a consumed message is delivered.
+	// Default is nil, which means the trace context isn't propagated.
+	Propagator Propagator
+
+	// RPCInterceptor is invoked with the RPC requests and responses exchanged with the server, e.g.
+	// to log them while debugging. A panicking interceptor is recovered and logged.
+	// Default is nil, which means no interception.
+	RPCInterceptor RPCInterceptor
+
+	// ClientCertificate is the client certificate presented to the server for mutual TLS
+	// authentication. It complements the API key or auth token, which are still required.
+	ClientCertificate *tls.Certificate
+
+	// ClientCertFile and ClientKeyFile are the paths of the PEM encoded client certificate and its
+	// private key, loaded by NewConnection if ClientCertificate isn't set.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// LocalAddr is the local address the REST and RPC connections are bound to, for hosts with
+	// multiple network interfaces. The DialContext of the transport is replaced.
+	LocalAddr net.Addr
+
+	// limiter of PublishRateLimit, shared by the connections re-established with the config
+	publishLimiter *rate.Limiter
+
+	// windows of SubscribeOptions.Dedup, shared by the connections re-established with the config
+	dedupWindows *dedupWindows
 }
 
 // internalConnection represents a connection to the DxHub PubSub server.
 type internalConnection struct {
+	lastActivity int64 // unix nanoseconds of the last successful consume or publish, accessed atomically
+
 	// Error channel should be monitored by the user for receiving error notification from the
 	// connection. If an error is sent to this channel, then the connection is already closed. Error
 	// returned from the channel shall describe the reason of connection closure. A nil value
 	// indicates normal closure.
 	Error      chan error
+	id         string           // short random ID of the connection included in the log messages
 	mu         sync.Mutex       // lock to protect the connection itself
 	config     Config           // config received from the user
 	restClient *resty.Client    // resty HTTP client
@@ -130,18 +474,43 @@ type internalConnection struct {
 	readerCh   chan []byte      // channel where read messages are sent to for processing
 	writerCh   chan *msgRequest // channel where messages are sent to for publishing
 	closeOnce  sync.Once        // to make sure the connection closure procedure is performed only once
+	closeCall  int32            // set by the first Close, accessed atomically
 	authHeader struct {         // auth header
 		key      string                 // string to use for auth header, either "X-API-KEY" or "X-AUTH-TOKEN"
 		provider func() ([]byte, error) // auth header provider function
 	}
 	subs struct { // subscriptions
 		table      map[string]*subscription // map of subscriptions indexed by stream name
-		sync.Mutex                          // lock to protect the table
+		pending    map[string]chan struct{} // streams whose subscription is being created, closed once done
+		sync.Mutex                          // lock to protect the table and pending
 	}
 	msgHandlers *handlerMap
+	restPool    *restPool
+	asyncAcks   int32 // number of results waiting to be delivered with AsyncAckBufferUpTo
+	quota       quota
+	throttle    throttle
+	ctx         context.Context    // base context of the connection, derived from Config.BaseContext
+	ctxCancel   context.CancelFunc // cancels ctx once the connection is closed
+	restHeaders map[string]string  // headers of the REST requests besides the auth header
+
+	inFlightPublishes int32         // number of publishes waiting for their response
+	publishSlots      chan struct{} // one entry per in-flight publish with MaxInFlightPublishes
+	pendingPublishes  pendingPublishes
 
 	// consumeTimeout to signify there was a consume timeout within subscriber
 	consumeTimeout bool
+
+	// discarded is set to 1 by discard, the subscriptions are kept on the server
+	discarded int32
+
+	// protocolVersion is the protocol version negotiated with the server
+	protocolVersion int
+
+	// tokens caches the token of Config.AuthTokenProviderWithExpiry, nil otherwise
+	tokens *tokenCache
+
+	// subscribeLatency records the durations of the subscription creations
+	subscribeLatency latencyRecorder
 }
 
 // newInternalConnection creates a new connection object based on the supplied configuration.
@@ -152,28 +521,181 @@ func newInternalConnection(config Config) (*internalConnection, error) {
 	if config.Domain == "" {
 		return nil, fmt.Errorf("Config must contain Domain")
 	}
+	if config.Scheme == "" {
+		config.Scheme = httpScheme
+	}
+	if _, ok := webSocketSchemes[config.Scheme]; !ok {
+		return nil, fmt.Errorf("Config Scheme must be either https or http")
+	}
 	if config.PollInterval == 0 {
 		config.PollInterval = defaultPollInterval
 	}
+	if config.AckCorrelationTTL == 0 {
+		config.AckCorrelationTTL = handlersExpiration
+	}
+	if config.MaxPollInterval > 0 && config.MinPollInterval > config.MaxPollInterval {
+		return nil, fmt.Errorf("Config MinPollInterval must not be greater than MaxPollInterval")
+	}
+	if config.PollJitter < 0 || config.PollJitter >= 1 {
+		return nil, fmt.Errorf("Config PollJitter must be in the range [0, 1)")
+	}
+	if config.dedupWindows == nil {
+		config.dedupWindows = &dedupWindows{}
+	}
+	if config.publishLimiter == nil {
+		config.publishLimiter = newPublishLimiter(config.PublishRateLimit, config.PublishBurst)
+	}
+	if !config.PayloadEncoding.valid() {
+		return nil, fmt.Errorf("Config PayloadEncoding must be one of the PayloadEncoding constants")
+	}
+	if !config.Mode.valid() {
+		return nil, fmt.Errorf("Config Mode must be one of the Mode constants")
+	}
+	if config.ConsumeErrorThreshold < 0 {
+		return nil, fmt.Errorf("Config ConsumeErrorThreshold must not be negative")
+	}
+	if config.ConsumeErrorThreshold == 0 {
+		config.ConsumeErrorThreshold = defaultConsumeErrorThreshold
+	}
+	if config.OutboxFlushTimeout < 0 {
+		return nil, fmt.Errorf("Config OutboxFlushTimeout must not be negative")
+	}
+	if config.MaxConsumeBackoff < 0 {
+		return nil, fmt.Errorf("Config MaxConsumeBackoff must not be negative")
+	}
+	if config.MaxConsumeBackoff == 0 {
+		config.MaxConsumeBackoff = defaultRetryMaxBackoff
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = defaultUserAgent()
+	}
+	if config.CircuitBreakerThreshold < 0 {
+		return nil, fmt.Errorf("Config CircuitBreakerThreshold must not be negative")
+	}
+	if config.CircuitBreakerThreshold == 0 {
+		config.CircuitBreakerThreshold = defaultCircuitBreakerThreshold
+	}
+	if config.CircuitBreakerCooldown < 0 {
+		return nil, fmt.Errorf("Config CircuitBreakerCooldown must not be negative")
+	}
+	if config.CircuitBreakerCooldown == 0 {
+		config.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+	}
+	if config.ConsumeBatchSize < 0 {
+		return nil, fmt.Errorf("Config ConsumeBatchSize must not be negative")
+	}
+	if config.NackRedeliveryDelay == 0 {
+		config.NackRedeliveryDelay = defaultNackRedeliveryDelay
+	}
+	if config.BlockingHandlerThreshold == 0 {
+		config.BlockingHandlerThreshold = defaultBlockingHandlerThreshold
+	}
+	if config.StreamDiscoveryInterval == 0 {
+		config.StreamDiscoveryInterval = defaultStreamDiscoveryInterval
+	}
+	if config.AckTimeout == 0 {
+		config.AckTimeout = defaultAckTimeout
+	}
+	if config.Metrics == nil {
+		config.Metrics = noopMetrics{}
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.BaseContext == nil {
+		config.BaseContext = context.Background
+	}
+	if config.ReconnectPolicy.MaxAttempts == 0 {
+		config.ReconnectPolicy.MaxAttempts = defaultReconnectAttempts
+	}
+	if config.ReconnectPolicy.Delay == 0 {
+		config.ReconnectPolicy.Delay = defaultReconnectDelay
+	}
+	if config.QuotaLowThreshold == 0 {
+		config.QuotaLowThreshold = defaultQuotaLowThreshold
+	}
+
+	clientCert := config.ClientCertificate
+	if clientCert == nil && (config.ClientCertFile != "" || config.ClientKeyFile != "") {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Config client certificate can't be loaded: %w", err)
+		}
+		clientCert = &cert
+	}
 
 	httpClient := resty.New()
+	transport, _ := httpClient.GetClient().Transport.(*http.Transport)
+	if config.HTTPClient != nil {
+		// shallow copy so that the transport of the supplied client isn't replaced
+		hc := *config.HTTPClient
+		httpClient = resty.NewWithClient(&hc)
+		transport = nil
+		if hc.Transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		} else if t, ok := hc.Transport.(*http.Transport); ok {
+			transport = t.Clone()
+		}
+	}
 	if config.Transport != nil {
-		httpClient.SetTransport(config.Transport)
+		transport = config.Transport.Clone()
+	}
+	if transport != nil && config.LocalAddr != nil {
+		transport.DialContext = (&net.Dialer{
+			LocalAddr: config.LocalAddr,
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
 	}
+	if transport != nil && config.TLSConfig != nil {
+		transport.TLSClientConfig = config.TLSConfig.Clone()
+	}
+	if clientCert != nil {
+		if transport == nil {
+			return nil, fmt.Errorf("Config client certificate requires the transport of HTTPClient to be an *http.Transport")
+		}
+		tlsConfig := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		}
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+		transport.TLSClientConfig = tlsConfig
+	}
+	pool := &restPool{}
+	if transport != nil {
+		pool.instrument(transport)
+		httpClient.SetTransport(transport)
+	}
+	httpClient.OnBeforeRequest(pool.beforeRequest)
+	httpClient.OnAfterResponse(pool.afterResponse)
+	httpClient.OnError(pool.onError)
 	c := &internalConnection{
+		id:          uuid.NewString()[:8],
 		config:      config,
 		restClient:  httpClient,
+		restPool:    pool,
+		restHeaders: newRestHeaders(config),
 		closed:      make(chan struct{}),
 		Error:       make(chan error, 1),        // buffer of 1 to make sure that error is not lost
 		readerCh:    make(chan []byte, 64),      // buffer of 64 helps with latency and provides a buffer to catch up during processing
 		writerCh:    make(chan *msgRequest, 64), // buffer of 64 helps with latency and provides a buffer to catch up during processing
-		msgHandlers: NewHandlerMap(handlersExpiration),
+		msgHandlers: NewHandlerMap(config.AckCorrelationTTL),
 	}
 	c.subs.table = make(map[string]*subscription)
+	c.subs.pending = make(map[string]chan struct{})
+	if config.MaxInFlightPublishes > 0 {
+		c.publishSlots = make(chan struct{}, config.MaxInFlightPublishes)
+	}
+	c.restClient.OnAfterResponse(c.quotaMiddleware)
+	c.ctx, c.ctxCancel = context.WithCancel(config.BaseContext())
 
 	if config.APIKeyProvider != nil {
 		c.authHeader.key = headerStrApiKey
 		c.authHeader.provider = config.APIKeyProvider
+	} else if config.AuthTokenProviderWithExpiry != nil {
+		c.tokens = &tokenCache{provider: config.AuthTokenProviderWithExpiry}
+		c.authHeader.key = headerStrAuthToken
+		c.authHeader.provider = c.tokens.get
 	} else if config.AuthTokenProvider != nil {
 		c.authHeader.key = headerStrAuthToken
 		c.authHeader.provider = config.AuthTokenProvider
@@ -184,6 +706,15 @@ func newInternalConnection(config Config) (*internalConnection, error) {
 	return c, nil
 }
 
+// logger returns the logger of the connection
+func (c *internalConnection) logger() log.SDKLogger {
+	logger := log.Logger
+	if c.config.Logger != nil {
+		logger = c.config.Logger
+	}
+	return log.WithPrefix(logger, "[conn "+c.id+"] ")
+}
+
 func (c *internalConnection) String() string {
 	return fmt.Sprintf("Conn[ID: %s, Domain: %s]", c.config.GroupID, c.config.Domain)
 }
@@ -200,26 +731,47 @@ func (c *internalConnection) connect(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to get auth token: %v", err)
 	}
+	// the websocket connection isn't part of the REST connection pool
+	ctx = context.WithValue(ctx, websocketDialKey{}, true)
 	opts := &websocket.DialOptions{
 		HTTPHeader: http.Header{
 			c.authHeader.key: []string{string(authToken)},
 		},
-		HTTPClient: c.restClient.GetClient(),
+		HTTPClient:   c.restClient.GetClient(),
+		Subprotocols: protocolSubprotocols(),
 	}
 	var resp *http.Response
 	brokerSubURL := &url.URL{
 		Host:   c.config.Domain,
-		Scheme: webSocketScheme,
+		Scheme: webSocketSchemes[c.config.Scheme],
 		Path:   apiPaths.pubsub,
 	}
 	c.ws, resp, err = websocket.Dial(ctx, brokerSubURL.String(), opts)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnauthorized && c.tokens != nil {
+		c.logger().Infof("Received unexpected response '%s', refreshing the auth token", resp.Status)
+		authToken, err = c.tokens.refresh()
+		if err != nil {
+			return fmt.Errorf("failed to get auth token: %v", err)
+		}
+		opts.HTTPHeader.Set(c.authHeader.key, string(authToken))
+		c.ws, resp, err = websocket.Dial(ctx, brokerSubURL.String(), opts)
+	}
+	if resp != nil {
+		c.updateQuota(resp.Header)
+	}
 	if err != nil {
 		if resp != nil {
 			return fmt.Errorf("failed to connect: %v, HTTP Response: %+v", err, resp)
 		}
 		return fmt.Errorf("failed to connect: %v", err)
 	}
-	log.Logger.Infof("Connected to PubSub server: %s", brokerSubURL.String())
+	c.protocolVersion, err = negotiatedProtocolVersion(c.ws.Subprotocol(), c.config.MinProtocolVersion)
+	if err != nil {
+		_ = c.ws.Close(websocket.StatusProtocolError, "unsupported protocol version")
+		c.ws = nil
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	c.logger().Infof("Connected to PubSub server: %s, protocol version: %d", brokerSubURL.String(), c.protocolVersion)
 	c.ws.SetReadLimit(maxMessageSize)
 
 	c.wg.Add(1)
@@ -231,6 +783,14 @@ func (c *internalConnection) connect(ctx context.Context) error {
 	c.wg.Add(1)
 	go c.writer()
 
+	c.wg.Add(1)
+	go c.watcher()
+
+	if c.tokens != nil {
+		c.wg.Add(1)
+		go c.tokenRefresher()
+	}
+
 	err = c.sendOpenMessage()
 	if err != nil {
 		c.closeNotify(c.checkWSError(err))
@@ -245,7 +805,7 @@ func (c *internalConnection) ping() {
 	c.wg.Add(1)
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), pongWait)
-		log.Logger.Debugf("Pinging server %v", c)
+		c.logger().Debugf("Pinging server %v", c)
 		err := c.ws.Ping(ctx)
 		cancel()
 		if err != nil {
@@ -266,25 +826,43 @@ loop:
 			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 			defer cancel()
 
-			if msg.handler != nil {
-				c.msgHandlers.Set(msg.req.ID, msg.handler)
+			if msg.ctx.Err() != nil {
+				c.logger().Debugf("Dropping cancelled message %s", msg.req)
+				c.msgHandlers.Delete(msg.req.ID)
+				continue
 			}
-			err := c.ws.Write(ctx, websocket.MessageText, msg.req.Bytes())
+			c.intercept(func(i RPCInterceptor) { i.BeforeSend(msg.req) })
+			err := c.ws.Write(ctx, c.messageType(), msg.req.Bytes())
 			if err != nil {
-				log.Logger.Errorf("Failed to write message %s: %v", msg.req, err)
+				c.logger().Errorf("Failed to write message %s: %v", msg.req, err)
 
 				defer c.closeNotify(c.checkWSError(err))
 				break loop
 			}
 		}
 	}
-	log.Logger.Debugf("writer shutdown complete")
+	c.logger().Debugf("writer shutdown complete")
+	c.wg.Done()
+}
+
+// watcher closes the WebSocket connection when the base context is cancelled
+func (c *internalConnection) watcher() {
+	select {
+	case <-c.closed:
+	case <-c.ctx.Done():
+		c.logger().Debugf("base context cancelled, closing the connection")
+		_ = c.ws.Close(websocket.StatusNormalClosure, websocket.StatusNormalClosure.String())
+	}
+	c.logger().Debugf("watcher shutdown complete")
 	c.wg.Done()
 }
 
 // processor goroutine processes the incoming messages from the WebSocket connection and sends ping
 // messages when required
 func (c *internalConnection) processor() {
+	// the handlers are otherwise only expired when a request is sent or a response is received
+	expireTicker := time.NewTicker(c.config.AckCorrelationTTL)
+	defer expireTicker.Stop()
 loop:
 	for {
 		select {
@@ -293,18 +871,24 @@ loop:
 		case msg := <-c.readerCh:
 			resp, err := rpc.NewResponseFromBytes(msg)
 			if err != nil {
-				log.Logger.Errorf("Received unknown message: %s", msg)
+				c.logger().Errorf("Received unknown message: %s", msg)
 				continue
 			}
+			c.intercept(func(i RPCInterceptor) { i.AfterReceive(resp) })
 			handler := c.msgHandlers.GetAndDelete(resp.ID)
-			if handler != nil {
-				handler(resp)
+			if handler == nil {
+				// duplicate response, response to PublishNoAck or to a request that timed out
+				c.logger().Debugf("Ignoring response %s without pending request", resp.ID)
+				continue
 			}
+			handler(resp)
+		case <-expireTicker.C:
+			c.msgHandlers.expireCheck()
 		case <-time.After(pingPeriod):
 			c.ping()
 		}
 	}
-	log.Logger.Debugf("processor shutdown complete")
+	c.logger().Debugf("processor shutdown complete")
 	c.wg.Done()
 }
 
@@ -313,8 +897,15 @@ func (c *internalConnection) reader() {
 	for {
 		msg, err := c.read(context.Background())
 		if err != nil {
+			if ctxErr := c.ctx.Err(); ctxErr != nil {
+				// base context was cancelled by the user
+				c.logger().Infof("PubSub connection closed: %v", ctxErr)
+				err = ctxErr
+			} else {
+				err = c.checkWSError(err)
+			}
 			// deferring the close here to make sure that wg gets marked Done before close
-			defer c.closeNotify(c.checkWSError(err))
+			defer c.closeNotify(err)
 			break
 		}
 
@@ -322,42 +913,88 @@ func (c *internalConnection) reader() {
 		case c.readerCh <- msg:
 		default:
 			// processor cannot take anymore messages because it's blocked downstream
-			log.Logger.Errorf("Failed to submit message %s: processor is blocked", msg)
+			c.logger().Errorf("Failed to submit message %s: processor is blocked", msg)
 		}
 	}
-	log.Logger.Debugf("reader goroutine shutdown complete")
+	c.logger().Debugf("reader goroutine shutdown complete")
 	c.wg.Done()
 }
 
 // disconnect disconnects the connection to the DxHub PubSub server.
 func (c *internalConnection) disconnect() {
+	_ = c.shutdown()
+}
+
+// Close stops all the subscribers, deletes the subscriptions on the server and closes the
+// connection. The failures are returned in a *MultiError. It's safe to call more than once, the
+// calls after the first one return nil.
+func (c *internalConnection) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closeCall, 0, 1) {
+		return nil
+	}
+	var errs []error
+	if !c.isDisconnected() {
+		if _, err := c.unsubscribeAll(); err != nil {
+			var multiErr *MultiError
+			if errors.As(err, &multiErr) {
+				errs = append(errs, multiErr.Errors...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := c.shutdown(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return &MultiError{Errors: errs}
+	}
+	return nil
+}
+
+// shutdown sends the close message and closes the WebSocket connection, then waits for all the
+// goroutines to finish. The failure to send the close message takes precedence over the failure to
+// close the WebSocket connection.
+func (c *internalConnection) shutdown() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.ws == nil {
-		log.Logger.Debugf("Connection is not opened")
-		return
+		c.logger().Debugf("Connection is not opened")
+		return nil
 	}
 	if c.isClosed() {
-		return
+		return nil
 	}
 
-	err := c.sendCloseMessage()
-	if err != nil {
-		log.Logger.Errorf("failed to send close message: %v", err)
+	closeMsgErr := c.sendCloseMessage()
+	if closeMsgErr != nil {
+		c.logger().Errorf("failed to send close message: %v", closeMsgErr)
+		closeMsgErr = fmt.Errorf("failed to send close message: %w", closeMsgErr)
 	}
-	log.Logger.Debugf("connection closing")
-	err = c.ws.Close(websocket.StatusNormalClosure, websocket.StatusNormalClosure.String())
+	c.logger().Debugf("connection closing")
+	err := c.ws.Close(websocket.StatusNormalClosure, websocket.StatusNormalClosure.String())
 
 	// wait for all goroutines to finish
-	log.Logger.Debugf("waiting for all goroutines to finish")
+	c.logger().Debugf("waiting for all goroutines to finish")
 	c.wg.Wait()
 
 	if err != nil {
-		log.Logger.Infof("PubSub connection closed with error: %v", err)
+		c.logger().Infof("PubSub connection closed with error: %v", err)
 	} else {
-		log.Logger.Infof("PubSub connection closed")
+		c.logger().Infof("PubSub connection closed")
 	}
+	if closeMsgErr != nil {
+		return closeMsgErr
+	}
+	return err
+}
+
+// discard disconnects a connection whose reconnect attempt failed. The subscriptions aren't
+// deleted on the server, unless the group is ephemeral, so that the next attempt reuses them.
+func (c *internalConnection) discard() {
+	atomic.StoreInt32(&c.discarded, 1)
+	c.disconnect()
 }
 
 // closeNotify notifies other goroutines about the connection closure
@@ -369,20 +1006,28 @@ func (c *internalConnection) closeNotify(err error) {
 		// WORKAROUND To decide if subscription needs to be deleted
 		// c.unsubscribe still needs to be called to free up other resource
 		deleteSub := true
-		if c.consumeTimeout {
-			log.Logger.Infof("Consume timeout. Not deleting subscription as reconnect will reuse")
+		if (c.consumeTimeout || atomic.LoadInt32(&c.discarded) == 1) && !c.config.EphemeralGroup {
+			c.logger().Infof("Not deleting subscription as reconnect will reuse")
 			deleteSub = false
 		}
-		for stream := range c.subs.table {
-			log.Logger.Debugf("unsubscribing from %s", stream)
-			e := c.unsubscribeWithoutLock(stream, deleteSub)
-			if e != nil {
-				log.Logger.Errorf("failed to unsubscribe from stream %s: %v", stream, e)
+		// a subscriptions endpoint that doesn't respond mustn't block the close. The deletions
+		// don't stop with the connection's context, which is done if Config.BaseContext is canceled.
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		for stream, sub := range c.subs.table {
+			c.logger().Debugf("unsubscribing from %s", stream)
+			if deleteSub && sub.id != "" {
+				if e := c.deleteSubscriptionDetached(ctx, sub.id); e != nil {
+					c.logger().Errorf("failed to unsubscribe from stream %s: %v", stream, e)
+				}
 			}
+			// the subscriber must still be stopped even though the server side deletion failed
+			_ = c.unsubscribeWithoutLock(ctx, stream, false)
 		}
+		cancel()
 		c.subs.Unlock()
 
 		c.wg.Wait()
+		c.ctxCancel()
 		c.Error <- err
 		close(c.Error)
 	})
@@ -408,16 +1053,16 @@ func (c *internalConnection) isClosed() bool {
 func (c *internalConnection) checkWSError(err error) error {
 	closeStatus := websocket.CloseStatus(err)
 	if closeStatus == websocket.StatusNormalClosure || closeStatus == websocket.StatusGoingAway {
-		log.Logger.Infof("PubSub connection closed by server: %v", closeStatus)
+		c.logger().Infof("PubSub connection closed by server: %v", closeStatus)
 		return nil
 	} else if closeStatus != -1 {
-		log.Logger.Errorf("PubSub connection failure: %v", closeStatus)
+		c.logger().Errorf("PubSub connection failure: %v", closeStatus)
 		return err
 	} else if errors.Is(err, io.EOF) {
-		log.Logger.Infof("PubSub connection closed by server: EOF")
+		c.logger().Infof("PubSub connection closed by server: EOF")
 		return nil
 	} else {
-		log.Logger.Errorf("Unexpected PubSub connection failure: %v", err)
+		c.logger().Errorf("Unexpected PubSub connection failure: %v", err)
 		return err
 	}
 }
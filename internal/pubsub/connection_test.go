@@ -2,15 +2,29 @@ package pubsub
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,14 +44,11 @@ func Test_E2E(t *testing.T) {
 			return []byte("xyz"), nil
 		},
 		PollInterval: 10 * time.Millisecond,
+		TLSConfig:    &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 
@@ -82,7 +93,12 @@ func Test_E2E(t *testing.T) {
 	wg.Wait()
 
 	// wait for sever to respond to all consume requests
-	time.Sleep(1 * time.Second)
+	for i := 0; i < numSubs; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = c.WaitForConsumed(ctx, fmt.Sprintf("test-stream-%d", i), numMessages)
+		cancel()
+		require.NoError(t, err)
+	}
 
 	// verify
 	for i := 0; i < numSubs; i++ {
@@ -95,8 +111,8 @@ func Test_E2E(t *testing.T) {
 	c.disconnect()
 	require.Equal(t, true, c.isDisconnected(), "Connection is still connected")
 
-	t.Logf("subs table: %#v", c.subs.table)
-	require.Zero(t, len(c.subs.table))
+	t.Logf("subscriptions: %+v", c.SubscriptionSnapshot())
+	require.Empty(t, c.SubscriptionSnapshot())
 
 	select {
 	case err = <-c.Error:
@@ -123,14 +139,11 @@ func Test_ConnectionError(t *testing.T) {
 			return []byte("xyz"), nil
 		},
 		PollInterval: 10 * time.Millisecond,
+		TLSConfig:    &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.Error(t, err, "Did not receive expected error")
 }
@@ -220,14 +233,11 @@ func Test_ConnectAlreadyConnected(t *testing.T) {
 			return []byte("xyz"), nil
 		},
 		PollInterval: 10 * time.Millisecond,
+		TLSConfig:    &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 	defer c.disconnect()
@@ -268,14 +278,11 @@ func Test_ConsumeError(t *testing.T) {
 		APIKeyProvider: func() ([]byte, error) {
 			return []byte("xyz"), nil
 		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 
@@ -299,7 +306,7 @@ func Test_ConsumeError(t *testing.T) {
 	c.disconnect()
 
 	require.True(t, c.isDisconnected())
-	require.Zero(t, len(c.subs.table))
+	require.Empty(t, c.SubscriptionSnapshot())
 	require.Zero(t, count)
 }
 
@@ -335,14 +342,11 @@ func Test_PublishError2(t *testing.T) {
 		APIKeyProvider: func() ([]byte, error) {
 			return []byte("xyz"), nil
 		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 
@@ -355,7 +359,7 @@ func Test_PublishError2(t *testing.T) {
 	c.disconnect()
 
 	require.True(t, c.isDisconnected())
-	require.Zero(t, len(c.subs.table))
+	require.Empty(t, c.SubscriptionSnapshot())
 }
 
 func Test_PublishAsync(t *testing.T) {
@@ -373,14 +377,11 @@ func Test_PublishAsync(t *testing.T) {
 		APIKeyProvider: func() ([]byte, error) {
 			return []byte("xyz"), nil
 		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 
@@ -416,7 +417,7 @@ func Test_PublishAsync(t *testing.T) {
 	c.disconnect()
 
 	require.True(t, c.isDisconnected())
-	require.Zero(t, len(c.subs.table))
+	require.Empty(t, c.SubscriptionSnapshot())
 }
 
 func Test_PublishAsyncCanceled(t *testing.T) {
@@ -434,14 +435,11 @@ func Test_PublishAsyncCanceled(t *testing.T) {
 		APIKeyProvider: func() ([]byte, error) {
 			return []byte("xyz"), nil
 		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 
@@ -468,7 +466,7 @@ func Test_PublishAsyncCanceled(t *testing.T) {
 	c.disconnect()
 
 	require.True(t, c.isDisconnected())
-	require.Zero(t, len(c.subs.table))
+	require.Empty(t, c.SubscriptionSnapshot())
 	require.Equal(t, 1, count)
 }
 
@@ -491,14 +489,11 @@ func Test_ConsumeTimeout(t *testing.T) {
 		APIKeyProvider: func() ([]byte, error) {
 			return []byte("xyz"), nil
 		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
 	})
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
-	c.restClient.SetTLSClientConfig(&tls.Config{
-		InsecureSkipVerify: true, // no verification for test server
-	})
-
 	err = c.connect(context.Background())
 	require.NoError(t, err)
 
@@ -516,3 +511,464 @@ func Test_ConsumeTimeout(t *testing.T) {
 	}
 	c.disconnect()
 }
+
+func Test_BaseContextCancel(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	baseCtx, baseCancel := context.WithCancel(context.Background())
+	defer baseCancel()
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		PollInterval: 10 * time.Millisecond,
+		BaseContext: func() context.Context {
+			return baseCtx
+		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+	})
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	err = c.connect(context.Background())
+	require.NoError(t, err)
+
+	_, err = c.subscribe("test-stream", "",
+		func(e error, _ string, _ map[string]string, _ []byte) {
+			require.NoError(t, e)
+		})
+	require.NoError(t, err)
+
+	baseCancel()
+
+	select {
+	case err = <-c.Error:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the connection to close")
+	}
+	require.True(t, c.isDisconnected())
+	require.Empty(t, c.SubscriptionSnapshot())
+}
+
+func Test_BaseContextCancelEphemeralGroup(t *testing.T) {
+	deleted := make(chan string, 1)
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		OnRequest: func(r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deleted <- strings.TrimPrefix(r.URL.Path, apiPaths.subscriptions+"/")
+			}
+		},
+	})
+	defer s.Close()
+
+	baseCtx, baseCancel := context.WithCancel(context.Background())
+	defer baseCancel()
+	c := newTestConnection(t, s, Config{
+		EphemeralGroup: true,
+		BaseContext: func() context.Context {
+			return baseCtx
+		},
+	})
+	defer c.disconnect()
+
+	id, err := c.subscribe("base-ctx-ephemeral-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	baseCancel()
+	select {
+	case err = <-c.Error:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the connection to close")
+	}
+	// the subscription is deleted from the server although the base context is done
+	select {
+	case deletedID := <-deleted:
+		require.Equal(t, id, deletedID)
+	default:
+		require.Fail(t, "Subscription not deleted")
+	}
+	require.False(t, test.HasSubscription(id))
+}
+
+// newTestConnection creates a connection to the test server using config and connects it.
+// GroupID, Domain and APIKeyProvider are filled in if not set.
+func newTestConnection(t testing.TB, s *httptest.Server, config Config) *internalConnection {
+	u, _ := url.Parse(s.URL)
+	if config.GroupID == "" {
+		config.GroupID = "test-client"
+	}
+	config.Domain = u.Host
+	if config.APIKeyProvider == nil && config.AuthTokenProvider == nil && config.AuthTokenProviderWithExpiry == nil {
+		config.APIKeyProvider = func() ([]byte, error) {
+			return []byte("xyz"), nil
+		}
+	}
+	if config.TLSConfig == nil {
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true} // no verification for test server
+	}
+	c, err := newInternalConnection(config)
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	err = c.connect(context.Background())
+	require.NoError(t, err)
+	return c
+}
+
+func Test_HTTPClient(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	// custom CA instead of skipping the verification
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	transport := &http.Transport{}
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	u, _ := url.Parse(s.URL)
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		HTTPClient: client,
+		TLSConfig:  &tls.Config{RootCAs: roots},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, c.restClient.GetClient().Timeout)
+	err = c.connect(context.Background())
+	require.NoError(t, err)
+	_, err = c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	c.disconnect()
+
+	// the supplied client isn't modified
+	require.Same(t, transport, client.Transport)
+	require.Nil(t, transport.DialContext)
+}
+
+// recordingTransport records the URLs of the requests
+type recordingTransport struct {
+	urls []string
+	mu   sync.Mutex
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.urls = append(r.urls, req.URL.String())
+	r.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func Test_Scheme(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PlainHTTP:         true,
+	})
+	defer s.Close()
+
+	transport := &recordingTransport{}
+	u, _ := url.Parse(s.URL)
+	config := Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		HTTPClient: &http.Client{Transport: transport},
+		Scheme:     "http",
+	}
+	c, err := newInternalConnection(config)
+	require.NoError(t, err)
+	require.NoError(t, c.connect(context.Background()))
+	_, err = c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	c.disconnect()
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	require.Contains(t, transport.urls, "http://"+u.Host+apiPaths.subscriptions)
+	for _, u := range transport.urls {
+		require.True(t, strings.HasPrefix(u, "http://"), "unexpected URL %s", u)
+	}
+
+	// https is the default, other schemes are rejected
+	config.Scheme = ""
+	c, err = newInternalConnection(config)
+	require.NoError(t, err)
+	require.Equal(t, "https", c.config.Scheme)
+	config.Scheme = "ftp"
+	_, err = newInternalConnection(config)
+	require.Error(t, err)
+}
+
+// newTestClientCertificate returns a client certificate in PEM encoding along with the pool of the
+// CA that signed it
+func newTestClientCertificate(t *testing.T) (*x509.CertPool, []byte, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return pool, certPEM, keyPEM
+}
+
+func Test_ClientCertificate(t *testing.T) {
+	pool, certPEM, keyPEM := newTestClientCertificate(t)
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ClientCAs:         pool,
+	})
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+	config := Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+	}
+
+	// the server requires a client certificate
+	c, err := newInternalConnection(config)
+	require.NoError(t, err)
+	require.Error(t, c.connect(context.Background()))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	certConfig := config
+	certConfig.ClientCertificate = &cert
+	c, err = newInternalConnection(certConfig)
+	require.NoError(t, err)
+	require.NoError(t, c.connect(context.Background()))
+	_, err = c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	c.disconnect()
+
+	// certificate files
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+	fileConfig := config
+	fileConfig.ClientCertFile = certFile
+	fileConfig.ClientKeyFile = keyFile
+	c, err = newInternalConnection(fileConfig)
+	require.NoError(t, err)
+	require.NoError(t, c.connect(context.Background()))
+	c.disconnect()
+
+	fileConfig.ClientKeyFile = filepath.Join(dir, "missing.pem")
+	_, err = newInternalConnection(fileConfig)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "client certificate can't be loaded")
+}
+
+func Test_LocalAddr(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")},
+	})
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	c.disconnect()
+
+	// the dialer of the supplied transport is replaced
+	u, _ := url.Parse(s.URL)
+	c, err = newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		// address reserved for documentation, not assigned to any interface
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")},
+	})
+	require.NoError(t, err)
+	err = c.connect(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "192.0.2.1")
+}
+
+type testLogger struct {
+	lines []string
+	sync.Mutex
+}
+
+func (l *testLogger) logf(level, format string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) { l.logf("DEBUG", format, args...) }
+func (l *testLogger) Infof(format string, args ...interface{})  { l.logf("INFO", format, args...) }
+func (l *testLogger) Warnf(format string, args ...interface{})  { l.logf("WARN", format, args...) }
+func (l *testLogger) Errorf(format string, args ...interface{}) { l.logf("ERROR", format, args...) }
+
+func (l *testLogger) count() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.lines)
+}
+
+func (l *testLogger) contains(s string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_Logger(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	global := &testLogger{}
+	defaultLogger := log.Logger
+	log.Logger = global
+	defer func() { log.Logger = defaultLogger }()
+
+	logger1 := &testLogger{}
+	c1 := newTestConnection(t, s, Config{
+		Logger: logger1,
+	})
+	logger2 := &testLogger{}
+	c2 := newTestConnection(t, s, Config{
+		Logger: logger2,
+	})
+
+	_, err := c1.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	c1.disconnect()
+	<-c1.Error
+	c2.disconnect()
+	<-c2.Error
+
+	require.NotZero(t, logger1.count())
+	require.NotZero(t, logger2.count())
+	require.Greater(t, logger1.count(), logger2.count())
+	require.Zero(t, global.count())
+
+	// every line carries the connection ID, consume lines also carry the subscription ID
+	require.NotEqual(t, c1.id, c2.id)
+	for _, line := range logger1.lines {
+		require.Contains(t, line, "[conn "+c1.id+"] ")
+	}
+	for _, line := range logger2.lines {
+		require.Contains(t, line, "[conn "+c2.id+"] ")
+	}
+	require.Contains(t, strings.Join(logger1.lines, "\n"), "[sub ")
+}
+
+func Test_Close(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	id, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Close())
+	require.True(t, c.isDisconnected())
+	require.False(t, test.HasSubscription(id))
+	require.NoError(t, c.Close())
+
+	// the Error channel is closed exactly once
+	select {
+	case err, ok := <-c.Error:
+		require.True(t, ok)
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the connection to close")
+	}
+	_, ok := <-c.Error
+	require.False(t, ok)
+}
+
+func Test_CloseError(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		DeleteError:       true,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	err := c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	err = c.Close()
+	var multiErr *MultiError
+	require.ErrorAs(t, err, &multiErr)
+	require.Equal(t, []string{"test-stream"}, multiErr.Streams())
+	require.True(t, c.IsDisconnected())
+	require.Empty(t, c.Subscriptions())
+	require.NoError(t, c.Close())
+}
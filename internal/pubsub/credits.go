@@ -0,0 +1,73 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import "sync"
+
+// creditWindow implements the credit based flow control of a subscription with
+// SubscribeOptions.InitialCredits. A credit is a message the server may send in a consume response.
+// The credits granted in a consume request are spent by the messages of the response and are
+// replenished as the callback processes the messages, so the consume requests follow the
+// throughput of the callback instead of the poll interval.
+type creditWindow struct {
+	free      int           // credits that aren't granted to the server or held by buffered messages
+	threshold int           // minimum number of credits granted in a consume request
+	available chan struct{} // signaled when free reaches threshold
+	sync.Mutex
+}
+
+// newCreditWindow returns a window of initial credits. A threshold of 0 defaults to half of the
+// initial credits.
+func newCreditWindow(initial, threshold int) *creditWindow {
+	if threshold <= 0 {
+		threshold = (initial + 1) / 2
+	}
+	if threshold > initial {
+		threshold = initial
+	}
+	return &creditWindow{
+		free:      initial,
+		threshold: threshold,
+		available: make(chan struct{}, 1),
+	}
+}
+
+// acquire waits until at least threshold credits are free and takes all of them. It returns false
+// if the subscription is cancelled or drained first.
+func (w *creditWindow) acquire(cancelled, drain <-chan struct{}) (int, bool) {
+	for {
+		w.Lock()
+		if w.free >= w.threshold {
+			n := w.free
+			w.free = 0
+			w.Unlock()
+			return n, true
+		}
+		w.Unlock()
+		select {
+		case <-w.available:
+		case <-cancelled:
+			return 0, false
+		case <-drain:
+			return 0, false
+		}
+	}
+}
+
+// release returns n credits to the window
+func (w *creditWindow) release(n int) {
+	if n <= 0 {
+		return
+	}
+	w.Lock()
+	w.free += n
+	ready := w.free >= w.threshold
+	w.Unlock()
+	if ready {
+		select {
+		case w.available <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CreditWindow(t *testing.T) {
+	w := newCreditWindow(4, 0)
+	require.Equal(t, 2, w.threshold)
+
+	n, ok := w.acquire(nil, nil)
+	require.True(t, ok)
+	require.Equal(t, 4, n)
+
+	// not enough credits until the threshold is replenished
+	cancelled := make(chan struct{})
+	close(cancelled)
+	w.release(1)
+	_, ok = w.acquire(cancelled, nil)
+	require.False(t, ok)
+
+	acquired := make(chan int)
+	go func() {
+		n, _ := w.acquire(nil, nil)
+		acquired <- n
+	}()
+	w.release(1)
+	select {
+	case n := <-acquired:
+		require.Equal(t, 2, n)
+	case <-time.After(time.Second):
+		t.Fatal("credits not acquired once replenished")
+	}
+}
+
+func Test_SubscribeInitialCredits(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval:             10 * time.Millisecond,
+		CreditReplenishThreshold: 2,
+	})
+	defer c.disconnect()
+
+	gate := make(chan struct{})
+	received := make(chan string, 10)
+	_, err := c.subscribe("test-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		<-gate
+		received <- string(payload)
+	}, WithInitialCredits(4))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := c.Publish(context.Background(), "test-stream", nil, []byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+	}
+
+	// the callback is blocked, no credits are granted once the buffer is full
+	require.Eventually(t, func() bool {
+		grants := test.ConsumeCredits("test-stream")
+		time.Sleep(50 * time.Millisecond)
+		return len(grants) > 0 && len(grants) == len(test.ConsumeCredits("test-stream"))
+	}, 2*time.Second, 10*time.Millisecond)
+	blocked := test.ConsumeCredits("test-stream")
+
+	// the credits are replenished as the messages are processed
+	for i := 0; i < 10; i++ {
+		gate <- struct{}{}
+		select {
+		case payload := <-received:
+			require.Equal(t, fmt.Sprint(i), payload)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+	grants := test.ConsumeCredits("test-stream")
+	require.Greater(t, len(grants), len(blocked))
+	for _, n := range grants {
+		require.GreaterOrEqual(t, n, 2)
+		require.LessOrEqual(t, n, 4)
+	}
+
+	// flow control isn't supported with acks
+	_, err = c.subscribeWithAck("ack-stream", "", func(error, *Message) {}, WithInitialCredits(4))
+	require.ErrorIs(t, err, ErrCreditsUnsupported)
+}
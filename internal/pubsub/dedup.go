@@ -0,0 +1,103 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// dedupWindow remembers the IDs of the most recently consumed messages of a stream to suppress
+// the duplicates, see SubscribeOptions.Dedup
+type dedupWindow struct {
+	mu         sync.Mutex
+	size       int
+	order      *list.List // IDs, most recently seen first
+	ids        map[string]*list.Element
+	suppressed int64 // accessed atomically
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		size:  size,
+		order: list.New(),
+		ids:   map[string]*list.Element{},
+	}
+}
+
+// seen records the ID and returns true if it's a duplicate of an ID in the window. The least
+// recently seen ID is evicted once the window is full. Empty IDs are never duplicates.
+func (w *dedupWindow) seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e, ok := w.ids[id]; ok {
+		w.order.MoveToFront(e)
+		atomic.AddInt64(&w.suppressed, 1)
+		return true
+	}
+	w.ids[id] = w.order.PushFront(id)
+	for w.order.Len() > w.size {
+		oldest := w.order.Back()
+		w.order.Remove(oldest)
+		delete(w.ids, oldest.Value.(string))
+	}
+	return false
+}
+
+// forget removes the IDs of the messages from the window so that they're delivered when
+// consumed again
+func (w *dedupWindow) forget(msgs []*Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, msg := range msgs {
+		if e, ok := w.ids[msg.ID]; ok {
+			w.order.Remove(e)
+			delete(w.ids, msg.ID)
+		}
+	}
+}
+
+// duplicates returns the number of duplicates suppressed by the window, 0 if w is nil
+func (w *dedupWindow) duplicates() int64 {
+	if w == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&w.suppressed)
+}
+
+// dedupWindows holds the windows of the subscriptions with SubscribeOptions.Dedup by stream. It's
+// shared by the connections re-established with the config, so duplicates are also suppressed
+// across reconnects.
+type dedupWindows struct {
+	sync.Mutex
+	windows map[string]*dedupWindow
+}
+
+// get returns the window of the stream, creating it with the size if the stream doesn't have one
+func (d *dedupWindows) get(stream string, size int) *dedupWindow {
+	d.Lock()
+	defer d.Unlock()
+	if d.windows == nil {
+		d.windows = map[string]*dedupWindow{}
+	}
+	w, ok := d.windows[stream]
+	if !ok {
+		w = newDedupWindow(size)
+		d.windows[stream] = w
+	}
+	return w
+}
+
+// remove drops the windows of the streams
+func (d *dedupWindows) remove(streams []string) {
+	d.Lock()
+	defer d.Unlock()
+	for _, stream := range streams {
+		delete(d.windows, stream)
+	}
+}
@@ -0,0 +1,87 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func dedupMessage(id string) rpc.PublishParams {
+	return rpc.PublishParams{MsgID: id, Payload: base64.StdEncoding.EncodeToString([]byte(id))}
+}
+
+func Test_SubscribeDedup(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+
+	received := make(chan string, 10)
+	handler := func(err error, id string, _ map[string]string, _ []byte) {
+		require.NoError(t, err)
+		received <- id
+	}
+	require.NoError(t, c.Subscribe("dedup-stream", handler, WithDedup(2)))
+
+	// the second msg-1 is a duplicate, msg-2 and then msg-1 are evicted before they're replayed
+	for _, id := range []string{"msg-1", "msg-2", "msg-1", "msg-3", "msg-2", "msg-1"} {
+		require.True(t, test.PublishRaw("dedup-stream", dedupMessage(id)))
+	}
+	for _, expected := range []string{"msg-1", "msg-2", "msg-3", "msg-2", "msg-1"} {
+		select {
+		case id := <-received:
+			require.Equal(t, expected, id)
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+	}
+	require.Eventually(t, func() bool {
+		return len(c.Subscriptions()) == 1 && c.Subscriptions()[0].Duplicates == 1
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case id := <-received:
+		require.FailNow(t, "Duplicate delivered", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the window is dropped once unsubscribed
+	require.NoError(t, c.Unsubscribe("dedup-stream"))
+	require.NoError(t, c.Subscribe("dedup-stream", handler, WithDedup(2)))
+	require.True(t, test.PublishRaw("dedup-stream", dedupMessage("msg-1")))
+	select {
+	case id := <-received:
+		require.Equal(t, "msg-1", id)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+	require.Zero(t, c.Subscriptions()[0].Duplicates)
+}
+
+func Test_DedupWindow(t *testing.T) {
+	w := newDedupWindow(2)
+	require.False(t, w.seen("a"))
+	require.False(t, w.seen("b"))
+	require.True(t, w.seen("a")) // a is the most recently seen, b is evicted next
+	require.False(t, w.seen("c"))
+	require.False(t, w.seen("b"))
+	require.False(t, w.seen(""))
+	require.False(t, w.seen(""))
+
+	w.forget([]*Message{{ID: "b"}})
+	require.False(t, w.seen("b"))
+	require.Equal(t, int64(1), w.duplicates())
+
+	var windows dedupWindows
+	require.Same(t, windows.get("stream", 2), windows.get("stream", 5))
+	first := windows.get("stream", 2)
+	windows.remove([]string{"stream"})
+	require.NotSame(t, first, windows.get("stream", 2))
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"hash/fnv"
+)
+
+// HeaderPartitionKey is the header that determines the worker delivering a message with
+// SubscribeOptions.Concurrency. The messages with the same key are delivered in order.
+const HeaderPartitionKey = "partition-key"
+
+// startWorkers starts the worker goroutines of a subscription with SubscribeOptions.Concurrency
+func (c *internalConnection) startWorkers(sub *subscription) {
+	sub.workers = make([]chan *Message, sub.opts.Concurrency)
+	for i := range sub.workers {
+		// a single message waits for each worker, then the subscriber goroutine blocks until the
+		// worker is done, which holds back the next consume request
+		sub.workers[i] = make(chan *Message, 1)
+		c.wg.Add(1)
+		sub.wg.Add(1)
+		go c.worker(sub, sub.workers[i])
+	}
+}
+
+// stopWorkers stops the worker goroutines once they delivered the messages already dispatched
+func (sub *subscription) stopWorkers() {
+	for _, w := range sub.workers {
+		close(w)
+	}
+}
+
+// dispatch delivers the message to the callback, through the worker of its partition key with
+// SubscribeOptions.Concurrency. It blocks while the worker is busy.
+func (c *internalConnection) dispatch(sub *subscription, msg *Message) {
+	if sub.workers == nil {
+		c.deliverMessage(sub, msg)
+		return
+	}
+	key, ok := msg.Headers[HeaderPartitionKey]
+	if !ok {
+		// no ordering to preserve
+		key = msg.ID
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	select {
+	case sub.workers[h.Sum32()%uint32(len(sub.workers))] <- msg:
+	case <-sub.ctx.Done():
+	}
+}
+
+// worker delivers the messages dispatched to it in order
+func (c *internalConnection) worker(sub *subscription, msgs <-chan *Message) {
+	defer sub.wg.Done()
+	defer c.wg.Done()
+	for msg := range msgs {
+		if sub.ctx.Err() == nil {
+			c.deliverMessage(sub, msg)
+		}
+	}
+}
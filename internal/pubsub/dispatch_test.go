@@ -0,0 +1,126 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+// keyedMessages returns n messages for each of the keys, numbered in order for each key
+func keyedMessages(stream string, keys, n int) []rpc.PublishParams {
+	var msgs []rpc.PublishParams
+	for i := 0; i < n; i++ {
+		for k := 0; k < keys; k++ {
+			msgs = append(msgs, rpc.PublishParams{
+				MsgID:   fmt.Sprintf("msg-%d-%d", k, i),
+				Stream:  stream,
+				Payload: base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(i))),
+				Headers: map[string]string{HeaderPartitionKey: "key-" + strconv.Itoa(k)},
+			})
+		}
+	}
+	return msgs
+}
+
+func Test_SubscribeConcurrency(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	var mu sync.Mutex
+	received := map[string][]string{}
+	var active, maxActive int32
+	_, err := c.subscribe("concurrent-stream", "", func(err error, _ string, headers map[string]string, payload []byte) {
+		require.NoError(t, err)
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		key := headers[HeaderPartitionKey]
+		received[key] = append(received[key], string(payload))
+		mu.Unlock()
+	}, WithConcurrency(4))
+	require.NoError(t, err)
+
+	require.True(t, test.PublishRaw("concurrent-stream", keyedMessages("concurrent-stream", 8, 10)...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitForConsumed(ctx, "concurrent-stream", 80))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 8)
+	for key, payloads := range received {
+		for i, p := range payloads {
+			require.Equal(t, strconv.Itoa(i), p, "out of order for %s", key)
+		}
+	}
+	require.Greater(t, atomic.LoadInt32(&maxActive), int32(1))
+}
+
+func Test_SubscribeConcurrencyCredits(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {}, WithConcurrency(4), WithInitialCredits(10))
+	require.True(t, errors.Is(err, ErrConcurrencyUnsupported), "unexpected error: %v", err)
+}
+
+func Benchmark_SlowCallback(b *testing.B) {
+	s := test.NewRPCServer(b, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			c := newTestConnection(b, s, Config{
+				PollInterval: time.Millisecond,
+			})
+			defer c.disconnect()
+
+			stream := fmt.Sprintf("bench-stream-%d-%d", concurrency, b.N)
+			_, err := c.subscribe(stream, "", func(error, string, map[string]string, []byte) {
+				time.Sleep(time.Millisecond)
+			}, WithConcurrency(concurrency))
+			require.NoError(b, err)
+
+			b.ResetTimer()
+			require.True(b, test.PublishRaw(stream, keyedMessages(stream, 64, (b.N+63)/64)...))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			require.NoError(b, c.WaitForConsumed(ctx, stream, b.N))
+		})
+	}
+}
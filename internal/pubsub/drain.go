@@ -0,0 +1,79 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+)
+
+// stopConsuming tells the subscriber goroutine to stop issuing new consume requests. The response
+// to a consume request that's already in flight is still delivered to the callback.
+func (sub *subscription) stopConsuming() {
+	sub.drainOnce.Do(func() {
+		close(sub.drain)
+	})
+}
+
+// Drain stops issuing new consume requests for all the subscriptions and waits until the responses
+// to the consume requests already in flight are delivered to the callbacks, then disconnects.
+// If ctx is done before all the responses are delivered, the connection is disconnected anyway
+// and the context error is returned.
+func (c *internalConnection) Drain(ctx context.Context) error {
+	c.subs.Lock()
+	subs := make([]*subscription, 0, len(c.subs.table))
+	for _, sub := range c.subs.table {
+		subs = append(subs, sub)
+	}
+	c.subs.Unlock()
+
+	c.logger().Debugf("Draining %d subscriptions", len(subs))
+	done := make(chan struct{})
+	go func() {
+		for _, sub := range subs {
+			sub.stopConsuming()
+		}
+		for _, sub := range subs {
+			sub.wg.Wait()
+		}
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+		c.logger().Debugf("All subscriptions drained")
+	case <-ctx.Done():
+		err = fmt.Errorf("failed to drain subscriptions: %w", ctx.Err())
+	}
+	c.disconnect()
+	return err
+}
+
+// drainSubscription stops issuing new consume requests for the stream and waits until the response
+// to the consume request already in flight is delivered to the callback, up to ctx. The
+// subscription is left in place, see Connection.DrainSubscription.
+func (c *internalConnection) drainSubscription(ctx context.Context, stream string) error {
+	c.subs.Lock()
+	sub, ok := c.subs.table[stream]
+	c.subs.Unlock()
+	if !ok {
+		return &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	}
+
+	sub.logger.Debugf("Draining subscription for stream %s", stream)
+	sub.stopConsuming()
+	done := make(chan struct{})
+	go func() {
+		sub.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		sub.logger.Debugf("Subscription for stream %s drained", stream)
+		return nil
+	case <-ctx.Done():
+		return &SubscriptionError{Stream: stream, ID: sub.id, Err: fmt.Errorf("failed to drain subscription: %w", ctx.Err())}
+	}
+}
@@ -0,0 +1,145 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Drain(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDelay:      500 * time.Millisecond,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	received := 0
+	_, err := c.subscribe("test-stream", "", func(e error, _ string, _ map[string]string, _ []byte) {
+		require.NoError(t, e)
+		received++
+	})
+	require.NoError(t, err)
+
+	// the first consume request is in flight while the message is published
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = c.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, received)
+	require.True(t, c.isDisconnected())
+
+	select {
+	case err = <-c.Error:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the connection to close")
+	}
+	require.Empty(t, c.SubscriptionSnapshot())
+}
+
+func Test_DrainTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDelay:      time.Second,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	// the first consume request is in flight
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = c.Drain(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.True(t, c.isDisconnected())
+}
+
+func Test_DrainSubscription(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDelay:      300 * time.Millisecond,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+
+	var received int32
+	err := c.Subscribe("drain-stream", func(e error, _ string, _ map[string]string, _ []byte) {
+		require.NoError(t, e)
+		atomic.AddInt32(&received, 1)
+	})
+	require.NoError(t, err)
+	err = c.Subscribe("drain-other-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	id := c.SubscriptionSnapshot()["drain-stream"].ID
+
+	// the first consume request is in flight while the message is published
+	time.Sleep(50 * time.Millisecond)
+	payload := base64.StdEncoding.EncodeToString([]byte("payload"))
+	require.True(t, test.PublishRaw("drain-stream", rpc.PublishParams{MsgID: "msg-1", Payload: payload}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, c.DrainSubscription(ctx, "drain-stream"))
+	require.Equal(t, int32(1), atomic.LoadInt32(&received))
+	require.False(t, test.HasSubscription(id))
+	require.NotContains(t, c.SubscriptionSnapshot(), "drain-stream")
+
+	// the rest of the connection is left alone
+	require.Contains(t, c.SubscriptionSnapshot(), "drain-other-stream")
+	require.False(t, c.IsDisconnected())
+
+	err = c.DrainSubscription(ctx, "drain-stream")
+	require.ErrorIs(t, err, ErrSubscriptionNotFound)
+}
+
+func Test_DrainSubscriptionTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDelay:      time.Second,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	defer c.Disconnect()
+	err := c.Subscribe("drain-timeout-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	id := c.SubscriptionSnapshot()["drain-timeout-stream"].ID
+
+	// the first consume request is in flight
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = c.DrainSubscription(ctx, "drain-timeout-stream")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	var subErr *SubscriptionError
+	require.ErrorAs(t, err, &subErr)
+	require.Equal(t, id, subErr.ID)
+	// unsubscribed anyway
+	require.False(t, test.HasSubscription(id))
+	require.NotContains(t, c.SubscriptionSnapshot(), "drain-timeout-stream")
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var defaultStreamDiscoveryInterval = 30 * time.Second
+
+// SubscribeDynamic subscribes to all the streams whose name matches the regular expression
+// pattern. The streams are listed every Config.StreamDiscoveryInterval, newly matching streams
+// are subscribed and the streams that disappeared are unsubscribed. Streams that are already
+// subscribed by other means are left alone. Discovery stops once ctx is done or the connection is
+// disconnected, the streams subscribed by SubscribeDynamic are unsubscribed when ctx is done.
+//
+// Messages are never delivered twice when subscriptions overlap, each stream has a single
+// subscription: a stream matching the pattern that's already subscribed, explicitly or by another
+// pattern, is left to the existing subscription and the overlap is logged. Subscribing explicitly
+// to a stream subscribed by a pattern fails with ErrOverlappingSubscription.
+//
+// An error is returned if the pattern is invalid or the streams can't be listed initially.
+func (c *Connection) SubscribeDynamic(ctx context.Context, pattern string, handler SubscriptionCallback, opts ...SubscribeOption) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid stream pattern: %w", err)
+	}
+	return c.subscribeDynamic(ctx, re, func(string) SubscriptionCallback { return handler }, opts)
+}
+
+// subscribeDynamic implements SubscribeDynamic, the callback of each stream is obtained from
+// handlerFor
+func (c *Connection) subscribeDynamic(ctx context.Context, re *regexp.Regexp, handlerFor func(stream string) SubscriptionCallback, opts []SubscribeOption) error {
+	if err := c.config.Mode.checkSubscribe(); err != nil {
+		return &SubscriptionError{Stream: re.String(), Err: err}
+	}
+	streams, err := c.current().listStreams()
+	if err != nil {
+		return err
+	}
+	subscribed := map[string]bool{}
+	overlapping := map[string]bool{}
+	c.discoverStreams(re, streams, subscribed, overlapping, handlerFor, opts)
+
+	connCtx := c.ctx
+	if connCtx == nil {
+		connCtx = context.Background()
+	}
+	go func() {
+		ticker := time.NewTicker(c.config.StreamDiscoveryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				streams, err := c.current().listStreams()
+				if err != nil {
+					c.logger().Errorf("Failed to list streams matching %s: %v", re, err)
+					continue
+				}
+				c.discoverStreams(re, streams, subscribed, overlapping, handlerFor, opts)
+			case <-ctx.Done():
+				for stream := range subscribed {
+					if err := c.Unsubscribe(stream); err != nil {
+						c.logger().Errorf("Failed to unsubscribe from stream %s: %v", stream, err)
+					}
+				}
+				return
+			case <-connCtx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// discoverStreams subscribes to the listed streams matching re and unsubscribes from the
+// subscribed streams that aren't listed anymore. The matching streams already subscribed by other
+// means are added to overlapping so that the overlap is only logged once.
+func (c *Connection) discoverStreams(re *regexp.Regexp, streams []string, subscribed, overlapping map[string]bool, handlerFor func(stream string) SubscriptionCallback, opts []SubscribeOption) {
+	listed := map[string]bool{}
+	for _, stream := range streams {
+		if !re.MatchString(stream) {
+			continue
+		}
+		listed[stream] = true
+		if subscribed[stream] {
+			continue
+		}
+		err := c.Subscribe(stream, handlerFor(stream), opts...)
+		if errors.Is(err, ErrSubscriptionExists) || errors.Is(err, ErrOverlappingSubscription) {
+			if !overlapping[stream] {
+				c.logger().Warnf("Stream %s matching %s is already subscribed, its messages are only delivered to the existing subscription", stream, re)
+				overlapping[stream] = true
+			}
+			continue
+		}
+		if err != nil {
+			c.logger().Errorf("Failed to subscribe to discovered stream %s: %v", stream, err)
+			continue
+		}
+		c.logger().Infof("Subscribed to discovered stream %s", stream)
+		subscribed[stream] = true
+		delete(overlapping, stream)
+		c.mu.Lock()
+		if sub, ok := c.subscriptions[stream]; ok {
+			sub.pattern = re.String()
+			c.subscriptions[stream] = sub
+		}
+		c.mu.Unlock()
+	}
+	for stream := range subscribed {
+		if listed[stream] {
+			continue
+		}
+		if err := c.Unsubscribe(stream); err != nil {
+			c.logger().Errorf("Failed to unsubscribe from disappeared stream %s: %v", stream, err)
+			continue
+		}
+		c.logger().Infof("Unsubscribed from disappeared stream %s", stream)
+		delete(subscribed, stream)
+	}
+}
+
+// checkOverlap returns a *SubscriptionError wrapping ErrOverlappingSubscription if the stream is
+// subscribed by a SubscribeDynamic pattern
+func (c *Connection) checkOverlap(stream string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sub, ok := c.subscriptions[stream]; ok && sub.pattern != "" {
+		return &SubscriptionError{
+			Stream: stream,
+			ID:     sub.subscriptionID,
+			Err:    fmt.Errorf("%w: stream matches pattern %s", ErrOverlappingSubscription, sub.pattern),
+		}
+	}
+	return nil
+}
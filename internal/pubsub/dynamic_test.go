@@ -0,0 +1,138 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscribeDynamic(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		StreamsPath:       apiPaths.streams,
+	})
+	defer s.Close()
+
+	test.CreateStream("dynamic-a")
+	test.CreateStream("static-a")
+	defer test.DeleteStream("dynamic-a")
+	defer test.DeleteStream("dynamic-b")
+	defer test.DeleteStream("static-a")
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval:            10 * time.Millisecond,
+		StreamDiscoveryInterval: 50 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	require.Error(t, c.SubscribeDynamic(context.Background(), "[", nil))
+
+	payloads := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := c.SubscribeDynamic(ctx, "^dynamic-", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		payloads <- string(payload)
+	})
+	require.NoError(t, err)
+	require.NotNil(t, c.Subscription("dynamic-a"))
+	require.Nil(t, c.Subscription("static-a"))
+
+	// a new matching stream is subscribed
+	test.CreateStream("dynamic-b")
+	require.Eventually(t, func() bool {
+		return c.Subscription("dynamic-b") != nil
+	}, time.Second, 10*time.Millisecond)
+	pubCtx, pubCancel := context.WithTimeout(context.Background(), time.Second)
+	defer pubCancel()
+	_, err = c.Publish(pubCtx, "dynamic-b", nil, []byte("test payload"))
+	require.NoError(t, err)
+	select {
+	case p := <-payloads:
+		require.Equal(t, "test payload", p)
+	case <-time.After(time.Second):
+		t.Fatal("message of the discovered stream wasn't consumed")
+	}
+
+	// a disappeared stream is unsubscribed
+	test.DeleteStream("dynamic-a")
+	require.Eventually(t, func() bool {
+		return c.Subscription("dynamic-a") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the discovered streams are unsubscribed once ctx is done
+	cancel()
+	require.Eventually(t, func() bool {
+		return c.Subscription("dynamic-b") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_SubscribeDynamicOverlap(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		StreamsPath:       apiPaths.streams,
+	})
+	defer s.Close()
+
+	test.CreateStream("sensor.temp")
+	test.CreateStream("sensor.humidity")
+	defer test.DeleteStream("sensor.temp")
+	defer test.DeleteStream("sensor.humidity")
+
+	logger := &testLogger{}
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval:            10 * time.Millisecond,
+		StreamDiscoveryInterval: 50 * time.Millisecond,
+		Logger:                  logger,
+	})
+	defer c.Disconnect()
+
+	var mu sync.Mutex
+	received := map[string][]string{}
+	handler := func(name string) SubscriptionCallback {
+		return func(err error, _ string, _ map[string]string, payload []byte) {
+			require.NoError(t, err)
+			mu.Lock()
+			received[name] = append(received[name], string(payload))
+			mu.Unlock()
+		}
+	}
+
+	// the explicit subscription is left alone by the pattern
+	require.NoError(t, c.Subscribe("sensor.temp", handler("explicit")))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, c.SubscribeDynamic(ctx, `^sensor\.`, handler("pattern")))
+	require.True(t, logger.contains("Stream sensor.temp matching ^sensor\\. is already subscribed"))
+
+	// a second pattern doesn't take over the streams of the first one
+	require.NoError(t, c.SubscribeDynamic(ctx, `humidity$`, handler("other pattern")))
+
+	// the explicit subscription of a stream subscribed by a pattern is rejected
+	err := c.Subscribe("sensor.humidity", handler("explicit"))
+	require.ErrorIs(t, err, ErrOverlappingSubscription)
+
+	for _, stream := range []string{"sensor.temp", "sensor.humidity"} {
+		pubCtx, pubCancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(pubCtx, stream, nil, []byte(stream))
+		pubCancel()
+		require.NoError(t, err)
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+		require.NoError(t, c.WaitForConsumed(waitCtx, stream, 1))
+		waitCancel()
+	}
+
+	// each message is delivered once
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string][]string{
+		"explicit": {"sensor.temp"},
+		"pattern":  {"sensor.humidity"},
+	}, received)
+}
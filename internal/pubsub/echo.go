@@ -0,0 +1,55 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// headerEchoCorrelationID correlates the message published by Echo with the message received
+const headerEchoCorrelationID = "echo-correlation-id"
+
+// Echo subscribes to the stream, publishes the payload to it and waits for the payload to be
+// received back through the subscription. The received payload is returned. The temporary
+// subscription is always removed before returning, so the connection must not already be
+// subscribed to the stream.
+func (c *internalConnection) Echo(ctx context.Context, stream string, payload []byte) ([]byte, error) {
+	correlationID := uuid.NewString()
+	recvCh := make(chan []byte, 1)
+	_, err := c.subscribe(stream, "", func(err error, id string, headers map[string]string, p []byte) {
+		if err != nil || headers[headerEchoCorrelationID] != correlationID {
+			return
+		}
+		select {
+		case recvCh <- p:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("echo failure: %w", err)
+	}
+	defer func() {
+		if err := c.unsubscribe(stream); err != nil {
+			c.logger().Errorf("Failed to remove echo subscription for stream %s: %v", stream, err)
+		}
+	}()
+
+	r, err := c.Publish(ctx, stream, map[string]string{headerEchoCorrelationID: correlationID}, payload)
+	if err != nil {
+		return nil, fmt.Errorf("echo failure: %w", err)
+	}
+	if r.Error != nil {
+		return nil, fmt.Errorf("echo failure: %w", r.Error)
+	}
+
+	select {
+	case p := <-recvCh:
+		return p, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("echo failure: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Echo(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	payload, err := c.Echo(ctx, "test-stream", []byte("echo payload"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("echo payload"), payload)
+
+	require.Empty(t, c.SubscriptionSnapshot(), "echo subscription was not removed")
+}
+
+func Test_EchoTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeError:      true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := c.Echo(ctx, "test-stream", []byte("echo payload"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.Empty(t, c.SubscriptionSnapshot(), "echo subscription was not removed")
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"encoding/base64"
+)
+
+// PayloadEncoding represents the base64 variant the payloads are encoded with for transport
+type PayloadEncoding int
+
+const (
+	// PayloadEncodingStd is the standard base64 encoding with padding, see RFC 4648
+	PayloadEncodingStd PayloadEncoding = iota
+	// PayloadEncodingURL is the URL-safe base64 encoding with padding
+	PayloadEncodingURL
+	// PayloadEncodingRawStd is the standard base64 encoding without padding
+	PayloadEncodingRawStd
+	// PayloadEncodingRawURL is the URL-safe base64 encoding without padding
+	PayloadEncodingRawURL
+)
+
+var payloadEncodings = []*base64.Encoding{
+	PayloadEncodingStd:    base64.StdEncoding,
+	PayloadEncodingURL:    base64.URLEncoding,
+	PayloadEncodingRawStd: base64.RawStdEncoding,
+	PayloadEncodingRawURL: base64.RawURLEncoding,
+}
+
+func (e PayloadEncoding) String() string {
+	switch e {
+	case PayloadEncodingStd:
+		return "Std"
+	case PayloadEncodingURL:
+		return "URL"
+	case PayloadEncodingRawStd:
+		return "RawStd"
+	case PayloadEncodingRawURL:
+		return "RawURL"
+	}
+	return "Unknown"
+}
+
+// valid returns true if e is one of the PayloadEncoding constants
+func (e PayloadEncoding) valid() bool {
+	return e >= 0 && int(e) < len(payloadEncodings)
+}
+
+// encode encodes the payload with the encoding
+func (e PayloadEncoding) encode(payload []byte) string {
+	return payloadEncodings[e].EncodeToString(payload)
+}
+
+// decode decodes the payload with the encoding. If that fails and fallback is true, the other
+// variants are tried in the order of the constants, the error of the encoding is returned if none
+// of them succeeds.
+func (e PayloadEncoding) decode(encoded string, fallback bool) ([]byte, error) {
+	payload, err := payloadEncodings[e].DecodeString(encoded)
+	if err == nil || !fallback {
+		return payload, err
+	}
+	for i, enc := range payloadEncodings {
+		if PayloadEncoding(i) == e {
+			continue
+		}
+		if p, e := enc.DecodeString(encoded); e == nil {
+			return p, nil
+		}
+	}
+	return nil, err
+}
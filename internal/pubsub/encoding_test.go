@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+// encodingPayload encodes to characters that differ between the standard and URL-safe variants and
+// needs padding
+var encodingPayload = []byte{0xfb, 0xff, 0xbf, 0x3e}
+
+func Test_PayloadEncodingRoundTrip(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	encodings := []PayloadEncoding{PayloadEncodingStd, PayloadEncodingURL, PayloadEncodingRawStd, PayloadEncodingRawURL}
+	for _, encoding := range encodings {
+		t.Run(encoding.String(), func(t *testing.T) {
+			c := newTestConnection(t, s, Config{
+				PollInterval:    10 * time.Millisecond,
+				PayloadEncoding: encoding,
+			})
+			defer c.disconnect()
+
+			stream := "encoding-stream-" + encoding.String()
+			received := make(chan []byte, 1)
+			_, err := c.subscribe(stream, "", func(err error, _ string, _ map[string]string, payload []byte) {
+				require.NoError(t, err)
+				received <- payload
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			r, err := c.Publish(ctx, stream, nil, encodingPayload)
+			require.NoError(t, err)
+			require.NoError(t, r.Error)
+
+			select {
+			case payload := <-received:
+				require.Equal(t, encodingPayload, payload)
+			case <-time.After(time.Second):
+				require.FailNow(t, "Consume timed out")
+			}
+		})
+	}
+}
+
+func Test_PayloadEncodingFallback(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	for _, fallback := range []bool{false, true} {
+		c := newTestConnection(t, s, Config{
+			PollInterval:            10 * time.Millisecond,
+			PayloadEncodingFallback: fallback,
+		})
+
+		type result struct {
+			payload []byte
+			err     error
+		}
+		received := make(chan result, 1)
+		_, err := c.subscribe("encoding-fallback-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+			received <- result{payload: payload, err: err}
+		})
+		require.NoError(t, err)
+
+		// the server emits URL-safe base64 without padding
+		require.True(t, test.PublishRaw("encoding-fallback-stream", rpc.PublishParams{
+			MsgID:   "msg-1",
+			Payload: base64.RawURLEncoding.EncodeToString(encodingPayload),
+		}))
+
+		select {
+		case r := <-received:
+			if fallback {
+				require.NoError(t, r.err)
+				require.Equal(t, encodingPayload, r.payload)
+			} else {
+				require.ErrorIs(t, r.err, ErrInvalidPayload)
+			}
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+		c.disconnect()
+	}
+}
+
+func Test_PayloadEncodingInvalid(t *testing.T) {
+	_, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  "localhost",
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		PayloadEncoding: PayloadEncoding(4),
+	})
+	require.ErrorContains(t, err, "PayloadEncoding")
+}
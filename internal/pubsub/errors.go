@@ -0,0 +1,230 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+)
+
+var (
+	// ErrPayloadTooLarge is returned when a payload exceeds Config.MaxPayloadBytes
+	ErrPayloadTooLarge = errors.New("payload too large")
+
+	// ErrHeadersTooLarge is returned when the headers exceed Config.MaxHeaderBytes
+	ErrHeadersTooLarge = errors.New("headers too large")
+
+	// ErrInvalidHeader is returned when a header key or value contains invalid characters
+	ErrInvalidHeader = errors.New("invalid header")
+
+	// ErrWriterBusy is returned when a request can't be queued because the writer is busy
+	ErrWriterBusy = errors.New("writer is busy")
+
+	// ErrTooManyInFlight is returned by PublishAsync when Config.MaxInFlightPublishes publishes
+	// are waiting for their response
+	ErrTooManyInFlight = errors.New("too many publishes in flight")
+
+	// ErrAckTimeout is the error of a publish whose response didn't arrive within
+	// Config.AckCorrelationTTL or Config.DefaultRPCTimeout
+	ErrAckTimeout = errors.New("timed out waiting for publish ack")
+
+	// ErrCreditsUnsupported is returned when SubscribeOptions.InitialCredits is set for a
+	// subscription with ack or with AckModeManual
+	ErrCreditsUnsupported = errors.New("flow control credits unsupported with acks")
+
+	// ErrConcurrencyUnsupported is returned when SubscribeOptions.Concurrency is set along with
+	// SubscribeOptions.InitialCredits
+	ErrConcurrencyUnsupported = errors.New("concurrency unsupported with flow control credits")
+
+	// ErrSubscriptionExists is returned when subscribing to a stream that's already subscribed
+	ErrSubscriptionExists = errors.New("subscription already exists")
+
+	// ErrOverlappingSubscription is returned when subscribing explicitly to a stream that's already
+	// subscribed by SubscribeDynamic
+	ErrOverlappingSubscription = errors.New("stream already subscribed by a pattern")
+
+	// ErrSubscriptionNotFound is returned when unsubscribing from a stream that's not subscribed
+	ErrSubscriptionNotFound = errors.New("subscription doesn't exist")
+
+	// ErrReconnectExhausted is sent to the Error channel when all the reconnect attempts failed
+	ErrReconnectExhausted = errors.New("reconnect attempts exhausted")
+
+	// ErrNotConnected is returned when the operation requires the connection to be open
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrAborted is sent to the Error channel when the connection is aborted
+	ErrAborted = errors.New("connection aborted")
+
+	// ErrProtocolUnsupported is returned when the server doesn't support Config.MinProtocolVersion
+	ErrProtocolUnsupported = errors.New("protocol version unsupported")
+
+	// ErrEmptySubscriptionID is returned when the server creates a subscription without an ID
+	ErrEmptySubscriptionID = errors.New("received empty subscription ID")
+
+	// ErrInvalidPayload is delivered to the callback along with a message whose payload can't be
+	// decoded
+	ErrInvalidPayload = errors.New("invalid payload")
+
+	// ErrUnsupportedContentType is delivered to the callback of SubscribeJSON along with a message
+	// whose content-type header isn't JSON
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+
+	// ErrRateLimited is returned by Publish when Config.PublishRateLimit doesn't allow the publish
+	// before the deadline of its context
+	ErrRateLimited = errors.New("publish rate limit exceeded")
+
+	// ErrOutboxFull is returned by Publish while reconnecting when Config.OutboxSize publishes are
+	// already held
+	ErrOutboxFull = errors.New("outbox full")
+
+	// ErrInvalidChunk is returned when reading a chunked payload of SubscribeReader whose chunks
+	// are missing or out of order
+	ErrInvalidChunk = errors.New("invalid chunk")
+
+	// ErrRedeliveryLimit is delivered to the AckSubscriptionCallback along with a message that was
+	// nacked after being redelivered Config.MaxRedeliveries times
+	ErrRedeliveryLimit = errors.New("redelivery limit reached")
+
+	// ErrLagUnknown is returned by Subscription.Lag until the subscription consumed a message whose
+	// offset is reported by the server
+	ErrLagUnknown = errors.New("lag unknown")
+
+	// ErrReadOnly is returned by the publishes of a connection with Config.Mode set to ModeReadOnly
+	ErrReadOnly = errors.New("connection is read-only")
+
+	// ErrWriteOnly is returned by the subscriptions of a connection with Config.Mode set to
+	// ModeWriteOnly
+	ErrWriteOnly = errors.New("connection is write-only")
+
+	// ErrCircuitOpen is delivered to the subscription callback when its circuit breaker opens after
+	// Config.CircuitBreakerThreshold consecutive consume errors
+	ErrCircuitOpen = errors.New("circuit open")
+)
+
+// SubscriptionError is returned by the subscription operations. It identifies the stream and,
+// if known, the subscription ID the failure relates to.
+type SubscriptionError struct {
+	Stream string // stream name
+	ID     string // subscription ID, empty if the subscription wasn't created
+	Err    error  // underlying cause
+}
+
+func (e *SubscriptionError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("subscription for stream %s: %v", e.Stream, e.Err)
+	}
+	return fmt.Sprintf("subscription %s for stream %s: %v", e.ID, e.Stream, e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// RPCError represents an error returned by the server in an RPC response
+type RPCError struct {
+	Code    int
+	Message string
+
+	// RetryAfter is the delay before retrying requested by the server with a throttle error, 0 if
+	// none, see Throttled
+	RetryAfter time.Duration
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// Temporary returns true if the error is a server error that may succeed on a retry.
+// JSON-RPC reserves the codes from -32099 to -32000 for implementation-defined server errors.
+func (e *RPCError) Temporary() bool {
+	return e.Code >= -32099 && e.Code <= -32000
+}
+
+// Throttled returns true if the server rejected the request because the client exceeds its rate.
+// Throttle errors are temporary.
+func (e *RPCError) Throttled() bool {
+	return e.Code == rpc.CodeThrottled
+}
+
+// LimitError is returned when a message exceeds one of the configured size limits.
+// It wraps either ErrPayloadTooLarge or ErrHeadersTooLarge.
+type LimitError struct {
+	Err   error // ErrPayloadTooLarge or ErrHeadersTooLarge
+	Size  int   // size of the rejected payload or headers in bytes
+	Limit int   // configured limit in bytes
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %d bytes exceeds the limit of %d bytes", e.Err, e.Size, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError aggregates the errors of a bulk operation that carries on after a failure, e.g.
+// UnsubscribeAll. The subscription operations add a *SubscriptionError per failed stream.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Streams returns the streams of the *SubscriptionError errors, in order
+func (e *MultiError) Streams() []string {
+	var streams []string
+	for _, err := range e.Errors {
+		var subErr *SubscriptionError
+		if errors.As(err, &subErr) {
+			streams = append(streams, subErr.Stream)
+		}
+	}
+	return streams
+}
+
+// Is returns true if one of the aggregated errors matches target
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// PoolError is sent to the Error channel of a ConnectionPool when one of its connections fails.
+type PoolError struct {
+	Index int   // index of the failed connection in the pool
+	Err   error // error sent to the Error channel of the connection
+}
+
+func (e *PoolError) Error() string {
+	return fmt.Sprintf("pool connection %d: %v", e.Index, e.Err)
+}
+
+func (e *PoolError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError describes a panic of a subscription callback, see Config.PanicHandler
+type PanicError struct {
+	Stream    string      // stream name
+	MessageID string      // ID of the message being delivered, empty for an error without message
+	Value     interface{} // value passed to panic
+	Stack     []byte      // stack trace of the goroutine that panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("callback for message %s of stream %s panicked: %v", e.MessageID, e.Stream, e.Value)
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscriptionErrors(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	_, err := c.subscribe("test-stream", "", handler)
+	require.NoError(t, err)
+
+	_, err = c.subscribe("test-stream", "", handler)
+	require.True(t, errors.Is(err, ErrSubscriptionExists))
+	var subErr *SubscriptionError
+	require.True(t, errors.As(err, &subErr))
+	require.Equal(t, "test-stream", subErr.Stream)
+
+	err = c.unsubscribe("unknown-stream")
+	require.True(t, errors.Is(err, ErrSubscriptionNotFound))
+	require.True(t, errors.As(err, &subErr))
+	require.Equal(t, "unknown-stream", subErr.Stream)
+	require.Equal(t, "subscription for stream unknown-stream: subscription doesn't exist", err.Error())
+
+	require.NoError(t, c.unsubscribe("test-stream"))
+}
+
+func Test_SubscriptionCreateError(t *testing.T) {
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  "example.com",
+		APIKeyProvider: func() ([]byte, error) {
+			return nil, errors.New("no key")
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	var subErr *SubscriptionError
+	require.True(t, errors.As(err, &subErr))
+	require.Equal(t, "test-stream", subErr.Stream)
+	require.Empty(t, subErr.ID)
+	require.EqualError(t, errors.Unwrap(subErr.Err), "no key")
+}
+
+func Test_UnsubscribeDeleteError(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		DeleteError:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	id, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	err = c.unsubscribe("test-stream")
+	var subErr *SubscriptionError
+	require.True(t, errors.As(err, &subErr))
+	require.Equal(t, id, subErr.ID)
+	require.Contains(t, err.Error(), "500")
+
+	_, ok := c.SubscriptionSnapshot()["test-stream"]
+	require.True(t, ok, "subscription should be preserved when the deletion fails")
+
+	c.disconnect()
+	require.True(t, c.isDisconnected())
+	require.Empty(t, c.SubscriptionSnapshot())
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FlushError is returned by Flush when publishes are still waiting for their response
+type FlushError struct {
+	IDs []string // IDs of the unacked publishes, sorted
+	Err error    // error of the context, or ErrNotConnected if the connection was closed
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf("flush failure: %d publishes unacked [%s]: %v", len(e.IDs), strings.Join(e.IDs, ", "), e.Err)
+}
+
+func (e *FlushError) Unwrap() error {
+	return e.Err
+}
+
+// pendingPublishes tracks the IDs of the in-flight publishes for Flush
+type pendingPublishes struct {
+	sync.Mutex
+	ids   map[string]struct{}
+	empty chan struct{} // closed once ids becomes empty, nil while it is
+}
+
+func (p *pendingPublishes) add(id string) {
+	p.Lock()
+	defer p.Unlock()
+	if p.ids == nil {
+		p.ids = map[string]struct{}{}
+	}
+	if len(p.ids) == 0 {
+		p.empty = make(chan struct{})
+	}
+	p.ids[id] = struct{}{}
+}
+
+func (p *pendingPublishes) remove(id string) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.ids[id]; !ok {
+		return
+	}
+	delete(p.ids, id)
+	if len(p.ids) == 0 {
+		close(p.empty)
+		p.empty = nil
+	}
+}
+
+// wait returns a channel closed once no publish is pending
+func (p *pendingPublishes) wait() <-chan struct{} {
+	p.Lock()
+	defer p.Unlock()
+	if p.empty == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return p.empty
+}
+
+// list returns the sorted IDs of the pending publishes
+func (p *pendingPublishes) list() []string {
+	p.Lock()
+	defer p.Unlock()
+	ids := make([]string, 0, len(p.ids))
+	for id := range p.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Flush waits until all the publishes sent so far, e.g. with PublishAsync, received their
+// response, or until ctx is done. A *FlushError listing the unacked publishes is returned if ctx
+// is done or the connection is closed first. PublishNoAck publishes aren't tracked.
+func (c *internalConnection) Flush(ctx context.Context) error {
+	select {
+	case <-c.pendingPublishes.wait():
+		return nil
+	case <-ctx.Done():
+		return &FlushError{IDs: c.pendingPublishes.list(), Err: ctx.Err()}
+	case <-c.closed:
+		return &FlushError{IDs: c.pendingPublishes.list(), Err: ErrNotConnected}
+	}
+}
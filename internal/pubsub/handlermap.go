@@ -16,15 +16,22 @@ import (
 type handlerMap struct {
 	expireDuration  time.Duration
 	newExpireTime   time.Time
-	currentHandlers map[string]func(*rpc.Response)
-	olderHandlers   map[string]func(*rpc.Response)
+	currentHandlers map[string]handlerEntry
+	olderHandlers   map[string]handlerEntry
 	sync.Mutex
 }
 
+// handlerEntry is the response handler of a request. expire, if set, is invoked instead of the
+// handler when the entry expires.
+type handlerEntry struct {
+	handler func(*rpc.Response)
+	expire  func()
+}
+
 func NewHandlerMap(expireDuration time.Duration) *handlerMap {
 	return &handlerMap{
-		currentHandlers: make(map[string]func(*rpc.Response)),
-		olderHandlers:   make(map[string]func(*rpc.Response)),
+		currentHandlers: make(map[string]handlerEntry),
+		olderHandlers:   make(map[string]handlerEntry),
 		expireDuration:  expireDuration,
 		newExpireTime:   time.Now().Add(expireDuration),
 	}
@@ -34,20 +41,37 @@ func (h *handlerMap) GetAndDelete(id string) func(*rpc.Response) {
 	h.expireCheck()
 	h.Lock()
 	defer h.Unlock()
-	handler := h.currentHandlers[id]
+	entry, ok := h.currentHandlers[id]
 	delete(h.currentHandlers, id)
-	if handler == nil {
-		handler = h.olderHandlers[id]
+	if !ok {
+		entry = h.olderHandlers[id]
 		delete(h.olderHandlers, id)
 	}
-	return handler
+	return entry.handler
 }
 
-func (h *handlerMap) Set(id string, handler func(*rpc.Response)) {
+// Set registers the handler of id. If the response doesn't arrive before the entry expires,
+// expire is invoked or, if nil, the handler is invoked with an error response.
+func (h *handlerMap) Set(id string, handler func(*rpc.Response), expire func()) {
 	h.expireCheck()
 	h.Lock()
 	defer h.Unlock()
-	h.currentHandlers[id] = handler
+	h.currentHandlers[id] = handlerEntry{handler: handler, expire: expire}
+}
+
+// Delete removes the handler of id, if any, without invoking it
+func (h *handlerMap) Delete(id string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.currentHandlers, id)
+	delete(h.olderHandlers, id)
+}
+
+// Len returns the number of handlers, including the ones about to expire
+func (h *handlerMap) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.currentHandlers) + len(h.olderHandlers)
 }
 
 // expireCheck deletes older and moves current to older
@@ -59,9 +83,13 @@ func (h *handlerMap) expireCheck() {
 	h.newExpireTime = time.Now().Add(h.expireDuration)
 	expiredHandlers := h.olderHandlers
 	h.olderHandlers = h.currentHandlers
-	h.currentHandlers = make(map[string]func(*rpc.Response))
+	h.currentHandlers = make(map[string]handlerEntry)
 	h.Unlock()
-	for id, handler := range expiredHandlers {
-		handler(rpc.NewErrorResponse(id, fmt.Errorf("timed out waiting for response from server")))
+	for id, entry := range expiredHandlers {
+		if entry.expire != nil {
+			entry.expire()
+			continue
+		}
+		entry.handler(rpc.NewErrorResponse(id, fmt.Errorf("timed out waiting for response from server")))
 	}
 }
@@ -0,0 +1,52 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	sdkModulePath   = "github.com/cisco-pxgrid/cloud-sdk-go"
+	headerUserAgent = "User-Agent"
+)
+
+// defaultUserAgent returns cloud-sdk-go/<version>, where version is the version of the SDK module
+// the application is built with, or "devel" if it's unknown, e.g. when building the SDK itself
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == sdkModulePath && dep.Version != "" {
+				version = dep.Version
+				break
+			}
+		}
+	}
+	return "cloud-sdk-go/" + version
+}
+
+// newRestHeaders returns the headers of the REST requests: Config.RestHeaders and the user agent.
+// The auth headers are left out, they're set by restRequest only.
+func newRestHeaders(config Config) map[string]string {
+	headers := make(map[string]string, len(config.RestHeaders)+1)
+	for k, v := range config.RestHeaders {
+		k = http.CanonicalHeaderKey(k)
+		if k == headerStrApiKey || k == headerStrAuthToken {
+			continue
+		}
+		headers[k] = v
+	}
+	headers[headerUserAgent] = config.UserAgent
+	return headers
+}
+
+// newRestRequest returns a REST request with the headers of the connection and the auth header,
+// which takes precedence
+func (c *internalConnection) newRestRequest(authValue []byte) *resty.Request {
+	return c.restClient.R().SetHeaders(c.restHeaders).SetHeader(c.authHeader.key, string(authValue))
+}
@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RestHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var last http.Header // headers of the last subscription creation
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		OnRequest: func(r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != apiPaths.subscriptions {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			last = r.Header.Clone()
+		},
+	})
+	defer s.Close()
+
+	received := func() http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotNil(t, last)
+		return last
+	}
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+	_, err := c.subscribe("rest-default-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	h := received()
+	require.True(t, strings.HasPrefix(h.Get("User-Agent"), "cloud-sdk-go/"), h.Get("User-Agent"))
+	require.Equal(t, "xyz", h.Get("X-Api-Key"))
+
+	custom := newTestConnection(t, s, Config{
+		UserAgent: "my-app/1.0",
+		RestHeaders: map[string]string{
+			"traceparent": "00-trace-span-01",
+			"X-Route":     "eu",
+			"User-Agent":  "ignored",
+			"x-api-key":   "overridden",
+		},
+	})
+	defer custom.disconnect()
+	_, err = custom.subscribe("rest-custom-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	h = received()
+	require.Equal(t, "my-app/1.0", h.Get("User-Agent"))
+	require.Equal(t, "00-trace-span-01", h.Get("Traceparent"))
+	require.Equal(t, "eu", h.Get("X-Route"))
+	// the auth header takes precedence
+	require.Equal(t, []string{"xyz"}, h.Values("X-Api-Key"))
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Ping sends a WebSocket ping to the server and waits for the pong. It returns an error if the
+// connection is closed or the pong isn't received before ctx is done.
+func (c *internalConnection) Ping(ctx context.Context) error {
+	c.mu.Lock()
+	ws := c.ws
+	closed := c.isClosed()
+	c.mu.Unlock()
+	if ws == nil || closed {
+		return fmt.Errorf("ping failure: %w", ErrNotConnected)
+	}
+	if err := ws.Ping(ctx); err != nil {
+		return fmt.Errorf("ping failure: %w", err)
+	}
+	return nil
+}
+
+// touch records a successful consume or publish
+func (c *internalConnection) touch() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+// LastActivity returns the time of the most recent successful consume or publish, zero if none
+// yet.
+func (c *internalConnection) LastActivity() time.Time {
+	t := atomic.LoadInt64(&c.lastActivity)
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
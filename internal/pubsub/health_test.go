@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Ping(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.ErrorIs(t, c.Ping(ctx), ErrNotConnected)
+
+	c = newTestConnection(t, s, Config{})
+	require.NoError(t, c.Ping(ctx))
+
+	c.disconnect()
+	require.ErrorIs(t, c.Ping(ctx), ErrNotConnected)
+}
+
+func Test_LastActivity(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+	require.True(t, c.LastActivity().IsZero())
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	published := c.LastActivity()
+	require.False(t, published.Before(start))
+
+	_, err = c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return c.LastActivity().After(published)
+	}, time.Second, 10*time.Millisecond)
+}
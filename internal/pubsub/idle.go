@@ -0,0 +1,125 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// use returns the current connection for an operation, reopening it first if it was closed by
+// Config.IdleConnectionTimeout. The connection isn't closed for being idle until release is
+// invoked, which must happen once the operation completes.
+func (c *Connection) use() (conn *internalConnection, release func(), err error) {
+	atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+	for {
+		c.mu.Lock()
+		if c.idle == nil {
+			c.inFlight++
+			conn = c.conn
+			c.mu.Unlock()
+			return conn, c.release, nil
+		}
+		c.mu.Unlock()
+		if err := c.reopen(); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// release ends an operation started with use
+func (c *Connection) release() {
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+}
+
+// reopen re-establishes the connection closed by Config.IdleConnectionTimeout
+func (c *Connection) reopen() error {
+	c.idleMu.Lock()
+	defer c.idleMu.Unlock()
+	c.mu.Lock()
+	idle := c.idle
+	c.mu.Unlock()
+	if idle == nil {
+		// reopened by a concurrent operation
+		return nil
+	}
+	if c.ctx == nil || c.ctx.Err() != nil {
+		return fmt.Errorf("failed to reopen idle connection: %w", ErrNotConnected)
+	}
+
+	c.logger().Infof("Reopening idle connection")
+	if err := c.reconnectOnce(); err != nil {
+		return fmt.Errorf("failed to reopen idle connection: %w", err)
+	}
+	c.mu.Lock()
+	c.idle = nil
+	c.mu.Unlock()
+	close(idle)
+	c.setState(StateConnected)
+	return nil
+}
+
+// idleWatcher closes the connection once it's been idle for Config.IdleConnectionTimeout. The
+// connection is idle while there are no subscriptions, no operations in progress and no activity.
+func (c *Connection) idleWatcher() {
+	timeout := c.config.IdleConnectionTimeout
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.closeIfIdle(timeout)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// closeIfIdle closes the connection if it's been idle for at least timeout
+func (c *Connection) closeIfIdle(timeout time.Duration) {
+	c.idleMu.Lock()
+	defer c.idleMu.Unlock()
+	if c.ctx.Err() != nil {
+		return
+	}
+	conn := c.current()
+	lastUsed := time.Unix(0, atomic.LoadInt64(&c.lastUsed))
+	if a := conn.LastActivity(); a.After(lastUsed) {
+		lastUsed = a
+	}
+	c.mu.Lock()
+	idle := c.idle == nil && c.inFlight == 0 && len(c.subscriptions) == 0 && time.Since(lastUsed) >= timeout
+	if idle {
+		// the operations started from now on reopen the connection
+		c.idle = make(chan struct{})
+	}
+	c.mu.Unlock()
+	if !idle {
+		return
+	}
+
+	c.logger().Infof("Closing connection idle since %v", lastUsed)
+	c.setState(StateIdle)
+	conn.disconnect()
+}
+
+// waitIdle blocks while the connection is closed by Config.IdleConnectionTimeout. It returns
+// false right away if the connection isn't idle, or once ctx is done.
+func (c *Connection) waitIdle() bool {
+	c.mu.Lock()
+	idle := c.idle
+	c.mu.Unlock()
+	if idle == nil {
+		return false
+	}
+	select {
+	case <-idle:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
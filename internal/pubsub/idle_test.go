@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IdleConnectionTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		IdleConnectionTimeout: 200 * time.Millisecond,
+	})
+	defer c.Disconnect()
+	id := c.ID()
+
+	require.Eventually(t, func() bool { return c.State() == StateIdle }, 2*time.Second, 10*time.Millisecond)
+	require.True(t, c.IsDisconnected())
+	select {
+	case err := <-c.Error:
+		t.Fatalf("Unexpected error notification for idle close: %v", err)
+	default:
+	}
+
+	// reopened by the next publish
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	require.Equal(t, StateConnected, c.State())
+	require.False(t, c.IsDisconnected())
+	require.NotEqual(t, id, c.ID())
+
+	// closed again once idle
+	require.Eventually(t, func() bool { return c.State() == StateIdle }, 2*time.Second, 10*time.Millisecond)
+}
+
+func Test_IdleConnectionTimeoutSubscribed(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		IdleConnectionTimeout: 100 * time.Millisecond,
+		PollInterval:          10 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	err := c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	// the subscription keeps the connection alive
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, StateConnected, c.State())
+
+	require.NoError(t, c.Unsubscribe("test-stream"))
+	require.Eventually(t, func() bool { return c.State() == StateIdle }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// acquirePublish reserves an in-flight publish. While Config.MaxInFlightPublishes publishes are in
+// flight, it waits for one of them to complete if wait is true and fails with ErrTooManyInFlight
+// otherwise. The returned function releases the publish once its response is received or given
+// up on, it can be invoked more than once.
+func (c *internalConnection) acquirePublish(ctx context.Context, wait bool) (func(), error) {
+	if c.publishSlots != nil {
+		select {
+		case c.publishSlots <- struct{}{}:
+		default:
+			if !wait {
+				return nil, ErrTooManyInFlight
+			}
+			select {
+			case c.publishSlots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-c.closed:
+				return nil, ErrNotConnected
+			}
+		}
+	}
+	atomic.AddInt32(&c.inFlightPublishes, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt32(&c.inFlightPublishes, -1)
+			if c.publishSlots != nil {
+				<-c.publishSlots
+			}
+		})
+	}, nil
+}
+
+// InFlightPublishes returns the number of publishes waiting for their response from the server
+func (c *internalConnection) InFlightPublishes() int {
+	return int(atomic.LoadInt32(&c.inFlightPublishes))
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"runtime/debug"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+)
+
+// RPCInterceptor observes the RPC messages exchanged with the server, e.g. to log them while
+// debugging. The methods are invoked by the goroutines that write and read the messages, so they
+// hold up all the traffic of the connection until they return.
+type RPCInterceptor interface {
+	// BeforeSend is invoked with every request right before it's written, including publish,
+	// consume and control requests. The request may be modified, but changing its ID breaks the
+	// correlation with its response.
+	BeforeSend(req *rpc.Request)
+
+	// AfterReceive is invoked with every response as soon as it's read, before it's handed to the
+	// pending request. The response may be modified.
+	AfterReceive(resp *rpc.Response)
+}
+
+// intercept invokes fn with Config.RPCInterceptor if set. A panic is recovered and logged so that
+// the interceptor can't break the connection.
+func (c *internalConnection) intercept(fn func(i RPCInterceptor)) {
+	if c.config.RPCInterceptor == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger().Errorf("RPC interceptor panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	fn(c.config.RPCInterceptor)
+}
@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingInterceptor struct {
+	sync.Mutex
+	sent     []rpc.Method
+	received map[string]bool // IDs of the responses
+	panics   bool
+}
+
+func (i *recordingInterceptor) BeforeSend(req *rpc.Request) {
+	i.Lock()
+	i.sent = append(i.sent, req.Method)
+	i.Unlock()
+	if i.panics {
+		panic("before send")
+	}
+}
+
+func (i *recordingInterceptor) AfterReceive(resp *rpc.Response) {
+	i.Lock()
+	i.received[resp.ID] = true
+	i.Unlock()
+	if i.panics {
+		panic("after receive")
+	}
+}
+
+func (i *recordingInterceptor) hasSent(method rpc.Method) bool {
+	i.Lock()
+	defer i.Unlock()
+	for _, m := range i.sent {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *recordingInterceptor) hasReceived(id string) bool {
+	i.Lock()
+	defer i.Unlock()
+	return i.received[id]
+}
+
+func Test_RPCInterceptor(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	for _, panics := range []bool{false, true} {
+		interceptor := &recordingInterceptor{received: map[string]bool{}, panics: panics}
+		c := newTestConnection(t, s, Config{
+			PollInterval:   10 * time.Millisecond,
+			RPCInterceptor: interceptor,
+		})
+
+		received := make(chan struct{}, 1)
+		_, err := c.subscribe("interceptor-stream", "", func(err error, _ string, _ map[string]string, _ []byte) {
+			require.NoError(t, err)
+			received <- struct{}{}
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r, err := c.Publish(ctx, "interceptor-stream", nil, []byte("payload"))
+		cancel()
+		require.NoError(t, err, "panics: %v", panics)
+		require.NoError(t, r.Error)
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+
+		require.True(t, interceptor.hasSent(rpc.MethodOpen))
+		require.True(t, interceptor.hasSent(rpc.MethodPublish))
+		require.True(t, interceptor.hasSent(rpc.MethodConsume))
+		require.True(t, interceptor.hasReceived(r.ID))
+		c.disconnect()
+	}
+}
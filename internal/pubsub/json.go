@@ -0,0 +1,62 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	json "github.com/goccy/go-json"
+)
+
+const (
+	// HeaderContentType is the header that holds the media type of the payload
+	HeaderContentType = "content-type"
+	// ContentTypeJSON is the media type of the payloads published by PublishJSON
+	ContentTypeJSON = "application/json"
+)
+
+// SubscribeJSON subscribes to a DxHub Pubsub Stream like Subscribe and unmarshals the JSON payload
+// of every message into a T for handler. A message that can't be unmarshalled, or whose
+// content-type header is set to another media type than JSON, is delivered with the zero T and an
+// error, which wraps ErrUnsupportedContentType in the latter case.
+func SubscribeJSON[T any](c *Connection, stream string, handler func(msg T, headers map[string]string, err error), opts ...SubscribeOption) error {
+	return c.Subscribe(stream, jsonCallback(handler), opts...)
+}
+
+// jsonCallback returns the SubscriptionCallback that unmarshals the payloads for handler
+func jsonCallback[T any](handler func(msg T, headers map[string]string, err error)) SubscriptionCallback {
+	return func(err error, id string, headers map[string]string, payload []byte) {
+		var msg T
+		if err != nil {
+			handler(msg, headers, err)
+			return
+		}
+		if ct, ok := headers[HeaderContentType]; ok && ct != ContentTypeJSON {
+			handler(msg, headers, fmt.Errorf("message %s: %w: %s", id, ErrUnsupportedContentType, ct))
+			return
+		}
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			var zero T
+			handler(zero, headers, fmt.Errorf("failed to unmarshal message %s: %w", id, err))
+			return
+		}
+		handler(msg, headers, nil)
+	}
+}
+
+// PublishJSON publishes the JSON encoding of v to the stream like Publish with the content-type
+// header set to application/json. The supplied headers aren't modified.
+func (c *Connection) PublishJSON(ctx context.Context, stream string, headers map[string]string, v interface{}) (*PublishResult, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[HeaderContentType] = ContentTypeJSON
+	return c.Publish(ctx, stream, h, payload)
+}
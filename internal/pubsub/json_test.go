@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+type testEvent struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func Test_JSON(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	type delivery struct {
+		event   testEvent
+		headers map[string]string
+		err     error
+	}
+	deliveries := make(chan delivery, 3)
+	err := SubscribeJSON(c, "test-stream", func(event testEvent, headers map[string]string, err error) {
+		deliveries <- delivery{event, headers, err}
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	headers := map[string]string{"k": "v"}
+	_, err = c.PublishJSON(ctx, "test-stream", headers, testEvent{Name: "a", Count: 1})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"k": "v"}, headers)
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("{not json"))
+	require.NoError(t, err)
+	_, err = c.Publish(ctx, "test-stream", map[string]string{HeaderContentType: "text/plain"}, []byte(`{"name":"b"}`))
+	require.NoError(t, err)
+
+	var got []delivery
+	for i := 0; i < 3; i++ {
+		select {
+		case d := <-deliveries:
+			got = append(got, d)
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	require.NoError(t, got[0].err)
+	require.Equal(t, testEvent{Name: "a", Count: 1}, got[0].event)
+	require.Equal(t, ContentTypeJSON, got[0].headers[HeaderContentType])
+	require.Equal(t, "v", got[0].headers["k"])
+
+	require.Error(t, got[1].err)
+	require.Equal(t, testEvent{}, got[1].event)
+
+	require.True(t, errors.Is(got[2].err, ErrUnsupportedContentType))
+	require.Equal(t, testEvent{}, got[2].event)
+}
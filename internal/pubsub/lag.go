@@ -0,0 +1,57 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+// recordOffset records the highest offset of a consume response, -1 if none is known
+func (sub *subscription) recordOffset(offset int64) {
+	sub.stats.Lock()
+	defer sub.stats.Unlock()
+	if offset > sub.stats.lastOffset {
+		sub.stats.lastOffset = offset
+	}
+}
+
+// lag returns the lag of the subscription of the stream, see Subscription.Lag
+func (c *internalConnection) lag(stream string) (int64, error) {
+	c.subs.Lock()
+	sub, ok := c.subs.table[stream]
+	c.subs.Unlock()
+	if !ok {
+		return 0, &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	}
+	sub.stats.Lock()
+	consumed := sub.stats.lastOffset
+	sub.stats.Unlock()
+	if consumed < 0 {
+		return 0, &SubscriptionError{Stream: stream, ID: sub.id, Err: ErrLagUnknown}
+	}
+	latest, err := c.latestOffset(stream)
+	if err != nil {
+		return 0, &SubscriptionError{Stream: stream, ID: sub.id, Err: err}
+	}
+	lag := latest - consumed
+	if lag < 0 {
+		// the stream was consumed past the latest offset in the meantime
+		lag = 0
+	}
+	c.config.Metrics.ObserveLag(stream, lag)
+	return lag, nil
+}
+
+// Lag returns the number of messages published to the stream after the last message consumed by
+// the subscription, i.e. the difference between the offset of the last message of the stream,
+// queried from the server, and the highest offset consumed. It counts messages, not time, see
+// MessageMeta for the time the consumed messages were accepted by the server. Messages that are
+// consumed but not delivered yet, e.g. not acked with AckModeManual, don't count. Offsets are per
+// partition, so the lag is only exact for streams with a single partition.
+// ErrLagUnknown is returned until a message with an offset is consumed. The lag is also reported to
+// Config.Metrics.
+func (s *Subscription) Lag() (int64, error) {
+	conn, release, err := s.conn.use()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return conn.lag(s.stream)
+}
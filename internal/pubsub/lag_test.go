@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscriptionLag(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		StreamsPath:       apiPaths.streams,
+	})
+	defer s.Close()
+
+	metrics := &testMetrics{}
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		Metrics:      metrics,
+	})
+	defer c.Disconnect()
+
+	// the callback blocks until released so that the messages published in the meantime lag
+	received := make(chan string, 10)
+	release := make(chan struct{})
+	err := c.Subscribe("lag-stream", func(err error, id string, _ map[string]string, _ []byte) {
+		require.NoError(t, err)
+		received <- id
+		<-release
+	}, WithBatchSize(1))
+	require.NoError(t, err)
+	sub := c.Subscription("lag-stream")
+
+	_, err = sub.Lag()
+	require.True(t, errors.Is(err, ErrLagUnknown), "unexpected error: %v", err)
+
+	payload := base64.StdEncoding.EncodeToString([]byte("payload"))
+	require.True(t, test.PublishRaw("lag-stream", rpc.PublishParams{MsgID: "msg-1", Payload: payload}))
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+	require.True(t, test.PublishRaw("lag-stream",
+		rpc.PublishParams{MsgID: "msg-2", Payload: payload},
+		rpc.PublishParams{MsgID: "msg-3", Payload: payload}))
+
+	lag, err := sub.Lag()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), lag)
+	metrics.Lock()
+	require.Equal(t, int64(2), metrics.lags["lag-stream"])
+	metrics.Unlock()
+
+	close(release)
+	require.Eventually(t, func() bool {
+		lag, err := sub.Lag()
+		return err == nil && lag == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -6,9 +6,9 @@ package pubsub
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
-	"github.com/cisco-pxgrid/cloud-sdk-go/log"
 )
 
 func (c *internalConnection) sendOpenMessage() error {
@@ -28,17 +28,16 @@ func (c *internalConnection) sendCloseMessage() error {
 }
 
 func (c *internalConnection) sendControlMessage(req *rpc.Request) error {
-	log.Logger.Debugf("Sending control message: %v", req)
-	respCh := make(chan *rpc.Response, 1) // we expect 1 response back
+	c.logger().Debugf("Sending control message: %v", req)
+	respCh, handler := c.singleResponse("control")
 	err := c.sendMessage(req, func(resp *rpc.Response) {
-		log.Logger.Debugf("Received control message response: %v", resp)
-		respCh <- resp
-		close(respCh)
+		c.logger().Debugf("Received control message response: %v", resp)
+		handler(resp)
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send request %v: %v", req, err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	ctx, cancel := context.WithTimeout(c.ctx, defaultTimeout)
 	defer cancel()
 	select {
 	case resp := <-respCh:
@@ -52,11 +51,45 @@ func (c *internalConnection) sendControlMessage(req *rpc.Request) error {
 	return nil
 }
 
+// singleResponse returns the channel that receives the response of a request and the handler that
+// sends it. Only the first response is sent, duplicates for the same request are logged and
+// ignored so that they can neither block the processor nor send on the closed channel.
+func (c *internalConnection) singleResponse(op string) (<-chan *rpc.Response, func(resp *rpc.Response)) {
+	respCh := make(chan *rpc.Response, 1) // we expect 1 response back
+	var once sync.Once
+	return respCh, func(resp *rpc.Response) {
+		sent := false
+		once.Do(func() {
+			respCh <- resp
+			close(respCh)
+			sent = true
+		})
+		if !sent {
+			c.logger().Warnf("Ignoring duplicate %s response %s", op, resp.ID)
+		}
+	}
+}
+
 func (c *internalConnection) sendMessage(req *rpc.Request, handler func(resp *rpc.Response)) error {
+	return c.sendMessageContext(context.Background(), req, handler, nil)
+}
+
+// sendMessageContext queues the request for the writer, the request isn't written if ctx is done
+// by the time the writer gets to it. The handler is registered before the request is queued so
+// that the caller can drop it with msgHandlers.Delete at any time. expire, if set, is invoked
+// instead of the handler if the response doesn't arrive within Config.AckCorrelationTTL.
+func (c *internalConnection) sendMessageContext(ctx context.Context, req *rpc.Request, handler func(resp *rpc.Response), expire func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if handler != nil {
+		c.msgHandlers.Set(req.ID, handler, expire)
+	}
 	select {
-	case c.writerCh <- &msgRequest{req: req, handler: handler}:
+	case c.writerCh <- &msgRequest{ctx: ctx, req: req, handler: handler}:
 		return nil
 	default:
-		return fmt.Errorf("writer is busy")
+		c.msgHandlers.Delete(req.ID)
+		return ErrWriterBusy
 	}
 }
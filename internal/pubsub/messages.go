@@ -0,0 +1,85 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+//go:build go1.23
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// Messages subscribes to the stream like Subscribe and returns an iterator over its messages:
+//
+//	for msg, err := range conn.Messages(ctx, stream) {
+//		...
+//	}
+//
+// The subscription is created when the iteration starts and unsubscribed when it ends, i.e. when
+// the loop breaks, ctx is done or an unrecoverable error is yielded. A message that can't be
+// decoded is yielded with an error wrapping ErrInvalidPayload and the iteration goes on, as it
+// does for the consume errors that are retryable, see IsRetryable. The other errors end the
+// iteration once yielded, e.g. the subscription failure, or the *SubscriptionError yielded when the
+// subscription ends otherwise, which wraps ErrNotConnected if the connection is closed for good.
+// Messages aren't consumed faster than the loop iterates. The messages are yielded as *Message
+// rather than Message values since a Message holds a lock and must not be copied.
+func (c *Connection) Messages(ctx context.Context, stream string, opts ...SubscribeOption) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		type delivery struct {
+			msg *Message
+			err error
+		}
+		deliveries := make(chan delivery)
+		done := make(chan struct{})
+		subCtx, err := c.SubscribeContext(stream, func(err error, id string, headers map[string]string, payload []byte) {
+			d := delivery{err: err}
+			if err == nil || errors.Is(err, ErrInvalidPayload) {
+				d.msg = &Message{ID: id, Headers: headers, Payload: payload, offset: -1}
+			}
+			select {
+			case deliveries <- d:
+			case <-done:
+			}
+		}, opts...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer func() {
+			// release a callback blocked on the delivery before unsubscribing
+			close(done)
+			if subCtx.Err() != nil {
+				// the subscription ended already
+				return
+			}
+			if err := c.Unsubscribe(stream); err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+				c.logger().Errorf("Failed to unsubscribe from stream %s: %v", stream, err)
+			}
+		}()
+
+		for {
+			select {
+			case d := <-deliveries:
+				if !yield(d.msg, d.err) {
+					return
+				}
+				if d.err != nil && d.msg == nil && !IsRetryable(d.err) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-subCtx.Done():
+				if ctx.Err() == nil {
+					err := ErrSubscriptionNotFound
+					if c.IsDisconnected() {
+						err = ErrNotConnected
+					}
+					yield(nil, &SubscriptionError{Stream: stream, Err: err})
+				}
+				return
+			}
+		}
+	}
+}
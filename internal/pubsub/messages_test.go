@@ -0,0 +1,180 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+//go:build go1.23
+
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Messages(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// published once the subscription exists
+	published := make(chan error, 1)
+	go func() {
+		published <- publishWhenSubscribed(ctx, c, "messages-stream", "payload-0", "payload-1", "payload-2", "payload-3", "payload-4")
+	}()
+
+	var payloads []string
+	for msg, err := range c.Messages(ctx, "messages-stream") {
+		require.NoError(t, err)
+		payloads = append(payloads, string(msg.Payload))
+		if len(payloads) == 3 {
+			break
+		}
+	}
+	require.Equal(t, []string{"payload-0", "payload-1", "payload-2"}, payloads)
+	require.NoError(t, <-published)
+	// breaking the loop unsubscribes
+	require.NotContains(t, c.SubscriptionSnapshot(), "messages-stream")
+}
+
+func Test_MessagesEnd(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+
+	// the context ends the iteration
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for _, err := range c.Messages(ctx, "messages-ctx-stream") {
+		require.NoError(t, err)
+	}
+	require.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	require.NotContains(t, c.SubscriptionSnapshot(), "messages-ctx-stream")
+
+	// the subscription failure is yielded
+	require.NoError(t, c.Subscribe("messages-dup-stream", func(error, string, map[string]string, []byte) {}))
+	var errs []error
+	for msg, err := range c.Messages(context.Background(), "messages-dup-stream") {
+		require.Nil(t, msg)
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrSubscriptionExists)
+	// the existing subscription is left alone
+	require.Contains(t, c.SubscriptionSnapshot(), "messages-dup-stream")
+
+	// the closing of the connection is yielded
+	disconnected := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := waitSubscribed(ctx, c, "messages-closed-stream")
+		c.Disconnect()
+		disconnected <- err
+	}()
+	errs = nil
+	for _, err := range c.Messages(context.Background(), "messages-closed-stream") {
+		errs = append(errs, err)
+	}
+	require.NoError(t, <-disconnected)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrNotConnected)
+}
+
+// waitSubscribed waits until the stream is subscribed
+func waitSubscribed(ctx context.Context, c *Connection, stream string) error {
+	for {
+		if _, ok := c.SubscriptionSnapshot()[stream]; ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// publishWhenSubscribed publishes the payloads to the stream once it's subscribed
+func publishWhenSubscribed(ctx context.Context, c *Connection, stream string, payloads ...string) error {
+	if err := waitSubscribed(ctx, c, stream); err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		if _, err := c.Publish(ctx, stream, nil, []byte(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Example_messages() {
+	s := test.NewRPCServer(exampleTB{}, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	conn, err := NewConnection(Config{
+		GroupID: "group",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("api-key"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.Connect(ctx); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer conn.Disconnect()
+
+	// another client publishes to the stream
+	go func() {
+		_ = publishWhenSubscribed(ctx, conn, "example-stream", "hello", "world")
+	}()
+
+	n := 0
+	for msg, err := range conn.Messages(ctx, "example-stream") {
+		if err != nil {
+			fmt.Println("error:", err)
+			break
+		}
+		fmt.Printf("message: %s\n", msg.Payload)
+		if n++; n == 2 {
+			break
+		}
+	}
+	// Output:
+	// message: hello
+	// message: world
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderMessageTimestamp is the header set by SubscribeOptions.MetadataHeaders to the time the
+	// server accepted the message, in Unix milliseconds
+	HeaderMessageTimestamp = "message-timestamp"
+	// HeaderMessageOffset is the header set by SubscribeOptions.MetadataHeaders to the offset of the
+	// message in its partition
+	HeaderMessageOffset = "message-offset"
+)
+
+// MessageMeta is the metadata the server reports with a consumed message
+type MessageMeta struct {
+	Timestamp time.Time // time the server accepted the message, zero if unknown
+	Offset    int64     // offset of the message in its partition, -1 if unknown
+}
+
+// Meta returns the metadata of the message
+func (m *Message) Meta() MessageMeta {
+	return MessageMeta{Timestamp: m.timestamp, Offset: m.offset}
+}
+
+// MessageMetaFromHeaders returns the metadata set in the headers of a message consumed with
+// SubscribeOptions.MetadataHeaders, for a SubscriptionCallback. The fields that aren't set are
+// unknown.
+func MessageMetaFromHeaders(headers map[string]string) MessageMeta {
+	meta := MessageMeta{Offset: -1}
+	if ms, err := strconv.ParseInt(headers[HeaderMessageTimestamp], 10, 64); err == nil {
+		meta.Timestamp = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	if offset, err := strconv.ParseInt(headers[HeaderMessageOffset], 10, 64); err == nil {
+		meta.Offset = offset
+	}
+	return meta
+}
+
+// addMetadataHeaders sets the metadata headers of the message with SubscribeOptions.MetadataHeaders.
+// Headers set by the publisher aren't overwritten.
+func (sub *subscription) addMetadataHeaders(msg *Message) {
+	if !sub.opts.MetadataHeaders {
+		return
+	}
+	set := func(key, value string) {
+		if _, ok := msg.Headers[key]; ok {
+			return
+		}
+		if msg.Headers == nil {
+			msg.Headers = map[string]string{}
+		}
+		msg.Headers[key] = value
+	}
+	if !msg.timestamp.IsZero() {
+		set(HeaderMessageTimestamp, strconv.FormatInt(msg.timestamp.UnixNano()/int64(time.Millisecond), 10))
+	}
+	if msg.offset >= 0 {
+		set(HeaderMessageOffset, strconv.FormatInt(msg.offset, 10))
+	}
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MessageMeta(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+
+	headers := make(chan map[string]string, 2)
+	err := c.Subscribe("meta-stream", func(err error, _ string, h map[string]string, _ []byte) {
+		require.NoError(t, err)
+		headers <- h
+	}, WithMetadataHeaders())
+	require.NoError(t, err)
+	metas := make(chan MessageMeta, 1)
+	err = c.SubscribeWithAck("meta-ack-stream", func(err error, msg *Message) {
+		require.NoError(t, err)
+		require.Empty(t, msg.Headers)
+		metas <- msg.Meta()
+	})
+	require.NoError(t, err)
+
+	at := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	payload := base64.StdEncoding.EncodeToString([]byte("payload"))
+	require.True(t, test.PublishRawAt("meta-stream", at,
+		rpc.PublishParams{MsgID: "msg-1", Payload: payload},
+		rpc.PublishParams{MsgID: "msg-2", Payload: payload, Headers: map[string]string{HeaderMessageOffset: "publisher"}}))
+	require.True(t, test.PublishRawAt("meta-ack-stream", at, rpc.PublishParams{MsgID: "msg-1", Payload: payload}))
+
+	receive := func() map[string]string {
+		select {
+		case h := <-headers:
+			return h
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+		return nil
+	}
+	h := receive()
+	meta := MessageMetaFromHeaders(h)
+	require.True(t, at.Equal(meta.Timestamp))
+	require.Zero(t, meta.Offset)
+
+	// headers of the publisher take precedence
+	h = receive()
+	require.Equal(t, "publisher", h[HeaderMessageOffset])
+	meta = MessageMetaFromHeaders(h)
+	require.True(t, at.Equal(meta.Timestamp))
+	require.Equal(t, int64(-1), meta.Offset)
+
+	select {
+	case meta = <-metas:
+		require.True(t, at.Equal(meta.Timestamp))
+		require.Zero(t, meta.Offset)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+
+	require.Equal(t, MessageMeta{Offset: -1}, MessageMetaFromHeaders(nil))
+}
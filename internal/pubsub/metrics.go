@@ -0,0 +1,95 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MetricsCollector receives the metrics of a Connection. Implementations must be safe for
+// concurrent use. See the package example for an adapter.
+type MetricsCollector interface {
+	// IncPublish is invoked once the result of a publish request is known
+	IncPublish(stream string, success bool)
+
+	// IncConsume is invoked for every successful consume response with the number of messages
+	IncConsume(stream string, msgCount int)
+
+	// ObserveConsumeLatency is invoked with the round-trip time of every consume request
+	ObserveConsumeLatency(stream string, d time.Duration)
+
+	// IncReconnect is invoked for every reconnect attempt
+	IncReconnect()
+
+	// ObserveSubscribeLatency is invoked with the duration of every successful subscription
+	// creation, including the retries
+	ObserveSubscribeLatency(stream string, d time.Duration)
+
+	// ObserveLag is invoked with the lag of the subscription of the stream in messages every time
+	// it's measured by Subscription.Lag
+	ObserveLag(stream string, lag int64)
+}
+
+// noopMetrics is the default MetricsCollector, it discards all the metrics
+type noopMetrics struct{}
+
+func (noopMetrics) IncPublish(string, bool)                       {}
+func (noopMetrics) IncConsume(string, int)                        {}
+func (noopMetrics) ObserveConsumeLatency(string, time.Duration)   {}
+func (noopMetrics) IncReconnect()                                 {}
+func (noopMetrics) ObserveSubscribeLatency(string, time.Duration) {}
+func (noopMetrics) ObserveLag(string, int64)                      {}
+
+// latencyWindow is the number of most recent durations the latency percentiles are computed over
+const latencyWindow = 1024
+
+// LatencyPercentiles are the percentiles of the most recent durations of an operation, zero if
+// none was observed yet.
+type LatencyPercentiles struct {
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Count int // number of durations the percentiles are computed over
+}
+
+// latencyRecorder keeps the latencyWindow most recent durations of an operation
+type latencyRecorder struct {
+	samples []time.Duration
+	next    int // index of the oldest sample once the window is full
+	sync.Mutex
+}
+
+func (r *latencyRecorder) observe(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.samples) < latencyWindow {
+		r.samples = append(r.samples, d)
+		return
+	}
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % latencyWindow
+}
+
+func (r *latencyRecorder) percentiles() LatencyPercentiles {
+	r.Lock()
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	r.Unlock()
+	if len(sorted) == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// nearest-rank percentile
+	rank := func(p int) time.Duration {
+		return sorted[(p*len(sorted)+99)/100-1]
+	}
+	return LatencyPercentiles{
+		P50:   rank(50),
+		P95:   rank(95),
+		P99:   rank(99),
+		Count: len(sorted),
+	}
+}
@@ -0,0 +1,367 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+type testMetrics struct {
+	published    map[string]int
+	failed       map[string]int
+	consumed     map[string]int
+	latencies    map[string]int
+	subscribes   map[string]int
+	lags         map[string]int64
+	reconnectCnt int
+	sync.Mutex
+}
+
+func (m *testMetrics) IncPublish(stream string, success bool) {
+	m.Lock()
+	defer m.Unlock()
+	if m.published == nil {
+		m.published = map[string]int{}
+		m.failed = map[string]int{}
+	}
+	if success {
+		m.published[stream]++
+	} else {
+		m.failed[stream]++
+	}
+}
+
+func (m *testMetrics) IncConsume(stream string, msgCount int) {
+	m.Lock()
+	defer m.Unlock()
+	if m.consumed == nil {
+		m.consumed = map[string]int{}
+	}
+	m.consumed[stream] += msgCount
+}
+
+func (m *testMetrics) ObserveConsumeLatency(stream string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	if m.latencies == nil {
+		m.latencies = map[string]int{}
+	}
+	m.latencies[stream]++
+}
+
+func (m *testMetrics) ObserveSubscribeLatency(stream string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	if m.subscribes == nil {
+		m.subscribes = map[string]int{}
+	}
+	m.subscribes[stream]++
+}
+
+func (m *testMetrics) ObserveLag(stream string, lag int64) {
+	m.Lock()
+	defer m.Unlock()
+	if m.lags == nil {
+		m.lags = map[string]int64{}
+	}
+	m.lags[stream] = lag
+}
+
+func (m *testMetrics) IncReconnect() {
+	m.Lock()
+	defer m.Unlock()
+	m.reconnectCnt++
+}
+
+func (m *testMetrics) reconnects() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.reconnectCnt
+}
+
+func Test_Metrics(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishFailures:   1,
+	})
+	defer s.Close()
+
+	metrics := &testMetrics{}
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		Metrics:      metrics,
+	})
+	defer c.disconnect()
+
+	received := make(chan struct{}, 2)
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {
+		received <- struct{}{}
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	require.Error(t, r.Error)
+	r, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+
+	result := make(chan *PublishResult, 1)
+	_, cancelAsync, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+	require.NoError(t, err)
+	defer cancelAsync()
+	require.NoError(t, (<-result).Error)
+
+	for i := 0; i < 2; i++ {
+		<-received
+	}
+	require.Eventually(t, func() bool {
+		metrics.Lock()
+		defer metrics.Unlock()
+		return metrics.consumed["test-stream"] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	require.Equal(t, 2, metrics.published["test-stream"])
+	require.Equal(t, 1, metrics.failed["test-stream"])
+	require.NotZero(t, metrics.latencies["test-stream"])
+	require.Equal(t, 1, metrics.subscribes["test-stream"])
+}
+
+func Test_SubscribeLatencyPercentiles(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+	require.Equal(t, LatencyPercentiles{}, c.SubscribeLatencyPercentiles())
+
+	handler := func(error, string, map[string]string, []byte) {}
+	for i := 0; i < 50; i++ {
+		_, err := c.subscribe(fmt.Sprintf("test-stream-%d", i), "", handler)
+		require.NoError(t, err)
+	}
+
+	p := c.SubscribeLatencyPercentiles()
+	require.Equal(t, 50, p.Count)
+	require.NotZero(t, p.P50)
+	require.LessOrEqual(t, int64(p.P50), int64(p.P95))
+	require.LessOrEqual(t, int64(p.P95), int64(p.P99))
+}
+
+func Test_LatencyRecorder(t *testing.T) {
+	r := latencyRecorder{}
+	for i := 1; i <= 100; i++ {
+		r.observe(time.Duration(i))
+	}
+	require.Equal(t, LatencyPercentiles{P50: 50, P95: 95, P99: 99, Count: 100}, r.percentiles())
+
+	// the oldest durations are replaced once the window is full
+	for i := 0; i < latencyWindow; i++ {
+		r.observe(time.Second)
+	}
+	require.Equal(t, LatencyPercentiles{P50: time.Second, P95: time.Second, P99: time.Second, Count: latencyWindow}, r.percentiles())
+}
+
+// expvarMetrics is a MetricsCollector adapter publishing the metrics with expvar. An adapter for
+// a metrics library such as Prometheus follows the same pattern.
+type expvarMetrics struct {
+	publish    *expvar.Map
+	consume    *expvar.Map
+	latency    *expvar.Map
+	subscribe  *expvar.Map
+	lag        *expvar.Map
+	reconnects *expvar.Int
+}
+
+func (m *expvarMetrics) IncPublish(stream string, success bool) {
+	if success {
+		m.publish.Add(stream+".success", 1)
+	} else {
+		m.publish.Add(stream+".failure", 1)
+	}
+}
+
+func (m *expvarMetrics) IncConsume(stream string, msgCount int) {
+	m.consume.Add(stream, int64(msgCount))
+}
+
+func (m *expvarMetrics) ObserveConsumeLatency(stream string, d time.Duration) {
+	m.latency.Add(stream, int64(d))
+}
+
+func (m *expvarMetrics) ObserveSubscribeLatency(stream string, d time.Duration) {
+	m.subscribe.Add(stream, int64(d))
+}
+
+func (m *expvarMetrics) ObserveLag(stream string, lag int64) {
+	v := new(expvar.Int)
+	v.Set(lag)
+	m.lag.Set(stream, v)
+}
+
+func (m *expvarMetrics) IncReconnect() {
+	m.reconnects.Add(1)
+}
+
+// newExpvarMetrics returns an expvarMetrics publishing its variables with expvar. The variables
+// published already, e.g. by a previous run of the example, are reset.
+func newExpvarMetrics() *expvarMetrics {
+	newMap := func(name string) *expvar.Map {
+		if m, ok := expvar.Get(name).(*expvar.Map); ok {
+			return m.Init()
+		}
+		return expvar.NewMap(name)
+	}
+	reconnects, ok := expvar.Get("pubsub_reconnects_total").(*expvar.Int)
+	if ok {
+		reconnects.Set(0)
+	} else {
+		reconnects = expvar.NewInt("pubsub_reconnects_total")
+	}
+	return &expvarMetrics{
+		publish:    newMap("pubsub_publish_total"),
+		consume:    newMap("pubsub_consume_messages_total"),
+		latency:    newMap("pubsub_consume_latency_ns_total"),
+		subscribe:  newMap("pubsub_subscribe_latency_ns_total"),
+		lag:        newMap("pubsub_lag_messages"),
+		reconnects: reconnects,
+	}
+}
+
+// exampleTB is the testing.TB of the test servers of the examples, which have no test to fail
+type exampleTB struct {
+	testing.TB
+}
+
+func (exampleTB) Helper() {}
+
+func (exampleTB) Logf(string, ...interface{}) {}
+
+func (exampleTB) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func Example_metricsCollector() {
+	s := test.NewRPCServer(exampleTB{}, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	metrics := newExpvarMetrics()
+	conn, err := NewConnection(Config{
+		GroupID: "group",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("api-key"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+		PollInterval: 10 * time.Millisecond,
+		Metrics:      metrics,
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.Connect(ctx); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer conn.Disconnect()
+
+	received := make(chan struct{}, 1)
+	err = conn.Subscribe("example-metrics-stream", func(error, string, map[string]string, []byte) {
+		received <- struct{}{}
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if _, err := conn.Publish(ctx, "example-metrics-stream", nil, []byte("payload")); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	select {
+	case <-received:
+	case <-ctx.Done():
+		fmt.Println("error: message not received")
+		return
+	}
+	fmt.Println("published:", metrics.publish.Get("example-metrics-stream.success"))
+	fmt.Println("consumed:", metrics.consume.Get("example-metrics-stream"))
+	fmt.Println("reconnects:", metrics.reconnects)
+	// Output:
+	// published: 1
+	// consumed: 1
+	// reconnects: 0
+}
+
+func Test_DeliveryLatencyPercentiles(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	received := make(chan struct{}, 100)
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {
+		received <- struct{}{}
+	})
+	require.NoError(t, err)
+	require.Equal(t, LatencyPercentiles{}, c.DeliveryLatencyPercentiles("test-stream"))
+
+	// the server accepted message i (i+1)*10ms ago
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		require.True(t, test.PublishRawAt("test-stream", now.Add(-time.Duration(i+1)*10*time.Millisecond), rpc.PublishParams{
+			MsgID:   fmt.Sprint("msg-", i),
+			Stream:  "test-stream",
+			Payload: base64.StdEncoding.EncodeToString([]byte("payload")),
+		}))
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+
+	p := c.DeliveryLatencyPercentiles("test-stream")
+	require.Equal(t, 100, p.Count)
+	require.GreaterOrEqual(t, int64(p.P50), int64(500*time.Millisecond))
+	require.GreaterOrEqual(t, int64(p.P95), int64(950*time.Millisecond))
+	require.GreaterOrEqual(t, int64(p.P99), int64(990*time.Millisecond))
+	require.Less(t, int64(p.P99), int64(3*time.Second))
+	require.Equal(t, LatencyPercentiles{}, c.DeliveryLatencyPercentiles("other-stream"))
+}
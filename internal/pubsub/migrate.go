@@ -0,0 +1,81 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// MigrateGroup moves the connection and all its subscriptions to a new GroupID without
+// interruption. A new connection is established under newGroupID and the subscriptions are
+// re-created on it before the subscriptions of the old group are deleted and the old connection
+// is closed. The server doesn't carry over the consume contexts, so messages consumed by the old
+// group while both groups are active may be delivered twice.
+// If the migration fails, the connection stays in the old group.
+func (c *Connection) MigrateGroup(ctx context.Context, newGroupID string) error {
+	if newGroupID == "" {
+		return fmt.Errorf("new GroupID must not be empty")
+	}
+	c.mu.Lock()
+	config := c.config
+	c.mu.Unlock()
+	config.GroupID = newGroupID
+	if config.EphemeralGroup {
+		config.GroupID = newGroupID + "-" + uuid.NewString()
+	}
+	conn, err := newInternalConnection(config)
+	if err != nil {
+		return err
+	}
+	if err = conn.connect(ctx); err != nil {
+		return fmt.Errorf("failed to migrate to group %s: %w", newGroupID, err)
+	}
+
+	c.mu.Lock()
+	subs := make([]subscriptionParams, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+
+	for i, sub := range subs {
+		if sub.ackHandler != nil {
+			subs[i].subscriptionID, err = conn.subscribeWithAck(sub.stream, "", sub.ackHandler, sub.opts...)
+		} else {
+			subs[i].subscriptionID, err = conn.subscribe(sub.stream, "", sub.handler, sub.opts...)
+		}
+		if err != nil {
+			// delete the subscriptions already created for the new group
+			for _, created := range subs[:i] {
+				_ = conn.unsubscribe(created.stream)
+			}
+			conn.disconnect()
+			return fmt.Errorf("failed to migrate to group %s: %w", newGroupID, err)
+		}
+	}
+
+	c.mu.Lock()
+	old := c.conn
+	c.conn = conn
+	c.config.GroupID = config.GroupID
+	for _, sub := range subs {
+		if _, ok := c.subscriptions[sub.stream]; ok {
+			c.subscriptions[sub.stream] = sub
+		}
+	}
+	c.mu.Unlock()
+	c.logger().Infof("%v migrated from group %s", c, old.config.GroupID)
+
+	// delete the subscriptions of the old group
+	for _, sub := range subs {
+		if err := old.unsubscribe(sub.stream); err != nil {
+			c.logger().Warnf("Failed to delete subscription of group %s for stream %s: %v", old.config.GroupID, sub.stream, err)
+		}
+	}
+	old.disconnect()
+	return nil
+}
@@ -0,0 +1,113 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MigrateGroup(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c, err := NewConnection(Config{
+		GroupID: "group-a",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+		PollInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	err = c.Connect(context.Background())
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	received := make(chan []byte, 10)
+	err = c.Subscribe("test-stream", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- payload
+	})
+	require.NoError(t, err)
+	oldID := c.Subscriptions()[0].ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("before"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("before"), <-received)
+
+	err = c.MigrateGroup(ctx, "group-b")
+	require.NoError(t, err)
+	require.Equal(t, StateConnected, c.State())
+
+	req, ok := test.GetSubscriptionRequest("test-stream")
+	require.True(t, ok)
+	require.Equal(t, "group-b", req.GroupID)
+	require.False(t, test.HasSubscription(oldID))
+	subs := c.Subscriptions()
+	require.Len(t, subs, 1)
+	require.NotEqual(t, oldID, subs[0].ID)
+	require.True(t, test.HasSubscription(subs[0].ID))
+	require.Contains(t, c.String(), "group-b")
+
+	// consumption continues under the new group
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("after"))
+	require.NoError(t, err)
+	select {
+	case payload := <-received:
+		require.Equal(t, []byte("after"), payload)
+	case <-ctx.Done():
+		t.Fatal("message not received after migration")
+	}
+
+	// the old connection closing is not reported as an error
+	select {
+	case err = <-c.Error:
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_MigrateGroupFailure(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		RejectReconnect:   true,
+	})
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c, err := NewConnection(Config{
+		GroupID: "group-a",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+	})
+	require.NoError(t, err)
+	err = c.Connect(context.Background())
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	err = c.MigrateGroup(context.Background(), "group-b")
+	require.Error(t, err)
+	require.Contains(t, c.String(), "group-a")
+	require.False(t, c.IsDisconnected())
+}
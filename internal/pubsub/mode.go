@@ -0,0 +1,38 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+// Mode restricts the operations allowed on a connection, e.g. for a consumer that must never
+// publish by mistake
+type Mode int
+
+const (
+	// ModeReadWrite allows publishing and subscribing
+	ModeReadWrite Mode = iota
+	// ModeReadOnly fails the publishes with ErrReadOnly
+	ModeReadOnly
+	// ModeWriteOnly fails the subscriptions with ErrWriteOnly
+	ModeWriteOnly
+)
+
+// valid returns true if m is one of the Mode constants
+func (m Mode) valid() bool {
+	return m >= ModeReadWrite && m <= ModeWriteOnly
+}
+
+// checkPublish returns ErrReadOnly if the mode doesn't allow publishing
+func (m Mode) checkPublish() error {
+	if m == ModeReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// checkSubscribe returns ErrWriteOnly if the mode doesn't allow subscribing
+func (m Mode) checkSubscribe() error {
+	if m == ModeWriteOnly {
+		return ErrWriteOnly
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ModeReadOnly(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond, Mode: ModeReadOnly})
+	defer c.Disconnect()
+
+	payloads := make(chan string, 2)
+	err := c.Subscribe("mode-read-only", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		payloads <- string(payload)
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "mode-read-only", nil, []byte("publish"))
+	require.ErrorIs(t, err, ErrReadOnly)
+	_, _, err = c.PublishAsync("mode-read-only", nil, []byte("async"), make(chan *PublishResult, 1))
+	require.ErrorIs(t, err, ErrReadOnly)
+	_, err = c.PublishAsyncFunc("mode-read-only", nil, []byte("func"), func(*PublishResult) {})
+	require.ErrorIs(t, err, ErrReadOnly)
+	err = c.PublishNoAck("mode-read-only", nil, []byte("noack"))
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	// none of the publishes reached the server, the first message consumed is the raw one
+	payload := base64.StdEncoding.EncodeToString([]byte("raw"))
+	require.True(t, test.PublishRaw("mode-read-only", rpc.PublishParams{MsgID: "msg-1", Payload: payload}))
+	select {
+	case p := <-payloads:
+		require.Equal(t, "raw", p)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+}
+
+func Test_ModeWriteOnly(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond, Mode: ModeWriteOnly})
+	defer c.Disconnect()
+
+	err := c.Subscribe("mode-write-only", func(error, string, map[string]string, []byte) {})
+	require.ErrorIs(t, err, ErrWriteOnly)
+	var subErr *SubscriptionError
+	require.ErrorAs(t, err, &subErr)
+	require.Equal(t, "mode-write-only", subErr.Stream)
+	_, ok := test.GetSubscriptionRequest("mode-write-only")
+	require.False(t, ok)
+
+	err = c.SubscribeDynamic(context.Background(), "^mode-write-only-.*", func(error, string, map[string]string, []byte) {})
+	require.ErrorIs(t, err, ErrWriteOnly)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := c.Publish(ctx, "mode-write-only", nil, []byte("publish"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+}
+
+func Test_ModeInvalid(t *testing.T) {
+	_, err := newInternalConnection(Config{
+		GroupID:        "test-client",
+		Domain:         "localhost",
+		APIKeyProvider: func() ([]byte, error) { return []byte("xyz"), nil },
+		Mode:           Mode(42),
+	})
+	require.EqualError(t, err, "Config Mode must be one of the Mode constants")
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import "time"
+
+// SubscribeOptions are the options of a subscription
+type SubscribeOptions struct {
+	// Prefetch is the number of messages the server may send ahead of the consume requests. It's
+	// independent of the number of messages per consume response.
+	// Default is 0, which means the server default.
+	Prefetch int
+
+	// Lazy defers the creation of the subscription on the server until it's activated with
+	// Subscription.Activate. No messages are consumed until then.
+	Lazy bool
+
+	// InitialCredits enables credit based flow control: the consume requests grant the server up to
+	// InitialCredits messages, which are buffered and delivered to the callback by a separate
+	// goroutine. The credits are replenished as the callback processes the messages and a new
+	// consume request is sent once Config.CreditReplenishThreshold credits are available, without
+	// waiting for the poll interval while the server has more messages. Not supported for
+	// subscriptions with ack or with AckModeManual.
+	// Default is 0, which means no flow control.
+	InitialCredits int
+
+	// Filter is invoked with the headers and the decoded payload of every consumed message before
+	// it's delivered. The messages for which it returns false are skipped: they're never delivered
+	// to the callback, but consuming advances past them as if they were processed. Messages whose
+	// payload can't be decoded aren't filtered, they're delivered with the error.
+	// Default is nil, which means every message is delivered.
+	Filter func(headers map[string]string, payload []byte) bool
+
+	// Ordered delivers the messages to the callback in the order of their offset in the stream.
+	// The messages of each consume response are sorted by offset before dispatch and the messages
+	// at or before the offset of a message already delivered are skipped as duplicates. Consume
+	// responses with messages whose offset isn't reported by the server are delivered as received.
+	// Default is false, which means the messages are delivered in the order they're received.
+	Ordered bool
+
+	// Concurrency is the number of goroutines delivering the messages to the callback, which is
+	// invoked concurrently. The messages with the same HeaderPartitionKey header are delivered by
+	// the same goroutine in order, the other messages are spread across the goroutines. While the
+	// goroutine of a message is busy, consuming waits rather than buffering the messages. Not
+	// supported with InitialCredits.
+	// Default is 0, which means the messages are delivered one at a time by the consuming
+	// goroutine.
+	Concurrency int
+
+	// BatchSize overrides Config.ConsumeBatchSize for the subscription. Must not be negative.
+	// Default is 0, which means Config.ConsumeBatchSize.
+	BatchSize int
+
+	// RawPayloads hands the payloads to the callback as received from the server, without base64
+	// decoding or decompression, for streams that carry text payloads such as those published with
+	// PublishRaw. The messages that aren't marked with HeaderPayloadEncoding, e.g. those published
+	// base64 encoded by Publish, are delivered with ErrInvalidPayload, as are the messages marked
+	// as raw consumed without RawPayloads.
+	// Default is false, which means the payloads are decoded with Config.PayloadEncoding.
+	RawPayloads bool
+
+	// Dedup suppresses the messages whose ID is among the IDs of the last Dedup messages consumed
+	// from the stream, e.g. replayed after a reconnect. Duplicates are never delivered to the
+	// callback, but consuming advances past them, they're counted in SubscriptionInfo.Duplicates.
+	// The window is kept across reconnects until the stream is unsubscribed. Must not be negative.
+	// Default is 0, which means duplicates are delivered.
+	Dedup int
+
+	// MaxAge skips the messages the server accepted more than MaxAge ago, e.g. the backlog replayed
+	// after a downtime. Stale messages are never delivered to the callback, but consuming advances
+	// past them, they're counted in SubscriptionInfo.Stale. Messages whose time isn't reported by
+	// the server are always delivered. Must not be negative.
+	// Default is 0, which means messages of any age are delivered.
+	MaxAge time.Duration
+
+	// OffsetReset is where the subscription starts consuming if its consumer group has no position
+	// in the stream yet, e.g. for a new Config.GroupID. It's sent with the creation of the
+	// subscription, servers that don't support it ignore it.
+	// Default is OffsetResetDefault, which means the server default.
+	OffsetReset OffsetReset
+
+	// GroupID is the consumer group of the subscription instead of Config.GroupID, so that several
+	// logical consumers can share a connection. The group is fixed at the creation of the
+	// subscription, MigrateGroup re-creates the subscription in the same group.
+	// Default is empty, which means Config.GroupID.
+	GroupID string
+
+	groupIDSet bool // set by WithGroupID to reject an empty group ID
+
+	// MetadataHeaders sets the HeaderMessageTimestamp and HeaderMessageOffset headers of the
+	// consumed messages to the metadata reported by the server, e.g. to measure the lag or to
+	// checkpoint, see MessageMetaFromHeaders. Headers set by the publisher take precedence and
+	// unknown metadata isn't set.
+	// Default is false, the metadata of a Message is still available with Message.Meta.
+	MetadataHeaders bool
+}
+
+// OffsetReset is where a consumer group without a position in the stream starts consuming
+type OffsetReset string
+
+const (
+	// OffsetResetDefault leaves the starting position to the server
+	OffsetResetDefault OffsetReset = ""
+	// OffsetResetEarliest starts consuming from the oldest message retained by the stream
+	OffsetResetEarliest OffsetReset = "earliest"
+	// OffsetResetLatest starts consuming from the messages published after the subscription
+	OffsetResetLatest OffsetReset = "latest"
+)
+
+// SubscribeOption sets an option of a subscription
+type SubscribeOption func(*SubscribeOptions)
+
+// WithPrefetch sets SubscribeOptions.Prefetch
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Prefetch = n
+	}
+}
+
+// WithLazy sets SubscribeOptions.Lazy
+func WithLazy() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Lazy = true
+	}
+}
+
+// WithInitialCredits sets SubscribeOptions.InitialCredits
+func WithInitialCredits(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.InitialCredits = n
+	}
+}
+
+// WithFilter sets SubscribeOptions.Filter
+func WithFilter(filter func(headers map[string]string, payload []byte) bool) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Filter = filter
+	}
+}
+
+// WithOrdering sets SubscribeOptions.Ordered
+func WithOrdering(ordered bool) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Ordered = ordered
+	}
+}
+
+// WithConcurrency sets SubscribeOptions.Concurrency
+func WithConcurrency(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Concurrency = n
+	}
+}
+
+// WithBatchSize sets SubscribeOptions.BatchSize
+func WithBatchSize(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.BatchSize = n
+	}
+}
+
+// WithRawPayloads sets SubscribeOptions.RawPayloads
+func WithRawPayloads() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.RawPayloads = true
+	}
+}
+
+// WithDedup sets SubscribeOptions.Dedup
+func WithDedup(windowSize int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Dedup = windowSize
+	}
+}
+
+// WithMaxAge sets SubscribeOptions.MaxAge
+func WithMaxAge(d time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.MaxAge = d
+	}
+}
+
+// WithOffsetReset sets SubscribeOptions.OffsetReset
+func WithOffsetReset(reset OffsetReset) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.OffsetReset = reset
+	}
+}
+
+// WithGroupID sets SubscribeOptions.GroupID, the subscription fails if id is empty
+func WithGroupID(id string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.GroupID = id
+		o.groupIDSet = true
+	}
+}
+
+// groupID returns GroupID, or the group of the connection if it isn't set
+func (o SubscribeOptions) groupID(connGroupID string) string {
+	if o.GroupID != "" {
+		return o.GroupID
+	}
+	return connGroupID
+}
+
+// WithMetadataHeaders sets SubscribeOptions.MetadataHeaders
+func WithMetadataHeaders() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.MetadataHeaders = true
+	}
+}
+
+// newSubscribeOptions returns the options with opts applied
+func newSubscribeOptions(opts []SubscribeOption) SubscribeOptions {
+	var o SubscribeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
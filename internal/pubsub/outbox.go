@@ -0,0 +1,179 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// outboxEntry is a publish held while the connection is being re-established, see
+// Config.OutboxSize
+type outboxEntry struct {
+	ctx     context.Context
+	stream  string
+	headers map[string]string
+	payload []byte
+	done    chan outboxResult // buffered, receives the result once the publish is sent
+}
+
+type outboxResult struct {
+	result *PublishResult
+	err    error
+}
+
+// hold adds the publish to the outbox if the connection is being re-established or the held
+// publishes are being sent. It returns nil if the publish isn't held because the connection isn't
+// reconnecting or the outbox is disabled.
+func (c *Connection) hold(ctx context.Context, stream string, headers map[string]string, payload []byte) (*outboxEntry, error) {
+	if err := c.config.Mode.checkPublish(); err != nil {
+		// never held while reconnecting
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	if c.config.OutboxSize <= 0 {
+		return nil, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != StateReconnecting && !(c.state == StateConnected && c.flushing) {
+		return nil, nil
+	}
+	if len(c.outbox) >= c.config.OutboxSize {
+		return nil, fmt.Errorf("publish failure: %w", ErrOutboxFull)
+	}
+	e := &outboxEntry{
+		ctx:     ctx,
+		stream:  stream,
+		headers: headers,
+		payload: payload,
+		done:    make(chan outboxResult, 1),
+	}
+	c.outbox = append(c.outbox, e)
+	return e, nil
+}
+
+// publishHeld holds the publish like hold and waits for its result. held is false if the publish
+// isn't held.
+func (c *Connection) publishHeld(ctx context.Context, stream string, headers map[string]string, payload []byte) (r *PublishResult, held bool, err error) {
+	e, err := c.hold(ctx, stream, headers, payload)
+	if err != nil {
+		return nil, true, err
+	}
+	if e == nil {
+		return nil, false, nil
+	}
+	r, err = c.awaitHeld(ctx, e)
+	return r, true, err
+}
+
+// awaitHeld waits for the result of the held publish. The publish is removed from the outbox if
+// ctx is done before it's sent.
+func (c *Connection) awaitHeld(ctx context.Context, e *outboxEntry) (*PublishResult, error) {
+	select {
+	case r := <-e.done:
+		return r.result, r.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		for i, held := range c.outbox {
+			if held == e {
+				c.outbox = append(c.outbox[:i], c.outbox[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+		return nil, fmt.Errorf("publish failure: %w", ctx.Err())
+	}
+}
+
+// takeOutbox empties the outbox and returns the held publishes in order
+func (c *Connection) takeOutbox() []*outboxEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	held := c.outbox
+	c.outbox = nil
+	return held
+}
+
+// takeFlush is takeOutbox for flushOutbox over conn. The flushing ends once the outbox is empty.
+// Nothing is returned if conn was lost again, the next reconnect sends the held publishes.
+func (c *Connection) takeFlush(conn *internalConnection) []*outboxEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != StateConnected || c.conn != conn {
+		return nil
+	}
+	held := c.outbox
+	c.outbox = nil
+	if len(held) == 0 {
+		c.flushing = false
+	}
+	return held
+}
+
+// requeue puts the publishes back at the head of the outbox, in order, when the connection is lost
+// while flushing them. They're sent once the connection is re-established. It returns false if the
+// connection is closed for good, the publishes must fail then.
+func (c *Connection) requeue(held []*outboxEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		// failOutbox runs once the context is canceled
+		return false
+	}
+	requeued := make([]*outboxEntry, 0, len(held)+len(c.outbox))
+	for _, e := range held {
+		// the publishes whose context is done were given up by awaitHeld
+		if e.ctx.Err() == nil {
+			requeued = append(requeued, e)
+		}
+	}
+	c.outbox = append(requeued, c.outbox...)
+	return true
+}
+
+// flushOutbox sends the held publishes in order over the re-established connection, including the
+// publishes held while sending, up to Config.OutboxFlushTimeout. The publishes not sent yet are
+// put back in the outbox if the connection is lost again.
+func (c *Connection) flushOutbox() {
+	// the flush of the next connection waits for the publishes of this one to be put back
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	var deadline time.Time
+	if c.config.OutboxFlushTimeout > 0 {
+		deadline = time.Now().Add(c.config.OutboxFlushTimeout)
+	}
+	conn := c.current()
+	for {
+		held := c.takeFlush(conn)
+		if len(held) == 0 {
+			return
+		}
+		c.logger().Infof("Sending %d publishes held while reconnecting", len(held))
+		for i, e := range held {
+			ctx, cancel := e.ctx, context.CancelFunc(func() {})
+			if !deadline.IsZero() {
+				ctx, cancel = context.WithDeadline(e.ctx, deadline)
+			}
+			r, err := conn.Publish(ctx, e.stream, e.headers, e.payload)
+			cancel()
+			if errors.Is(err, ErrNotConnected) && c.requeue(held[i:]) {
+				c.logger().Infof("Connection lost while sending held publishes, %d held again", len(held)-i)
+				return
+			}
+			e.done <- outboxResult{result: r, err: err}
+		}
+	}
+}
+
+// failOutbox fails the held publishes once the connection is closed for good
+func (c *Connection) failOutbox() {
+	for _, e := range c.takeOutbox() {
+		e.done <- outboxResult{err: fmt.Errorf("publish failure: %w", ErrNotConnected)}
+	}
+	c.mu.Lock()
+	c.flushing = false
+	c.mu.Unlock()
+}
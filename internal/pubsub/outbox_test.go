@@ -0,0 +1,290 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Outbox(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		PublishOrder:      map[string][]string{"out-stream": {"1", "2", "3"}},
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	type result struct {
+		r   *PublishResult
+		err error
+	}
+	results := make(chan result, 3)
+	var fullErr error
+	var c *Connection
+	var once sync.Once
+	c = newTestPublicConnection(t, s, Config{
+		OutboxSize: 3,
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 3,
+			Delay:       10 * time.Millisecond,
+		},
+		OnStateChange: func(state ConnectionState) {
+			if state != StateReconnecting {
+				return
+			}
+			// publish while the connection is being re-established
+			once.Do(func() {
+				ctx := context.Background()
+				for i := 1; i <= 3; i++ {
+					go func(payload string) {
+						r, err := c.Publish(ctx, "out-stream", nil, []byte(payload))
+						results <- result{r, err}
+					}(strconv.Itoa(i))
+					// held in order
+					require.Eventually(t, func() bool {
+						c.mu.Lock()
+						defer c.mu.Unlock()
+						return len(c.outbox) == i
+					}, time.Second, time.Millisecond)
+				}
+				_, fullErr = c.Publish(ctx, "out-stream", nil, []byte("4"))
+			})
+		},
+	})
+	defer c.Disconnect()
+	err := c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case res := <-results:
+			require.NoError(t, res.err)
+			require.NoError(t, res.r.Error)
+		case <-time.After(5 * time.Second):
+			t.Fatal("held publish not sent")
+		}
+	}
+	require.True(t, errors.Is(fullErr, ErrOutboxFull), "unexpected error: %v", fullErr)
+}
+
+func Test_OutboxReconnectFailure(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		RejectReconnect:   true,
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	published := make(chan error, 1)
+	var c *Connection
+	c = newTestPublicConnection(t, s, Config{
+		OutboxSize: 1,
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 2,
+			Delay:       100 * time.Millisecond,
+		},
+		OnStateChange: func(state ConnectionState) {
+			if state == StateReconnecting {
+				go func() {
+					_, err := c.Publish(context.Background(), "out-stream", nil, []byte("1"))
+					published <- err
+				}()
+			}
+		},
+	})
+	defer c.Disconnect()
+	err := c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	select {
+	case err = <-published:
+		require.True(t, errors.Is(err, ErrNotConnected), "unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("held publish not failed")
+	}
+	require.Equal(t, StateFailed, c.State())
+}
+
+func Test_OutboxOrder(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		PublishOrder:      map[string][]string{"out-order-stream": {"1", "2", "3", "4"}},
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	results := make(chan error, 4)
+	publish := func(c *Connection, payload string) {
+		r, err := c.Publish(context.Background(), "out-order-stream", nil, []byte(payload))
+		if err == nil {
+			err = r.Error
+		}
+		results <- err
+	}
+	var c *Connection
+	var mu sync.Mutex
+	held := false
+	c = newTestPublicConnection(t, s, Config{
+		OutboxSize: 4,
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 3,
+			Delay:       10 * time.Millisecond,
+		},
+		OnStateChange: func(state ConnectionState) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case state == StateReconnecting && !held:
+				held = true
+				for i := 1; i <= 3; i++ {
+					go publish(c, strconv.Itoa(i))
+					require.Eventually(t, func() bool {
+						c.mu.Lock()
+						defer c.mu.Unlock()
+						return len(c.outbox) == i
+					}, time.Second, time.Millisecond)
+				}
+			case state == StateConnected && held:
+				// published right after the reconnect, behind the held publishes
+				go publish(c, "4")
+			}
+		},
+	})
+	defer c.Disconnect()
+	require.NoError(t, c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {}))
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-results:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("publish not sent")
+		}
+	}
+}
+
+func Test_OutboxFlushTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	published := make(chan error, 1)
+	var c *Connection
+	var once sync.Once
+	c = newTestPublicConnection(t, s, Config{
+		OutboxSize:         1,
+		OutboxFlushTimeout: 200 * time.Millisecond,
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 3,
+			Delay:       10 * time.Millisecond,
+		},
+		OnStateChange: func(state ConnectionState) {
+			if state == StateReconnecting {
+				once.Do(func() {
+					go func() {
+						_, err := c.Publish(context.Background(), "out-stream", nil, []byte("1"))
+						published <- err
+					}()
+				})
+			}
+		},
+	})
+	defer c.Disconnect()
+	require.NoError(t, c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {}))
+
+	// the server never responds to the publish
+	select {
+	case err := <-published:
+		require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("held publish not timed out")
+	}
+}
+
+func Test_OutboxConnectionLostWhileFlushing(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		// the first held publish is sent over a connection that is lost before the response
+		PublishDrops: 1,
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	results := make(chan error, 2)
+	var c *Connection
+	var once sync.Once
+	c = newTestPublicConnection(t, s, Config{
+		OutboxSize: 2,
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 3,
+			Delay:       10 * time.Millisecond,
+		},
+		OnStateChange: func(state ConnectionState) {
+			if state != StateReconnecting {
+				return
+			}
+			once.Do(func() {
+				for i := 1; i <= 2; i++ {
+					go func(payload string) {
+						r, err := c.Publish(context.Background(), "out-lost-stream", nil, []byte(payload))
+						if err == nil {
+							err = r.Error
+						}
+						results <- err
+					}(strconv.Itoa(i))
+					require.Eventually(t, func() bool {
+						c.mu.Lock()
+						defer c.mu.Unlock()
+						return len(c.outbox) == i
+					}, time.Second, time.Millisecond)
+				}
+			})
+		},
+	})
+	defer c.Disconnect()
+	require.NoError(t, c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {}))
+
+	// both are sent over the next connection instead of failing
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("held publish not sent")
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// PatternCallback is the callback of SubscribePattern, it receives the name of the stream the
+// message was consumed from along with the arguments of SubscriptionCallback
+type PatternCallback func(stream string, err error, id string, headers map[string]string, payload []byte)
+
+// SubscribePattern subscribes to all the streams whose name matches the wildcard pattern, in
+// which * matches any sequence of characters and ? matches a single character, e.g.
+// "events.tenant-*". The subscriptions API has no wildcard support, so the matching streams are
+// reconciled every Config.StreamDiscoveryInterval like SubscribeDynamic, which describes how
+// discovery stops and how overlapping subscriptions are handled.
+func (c *Connection) SubscribePattern(ctx context.Context, pattern string, handler PatternCallback, opts ...SubscribeOption) error {
+	return c.subscribeDynamic(ctx, wildcardRegexp(pattern), func(stream string) SubscriptionCallback {
+		return func(err error, id string, headers map[string]string, payload []byte) {
+			handler(stream, err, id, headers, payload)
+		}
+	}, opts)
+}
+
+// wildcardRegexp returns the regular expression matching the same names as the wildcard pattern
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscribePattern(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		StreamsPath:       apiPaths.streams,
+	})
+	defer s.Close()
+
+	test.CreateStream("events.tenant-1")
+	test.CreateStream("events.tenantx")
+	defer test.DeleteStream("events.tenant-1")
+	defer test.DeleteStream("events.tenant-2")
+	defer test.DeleteStream("events.tenantx")
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval:            10 * time.Millisecond,
+		StreamDiscoveryInterval: 50 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	type delivery struct{ stream, payload string }
+	deliveries := make(chan delivery, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := c.SubscribePattern(ctx, "events.tenant-*", func(stream string, err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		deliveries <- delivery{stream, string(payload)}
+	})
+	require.NoError(t, err)
+	require.NotNil(t, c.Subscription("events.tenant-1"))
+	require.Nil(t, c.Subscription("events.tenantx"))
+
+	// streams appearing later are subscribed, the callback receives the stream of each message
+	test.CreateStream("events.tenant-2")
+	require.Eventually(t, func() bool {
+		return c.Subscription("events.tenant-2") != nil
+	}, time.Second, 10*time.Millisecond)
+	for _, stream := range []string{"events.tenant-1", "events.tenant-2"} {
+		pubCtx, pubCancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(pubCtx, stream, nil, []byte("payload of "+stream))
+		pubCancel()
+		require.NoError(t, err)
+		select {
+		case d := <-deliveries:
+			require.Equal(t, delivery{stream, "payload of " + stream}, d)
+		case <-time.After(time.Second):
+			t.Fatalf("message of stream %s wasn't consumed", stream)
+		}
+	}
+
+	// disappearing streams are unsubscribed
+	test.DeleteStream("events.tenant-1")
+	require.Eventually(t, func() bool {
+		return c.Subscription("events.tenant-1") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_WildcardRegexp(t *testing.T) {
+	re := wildcardRegexp("events.tenant-?.*")
+	require.True(t, re.MatchString("events.tenant-1.created"))
+	require.True(t, re.MatchString("events.tenant-a."))
+	require.False(t, re.MatchString("events.tenant-12.created"))
+	require.False(t, re.MatchString("eventsXtenant-1.created"))
+	require.False(t, re.MatchString("prefix.events.tenant-1.created"))
+}
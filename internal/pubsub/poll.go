@@ -0,0 +1,66 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"math/rand"
+	"time"
+)
+
+// pollInterval computes the delay between consecutive consume requests of a subscription. With
+// Config.MaxPollInterval set, the delay drops to Config.MinPollInterval as soon as messages are
+// received and doubles with every empty consume response, starting from Config.PollInterval, up
+// to Config.MaxPollInterval. Otherwise the delay is always Config.PollInterval.
+// Config.PollJitter randomizes each sleep around the delay, see jittered.
+type pollInterval struct {
+	base    time.Duration
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+	jitter  float64
+}
+
+func newPollInterval(config Config) *pollInterval {
+	p := &pollInterval{
+		base:    config.PollInterval,
+		min:     config.PollInterval,
+		max:     config.PollInterval,
+		current: config.PollInterval,
+		jitter:  config.PollJitter,
+	}
+	if config.MaxPollInterval > 0 {
+		p.min = config.MinPollInterval
+		p.max = config.MaxPollInterval
+		if p.base > p.max {
+			p.base = p.max
+		}
+		p.current = p.min
+	}
+	return p
+}
+
+// update adjusts the delay based on the number of messages in the last consume response and
+// returns the new delay
+func (p *pollInterval) update(messages int) time.Duration {
+	if messages > 0 {
+		p.current = p.min
+	} else if p.current < p.base {
+		p.current = p.base
+	} else if p.current < p.max {
+		p.current *= 2
+		if p.current > p.max {
+			p.current = p.max
+		}
+	}
+	return p.current
+}
+
+// jittered returns d randomized by up to ±jitter of its value so that the consume requests of the
+// subscribers sharing the same interval don't synchronize
+func (p *pollInterval) jittered(d time.Duration) time.Duration {
+	if p.jitter == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + p.jitter*(2*rand.Float64()-1)))
+}
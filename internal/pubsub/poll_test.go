@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PollIntervalFixed(t *testing.T) {
+	p := newPollInterval(Config{PollInterval: time.Second})
+	require.Equal(t, time.Second, p.current)
+	require.Equal(t, time.Second, p.update(10))
+	require.Equal(t, time.Second, p.update(0))
+	require.Equal(t, time.Second, p.update(0))
+}
+
+func Test_PollIntervalAdaptive(t *testing.T) {
+	p := newPollInterval(Config{
+		PollInterval:    100 * time.Millisecond,
+		MinPollInterval: 10 * time.Millisecond,
+		MaxPollInterval: time.Second,
+	})
+	require.Equal(t, 10*time.Millisecond, p.current)
+
+	// grows when idle
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for _, e := range expected {
+		require.Equal(t, e, p.update(0))
+	}
+
+	// shrinks under load
+	require.Equal(t, 10*time.Millisecond, p.update(5))
+	require.Equal(t, 10*time.Millisecond, p.update(1))
+	require.Equal(t, 100*time.Millisecond, p.update(0))
+}
+
+func Test_PollIntervalAdaptiveMaxBelowBase(t *testing.T) {
+	p := newPollInterval(Config{
+		PollInterval:    time.Second,
+		MaxPollInterval: 500 * time.Millisecond,
+	})
+	require.Equal(t, time.Duration(0), p.update(1))
+	require.Equal(t, 500*time.Millisecond, p.update(0))
+	require.Equal(t, 500*time.Millisecond, p.update(0))
+}
+
+func Test_PollIntervalInvalidConfig(t *testing.T) {
+	_, err := newInternalConnection(Config{
+		GroupID:         "test-group",
+		Domain:          "localhost",
+		MinPollInterval: time.Second,
+		MaxPollInterval: 500 * time.Millisecond,
+		APIKeyProvider:  func() ([]byte, error) { return []byte("key"), nil },
+	})
+	require.Error(t, err)
+}
+
+func Test_PollIntervalJitter(t *testing.T) {
+	p := newPollInterval(Config{PollInterval: time.Second})
+	require.Equal(t, time.Second, p.jittered(time.Second))
+
+	p = newPollInterval(Config{PollInterval: time.Second, PollJitter: 0.1})
+	distinct := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := p.jittered(time.Second)
+		require.GreaterOrEqual(t, int64(d), int64(900*time.Millisecond))
+		require.LessOrEqual(t, int64(d), int64(1100*time.Millisecond))
+		distinct[d] = true
+	}
+	require.Greater(t, len(distinct), 1)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// PoolStrategy determines how a ConnectionPool assigns streams to its connections
+type PoolStrategy int
+
+const (
+	// PoolRoundRobin assigns each subscription and publish to the next connection in turn
+	PoolRoundRobin PoolStrategy = iota
+	// PoolHash assigns a stream to a connection based on the hash of its name, so all the
+	// operations on a stream go through the same connection
+	PoolHash
+)
+
+// ConnectionPool spreads the subscriptions and publishes over several connections for throughput
+// and fault isolation. Each connection reconnects independently, their failures are sent to the
+// Error channel as *PoolError.
+type ConnectionPool struct {
+	next     uint32 // round robin counter, accessed atomically
+	conns    []*Connection
+	strategy PoolStrategy
+	Error    chan error
+	assigned map[string]*Connection // connection of each subscribed stream
+	mu       sync.Mutex             // lock to protect assigned
+}
+
+// NewConnectionPool creates a pool of size connections based on the supplied configuration.
+func NewConnectionPool(config Config, size int, strategy PoolStrategy) (*ConnectionPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid pool size %d", size)
+	}
+	p := &ConnectionPool{
+		conns:    make([]*Connection, size),
+		strategy: strategy,
+		Error:    make(chan error, size),
+		assigned: map[string]*Connection{},
+	}
+	for i := range p.conns {
+		conn, err := NewConnection(config)
+		if err != nil {
+			return nil, err
+		}
+		p.conns[i] = conn
+	}
+	return p, nil
+}
+
+// Connect establishes all the connections of the pool. The connections already established are
+// disconnected if one of them fails.
+func (p *ConnectionPool) Connect(ctx context.Context) error {
+	for i, conn := range p.conns {
+		if err := conn.Connect(ctx); err != nil {
+			for _, c := range p.conns[:i] {
+				c.Disconnect()
+			}
+			return &PoolError{Index: i, Err: err}
+		}
+	}
+	go p.errorHandler()
+	return nil
+}
+
+// errorHandler forwards the errors of the connections to the Error channel, which is closed once
+// all the connections are closed
+func (p *ConnectionPool) errorHandler() {
+	var wg sync.WaitGroup
+	wg.Add(len(p.conns))
+	for i, conn := range p.conns {
+		go func(i int, conn *Connection) {
+			defer wg.Done()
+			// the connection sends a single error, nil if it was disconnected
+			if err := <-conn.Error; err != nil {
+				p.Error <- &PoolError{Index: i, Err: err}
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+	close(p.Error)
+}
+
+// Disconnect disconnects all the connections of the pool.
+func (p *ConnectionPool) Disconnect() {
+	for _, conn := range p.conns {
+		conn.Disconnect()
+	}
+}
+
+// Connections returns the connections of the pool
+func (p *ConnectionPool) Connections() []*Connection {
+	return append([]*Connection(nil), p.conns...)
+}
+
+// pick returns the connection for an operation on the stream
+func (p *ConnectionPool) pick(stream string) *Connection {
+	if p.strategy == PoolHash {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(stream))
+		return p.conns[h.Sum32()%uint32(len(p.conns))]
+	}
+	return p.conns[(atomic.AddUint32(&p.next, 1)-1)%uint32(len(p.conns))]
+}
+
+// Subscribe subscribes to a DxHub Pubsub Stream on one of the connections of the pool.
+// A *SubscriptionError is returned on failure.
+func (p *ConnectionPool) Subscribe(stream string, handler SubscriptionCallback, opts ...SubscribeOption) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.assigned[stream]; ok {
+		return &SubscriptionError{Stream: stream, Err: ErrSubscriptionExists}
+	}
+	conn := p.pick(stream)
+	if err := conn.Subscribe(stream, handler, opts...); err != nil {
+		return err
+	}
+	p.assigned[stream] = conn
+	return nil
+}
+
+// Unsubscribe unsubscribes from a DxHub Pubsub Stream. A *SubscriptionError is returned on failure.
+func (p *ConnectionPool) Unsubscribe(stream string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.assigned[stream]
+	if !ok {
+		return &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	}
+	if err := conn.Unsubscribe(stream); err != nil {
+		return err
+	}
+	delete(p.assigned, stream)
+	return nil
+}
+
+// Publish publishes a message to the stream through one of the connections of the pool.
+func (p *ConnectionPool) Publish(ctx context.Context, stream string, headers map[string]string, payload []byte) (*PublishResult, error) {
+	return p.pick(stream).Publish(ctx, stream, headers, payload)
+}
+
+// PublishAsync publishes a message to the stream asynchronously through one of the connections of
+// the pool. See Connection.PublishAsync.
+func (p *ConnectionPool) PublishAsync(stream string, headers map[string]string, payload []byte, result chan *PublishResult) (msgID string, cancel func(), err error) {
+	return p.pick(stream).PublishAsync(stream, headers, payload, result)
+}
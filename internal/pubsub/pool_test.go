@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, s *httptest.Server, size int, strategy PoolStrategy) *ConnectionPool {
+	u, _ := url.Parse(s.URL)
+	p, err := NewConnectionPool(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+		PollInterval: 10 * time.Millisecond,
+	}, size, strategy)
+	require.NoError(t, err)
+	require.NoError(t, p.Connect(context.Background()))
+	return p
+}
+
+func Test_ConnectionPool(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	p := newTestPool(t, s, 3, PoolRoundRobin)
+
+	received := make(chan string, 3)
+	streams := []string{"stream-1", "stream-2", "stream-3"}
+	for _, stream := range streams {
+		stream := stream
+		err := p.Subscribe(stream, func(err error, _ string, _ map[string]string, payload []byte) {
+			require.NoError(t, err)
+			received <- stream + ":" + string(payload)
+		})
+		require.NoError(t, err)
+	}
+	err := p.Subscribe("stream-1", func(error, string, map[string]string, []byte) {})
+	require.True(t, errors.Is(err, ErrSubscriptionExists))
+
+	// the subscriptions are spread over the connections
+	for _, conn := range p.Connections() {
+		require.Len(t, conn.Subscriptions(), 1)
+	}
+
+	for _, stream := range streams {
+		r, err := p.Publish(context.Background(), stream, nil, []byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, r.Error)
+	}
+	got := map[string]bool{}
+	for range streams {
+		select {
+		case msg := <-received:
+			got[msg] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	require.Equal(t, map[string]bool{"stream-1:hello": true, "stream-2:hello": true, "stream-3:hello": true}, got)
+
+	require.NoError(t, p.Unsubscribe("stream-2"))
+	require.True(t, errors.Is(p.Unsubscribe("stream-2"), ErrSubscriptionNotFound))
+
+	// the Error channel is closed once all the connections are disconnected
+	p.Disconnect()
+	select {
+	case err, ok := <-p.Error:
+		require.False(t, ok, "unexpected error %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Error channel not closed")
+	}
+}
+
+func Test_ConnectionPoolHash(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	p := newTestPool(t, s, 4, PoolHash)
+	defer p.Disconnect()
+
+	// all the operations on a stream go through the same connection
+	for _, stream := range []string{"a", "b", "c", "d", "e"} {
+		conn := p.pick(stream)
+		for i := 0; i < 3; i++ {
+			require.Same(t, conn, p.pick(stream))
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cisco-pxgrid/websocket"
+)
+
+const (
+	// ProtocolV1 frames the RPC messages as text WebSocket messages. It's assumed for servers that
+	// don't negotiate a protocol version.
+	ProtocolV1 = 1
+	// ProtocolV2 frames the RPC messages as binary WebSocket messages, which spares the
+	// UTF-8 validation of large payloads.
+	ProtocolV2 = 2
+
+	// latestProtocolVersion is the latest protocol version supported by the SDK
+	latestProtocolVersion = ProtocolV2
+
+	protocolSubprotocolPrefix = "dxhub.pubsub.v"
+)
+
+// protocolSubprotocols returns the WebSocket subprotocols offered to the server, the preferred
+// protocol version first
+func protocolSubprotocols() []string {
+	var subprotocols []string
+	for v := latestProtocolVersion; v >= ProtocolV1; v-- {
+		subprotocols = append(subprotocols, protocolSubprotocolPrefix+strconv.Itoa(v))
+	}
+	return subprotocols
+}
+
+// negotiatedProtocolVersion returns the protocol version of the subprotocol selected by the
+// server. An error wrapping ErrProtocolUnsupported is returned if the version is below the
+// minimum.
+func negotiatedProtocolVersion(subprotocol string, min int) (int, error) {
+	version := ProtocolV1
+	if strings.HasPrefix(subprotocol, protocolSubprotocolPrefix) {
+		if v, err := strconv.Atoi(strings.TrimPrefix(subprotocol, protocolSubprotocolPrefix)); err == nil {
+			version = v
+		}
+	}
+	if version < min {
+		return 0, fmt.Errorf("%w: server supports version %d, minimum is %d", ErrProtocolUnsupported, version, min)
+	}
+	return version, nil
+}
+
+// messageType returns the WebSocket message type of the RPC messages for the protocol version
+func (c *internalConnection) messageType() websocket.MessageType {
+	if c.protocolVersion >= ProtocolV2 {
+		return websocket.MessageBinary
+	}
+	return websocket.MessageText
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server, 0 if not connected yet.
+func (c *internalConnection) ProtocolVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocolVersion
+}
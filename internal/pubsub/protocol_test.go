@@ -0,0 +1,82 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   int
+		expected int
+	}{
+		{name: "no negotiation", server: 0, expected: ProtocolV1},
+		{name: "v1", server: ProtocolV1, expected: ProtocolV1},
+		{name: "v2", server: ProtocolV2, expected: ProtocolV2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := test.NewRPCServer(t, test.Config{
+				PubSubPath:        apiPaths.pubsub,
+				SubscriptionsPath: apiPaths.subscriptions,
+				ProtocolVersion:   tt.server,
+			})
+			defer s.Close()
+
+			c := newTestConnection(t, s, Config{
+				PollInterval: 10 * time.Millisecond,
+			})
+			defer c.disconnect()
+			require.Equal(t, tt.expected, c.ProtocolVersion())
+
+			// the server verifies the framing of the messages
+			received := make(chan []byte, 1)
+			_, err := c.subscribe("test-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+				require.NoError(t, err)
+				received <- payload
+			})
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+			require.NoError(t, err)
+			select {
+			case p := <-received:
+				require.Equal(t, []byte("test payload"), p)
+			case <-time.After(time.Second):
+				t.Fatal("message wasn't consumed")
+			}
+		})
+	}
+}
+
+func Test_ProtocolUnsupported(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ProtocolVersion:   ProtocolV1,
+	})
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		TLSConfig:          &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		MinProtocolVersion: ProtocolV2,
+	})
+	require.NoError(t, err)
+	err = c.connect(context.Background())
+	require.ErrorIs(t, err, ErrProtocolUnsupported)
+	require.Equal(t, 0, c.ProtocolVersion())
+}
@@ -5,15 +5,15 @@ package pubsub
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
-	"github.com/cisco-pxgrid/cloud-sdk-go/log"
 )
 
 type msgRequest struct {
+	ctx     context.Context // the request isn't written once ctx is done
 	req     *rpc.Request
 	handler func(*rpc.Response)
 }
@@ -22,10 +22,18 @@ type msgRequest struct {
 type PublishResult struct {
 	ID    string // Message ID
 	Error error  // Error shall be non-nil in case of an error
+
+	// Metadata assigned by the server to a published message, zero if the server didn't return it
+	Partition int       // partition of the stream the message was assigned to
+	Offset    int64     // offset of the message in the partition
+	Timestamp time.Time // time the server accepted the message
 }
 
 type pubResultAck struct {
-	ch chan *PublishResult
+	ch       chan *PublishResult
+	canceled chan struct{}        // closed by the cancel function of PublishAsync
+	release  func()               // releases the in-flight publish, see acquirePublish
+	fn       func(*PublishResult) // invoked with the result instead of sending it to ch if set
 	sync.Mutex
 }
 
@@ -33,90 +41,243 @@ func (p *PublishResult) String() string {
 	return fmt.Sprintf("PublishResult[ID: %s, Error: %v]", p.ID, p.Error)
 }
 
-func (c *internalConnection) sendPublishMessage(stream string, headers map[string]string, payload string, ack *pubResultAck) (string, error) {
+func (c *internalConnection) sendPublishMessage(ctx context.Context, stream string, headers map[string]string, payload string, ack *pubResultAck) (string, error) {
 	// Create a new request for publishing the message
 	req, err := rpc.NewPublishRequest(stream, headers, payload)
 	if err != nil {
-		log.Logger.Errorf("Failed to create message for publish: %v", err)
+		c.logger().Errorf("Failed to create message for publish: %v", err)
 		return "", err
 	}
+	// the publish is pending for Flush until its in-flight slot is released
+	c.pendingPublishes.add(req.ID)
+	release := ack.release
+	ack.release = func() {
+		c.pendingPublishes.remove(req.ID)
+		release()
+	}
 
-	var handler func(*rpc.Response) = nil
-	ack.Lock()
-	if ack.ch != nil {
-		// define the handler
-		handler = func(resp *rpc.Response) {
-			pr := &PublishResult{ID: resp.ID}
-			// this lock gets activated when handler is invoked, this is acquired in a different
-			// context than the one above
-			ack.Lock()
-			defer ack.Unlock()
-
-			// Have to perform channel check here again because by the time the response is received
-			// the user might've given up and set the ack channel to nil
-			if ack.ch != nil {
-				// Create PublishResult based on the response
-				if resp.Error.Code == 0 {
-					rpcResult, rpcErr := resp.PublishResult()
-					if rpcErr != nil {
-						pr.Error = rpcErr
-					} else if rpcResult.Status != rpc.ResultStatusSuccess {
-						pr.Error = fmt.Errorf(rpcResult.Status)
-					} else {
-						pr.Error = nil
-					}
-				} else {
-					pr.Error = fmt.Errorf("%d: %s", resp.Error.Code, resp.Error.Message)
-				}
+	var timer *time.Timer // see Config.DefaultRPCTimeout
+	complete := func(pr *PublishResult) {
+		if timer != nil {
+			timer.Stop()
+		}
+		ack.release()
+		c.config.Metrics.IncPublish(stream, pr.Error == nil)
+		if pr.Error == nil {
+			c.touch()
+		}
+
+		if ack.fn != nil {
+			go ack.fn(pr)
+			return
+		}
+
+		// this lock gets activated when handler is invoked, this is acquired in a different
+		// context than the one in which the message is sent
+		ack.Lock()
+		defer ack.Unlock()
 
-				// Send PublishResult back to the user
-				select {
-				case ack.ch <- pr:
-				default:
+		// Have to perform channel check here because by the time the response is received
+		// the user might've given up and set the ack channel to nil
+		if ack.ch != nil {
+			// Send PublishResult back to the user
+			c.deliverAsyncAck(ack, pr)
+		}
+	}
+	handler := func(resp *rpc.Response) {
+		// Create PublishResult based on the response
+		pr := &PublishResult{ID: resp.ID}
+		if resp.Error.Code == 0 {
+			rpcResult, rpcErr := resp.PublishResult()
+			if rpcErr != nil {
+				pr.Error = rpcErr
+			} else if rpcResult.Status != rpc.ResultStatusSuccess {
+				pr.Error = fmt.Errorf(rpcResult.Status)
+			} else {
+				pr.Error = nil
+				pr.Partition = rpcResult.Partition
+				pr.Offset = rpcResult.Offset
+				if rpcResult.Timestamp != 0 {
+					pr.Timestamp = time.Unix(0, rpcResult.Timestamp*int64(time.Millisecond))
 				}
 			}
+		} else {
+			pr.Error = c.newRPCError(resp.Error)
 		}
+		complete(pr)
+	}
+	// the ack is lost, see Config.AckCorrelationTTL
+	expire := func() {
+		complete(&PublishResult{ID: req.ID, Error: ErrAckTimeout})
+	}
+
+	if _, ok := ctx.Deadline(); !ok && c.config.DefaultRPCTimeout > 0 {
+		// the pending response is dropped once the timeout elapses, unless it arrived already
+		timer = time.AfterFunc(c.config.DefaultRPCTimeout, func() {
+			if c.msgHandlers.GetAndDelete(req.ID) != nil {
+				expire()
+			}
+		})
 	}
-	ack.Unlock()
 
 	// Send the message over the network
-	err = c.sendMessage(req, handler)
+	err = c.sendMessageContext(ctx, req, handler, expire)
 	if err != nil {
+		if timer != nil {
+			timer.Stop()
+		}
+		ack.release()
+		c.config.Metrics.IncPublish(stream, false)
 		return "", err
 	}
 	return req.ID, nil
 }
 
+// validateMessage checks the headers and payload against the configured limits and the header
+// contract of the server.
+func (c *internalConnection) validateMessage(headers map[string]string, payload []byte) error {
+	if c.config.MaxPayloadBytes > 0 && len(payload) > c.config.MaxPayloadBytes {
+		return &LimitError{Err: ErrPayloadTooLarge, Size: len(payload), Limit: c.config.MaxPayloadBytes}
+	}
+	size := 0
+	for k, v := range headers {
+		if !validHeaderKey(k) {
+			return fmt.Errorf("%w: key %q", ErrInvalidHeader, k)
+		}
+		if !validHeaderValue(v) {
+			return fmt.Errorf("%w: value of key %q", ErrInvalidHeader, k)
+		}
+		size += len(k) + len(v)
+	}
+	if c.config.MaxHeaderBytes > 0 && size > c.config.MaxHeaderBytes {
+		return &LimitError{Err: ErrHeadersTooLarge, Size: size, Limit: c.config.MaxHeaderBytes}
+	}
+	return nil
+}
+
+// withDefaultHeaders returns the headers of a message published to the stream merged with
+// Config.SourceMetadata, Config.DefaultHeaders and Config.StreamHeaders, each taking precedence
+// over the previous ones. The headers take precedence over all of them, the supplied map is never
+// modified.
+func (c *internalConnection) withDefaultHeaders(stream string, headers map[string]string) map[string]string {
+	streamHeaders := c.config.StreamHeaders[stream]
+	n := len(c.config.SourceMetadata) + len(c.config.DefaultHeaders) + len(streamHeaders)
+	if n == 0 {
+		return headers
+	}
+	h := make(map[string]string, n+len(headers))
+	for _, defaults := range []map[string]string{c.config.SourceMetadata, c.config.DefaultHeaders, streamHeaders, headers} {
+		for k, v := range defaults {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+// validHeaderKey returns true if the key is non-empty and consists of printable ASCII characters
+// other than space and colon
+func validHeaderKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] >= 0x7f || k[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue returns true if the value doesn't contain any control characters
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if (v[i] < ' ' && v[i] != '\t') || v[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeMessage applies the configured compression and encodes the payload for transport. Raw
+// payloads are sent as is.
+func (c *internalConnection) encodeMessage(headers map[string]string, payload []byte) (map[string]string, string, error) {
+	if isRaw(headers) {
+		return headers, string(payload), validateRaw(payload)
+	}
+	headers, payload, err := compress(c.config.Compression, headers, payload)
+	if err != nil {
+		return nil, "", err
+	}
+	return headers, c.config.PayloadEncoding.encode(payload), nil
+}
+
 // Publish publishes a message to the stream asynchronously.
+// It waits while Config.MaxInFlightPublishes publishes are waiting for their response, up to ctx.
 func (c *internalConnection) Publish(ctx context.Context, stream string, headers map[string]string, payload []byte) (*PublishResult, error) {
+	if err := c.config.Mode.checkPublish(); err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	headers = c.withDefaultHeaders(stream, headers)
+	if err := c.validateMessage(headers, payload); err != nil {
+		return nil, err
+	}
+	if err := c.waitPublishRate(ctx); err != nil {
+		return nil, err
+	}
+	headers = c.injectTrace(ctx, headers)
+	headers, encoded, err := c.encodeMessage(headers, payload)
+	if err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	release, err := c.acquirePublish(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	// buffered so that the result is never subject to the AsyncAckPolicy
 	ack := &pubResultAck{
-		ch: make(chan *PublishResult),
+		ch:      make(chan *PublishResult, 1),
+		release: release,
 	}
-	id, err := c.sendPublishMessage(stream, headers, base64.StdEncoding.EncodeToString(payload), ack)
+	id, err := c.sendPublishMessage(ctx, stream, headers, encoded, ack)
 	if err != nil {
-		return nil, fmt.Errorf("publish failure: %v", err)
+		return nil, fmt.Errorf("publish failure: %w", err)
 	}
 
 	select {
 	case r := <-ack.ch:
 		return r, nil
 	case <-ctx.Done():
-		return nil, fmt.Errorf("timed out waiting for publish response for message %s", id)
+		// the response is of no use anymore, the message isn't written if it's still queued
+		c.msgHandlers.Delete(id)
+		ack.release()
+		return nil, fmt.Errorf("timed out waiting for publish response for message %s: %w", id, ctx.Err())
+	case <-c.closed:
+		ack.release()
+		return nil, fmt.Errorf("publish failure for message %s: %w", id, ErrNotConnected)
 	}
 }
 
 // PublishAsync publishes a message to the stream asynchronously.
 // Response can be monitored on the supplied channel. The cancel function must be invoked before closing the channel.
+// Config.AsyncAckPolicy determines what happens when the channel isn't ready to receive the response.
+// ErrTooManyInFlight is returned while Config.MaxInFlightPublishes publishes are waiting for their response.
 func (c *internalConnection) PublishAsync(stream string, headers map[string]string, payload []byte, result chan *PublishResult) (msgID string, cancel func(), err error) {
 	ack := &pubResultAck{
-		ch: result,
+		ch:       result,
+		canceled: make(chan struct{}),
 	}
-	id, err := c.sendPublishMessage(stream, headers, base64.StdEncoding.EncodeToString(payload), ack)
+	id, err := c.publishAsync(stream, headers, payload, ack)
 	if err != nil {
-		return "", nil, fmt.Errorf("publish failure: %v", err)
+		return "", nil, err
 	}
 
+	var cancelOnce sync.Once
 	cancel = func() {
+		// wake up a result delivery blocked by the AsyncAckPolicy before taking the lock
+		cancelOnce.Do(func() { close(ack.canceled) })
 		ack.Lock()
 		defer ack.Unlock()
 
@@ -125,3 +286,72 @@ func (c *internalConnection) PublishAsync(stream string, headers map[string]stri
 
 	return id, cancel, nil
 }
+
+// PublishAsyncFunc publishes a message to the stream asynchronously and invokes callback with the
+// response in a separate goroutine, so a slow callback doesn't hold up the connection.
+// ErrTooManyInFlight is returned while Config.MaxInFlightPublishes publishes are waiting for their response.
+func (c *internalConnection) PublishAsyncFunc(stream string, headers map[string]string, payload []byte, callback func(*PublishResult)) (msgID string, err error) {
+	return c.publishAsync(stream, headers, payload, &pubResultAck{fn: callback})
+}
+
+// PublishNoAck publishes a message to the stream without tracking its response, for high volume
+// messages whose acks don't matter. Only the errors detected before the request is queued for
+// sending are returned, e.g. ErrWriterBusy, the response of the server is discarded. The publish
+// doesn't count towards Config.MaxInFlightPublishes.
+func (c *internalConnection) PublishNoAck(stream string, headers map[string]string, payload []byte) error {
+	if err := c.config.Mode.checkPublish(); err != nil {
+		return fmt.Errorf("publish failure: %w", err)
+	}
+	if c.isClosed() {
+		return fmt.Errorf("publish failure: %w", ErrNotConnected)
+	}
+	headers = c.withDefaultHeaders(stream, headers)
+	if err := c.validateMessage(headers, payload); err != nil {
+		return err
+	}
+	if err := c.waitPublishRate(c.ctx); err != nil {
+		return err
+	}
+	headers, encoded, err := c.encodeMessage(headers, payload)
+	if err != nil {
+		return fmt.Errorf("publish failure: %w", err)
+	}
+	req, err := rpc.NewPublishRequest(stream, headers, encoded)
+	if err != nil {
+		return fmt.Errorf("publish failure: %w", err)
+	}
+	// without a handler the response is dropped by the processor
+	if err := c.sendMessageContext(context.Background(), req, nil, nil); err != nil {
+		return fmt.Errorf("publish failure: %w", err)
+	}
+	return nil
+}
+
+// publishAsync sends the publish request without waiting for the response, which is delivered
+// through ack
+func (c *internalConnection) publishAsync(stream string, headers map[string]string, payload []byte, ack *pubResultAck) (string, error) {
+	if err := c.config.Mode.checkPublish(); err != nil {
+		return "", fmt.Errorf("publish failure: %w", err)
+	}
+	headers = c.withDefaultHeaders(stream, headers)
+	if err := c.validateMessage(headers, payload); err != nil {
+		return "", err
+	}
+	if err := c.waitPublishRate(c.ctx); err != nil {
+		return "", err
+	}
+	headers, encoded, err := c.encodeMessage(headers, payload)
+	if err != nil {
+		return "", fmt.Errorf("publish failure: %w", err)
+	}
+	release, err := c.acquirePublish(context.Background(), false)
+	if err != nil {
+		return "", fmt.Errorf("publish failure: %w", err)
+	}
+	ack.release = release
+	id, err := c.sendPublishMessage(context.Background(), stream, headers, encoded, ack)
+	if err != nil {
+		return "", fmt.Errorf("publish failure: %w", err)
+	}
+	return id, nil
+}
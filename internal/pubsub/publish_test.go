@@ -0,0 +1,740 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PublishLimits(t *testing.T) {
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  "example.com",
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		MaxPayloadBytes: 8,
+		MaxHeaderBytes:  8,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("too large payload"))
+	require.True(t, errors.Is(err, ErrPayloadTooLarge))
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, 8, limitErr.Limit)
+	require.Equal(t, 17, limitErr.Size)
+
+	_, _, err = c.PublishAsync("test-stream", map[string]string{"key": "long value"}, nil, nil)
+	require.True(t, errors.Is(err, ErrHeadersTooLarge))
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, 13, limitErr.Size)
+}
+
+func Test_PublishInvalidHeaders(t *testing.T) {
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  "example.com",
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+	})
+	require.NoError(t, err)
+
+	for _, headers := range []map[string]string{
+		{"": "value"},
+		{"bad key": "value"},
+		{"bad:key": "value"},
+		{"key": "bad\r\nvalue"},
+	} {
+		_, _, err = c.PublishAsync("test-stream", headers, []byte("payload"), nil)
+		require.True(t, errors.Is(err, ErrInvalidHeader), "headers: %q", headers)
+	}
+
+	require.NoError(t, c.validateMessage(map[string]string{"key": "value\twith tab"}, nil))
+}
+
+func Test_PublishWithRetry(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishFailures:   2,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	subCh := make(chan map[string]string, 1)
+	_, err := c.subscribe("test-stream", "",
+		func(e error, _ string, headers map[string]string, _ []byte) {
+			require.NoError(t, e)
+			subCh <- headers
+		})
+	require.NoError(t, err)
+
+	attempts := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	r, err := c.PublishWithRetry(ctx, "test-stream", nil, []byte("test payload"), RetryOptions{
+		InitialBackoff: 10 * time.Millisecond,
+		IdempotencyKey: "test-key",
+		Retryable: func(err error) bool {
+			attempts++
+			return IsRetryable(err)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	require.Equal(t, 2, attempts)
+
+	select {
+	case headers := <-subCh:
+		require.Equal(t, "test-key", headers[HeaderIdempotencyKey])
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+}
+
+func Test_PublishWithRetryThrottled(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:         apiPaths.pubsub,
+		SubscriptionsPath:  apiPaths.subscriptions,
+		PublishThrottles:   1,
+		ThrottleRetryAfter: 300 * time.Millisecond,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+	require.Equal(t, ThrottleState{}, c.ThrottleState())
+
+	var failures []error
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	r, err := c.PublishWithRetry(ctx, "test-stream", nil, []byte("test payload"), RetryOptions{
+		InitialBackoff: 10 * time.Millisecond,
+		Retryable: func(err error) bool {
+			failures = append(failures, err)
+			return IsRetryable(err)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	// the retry waits for the delay requested by the server rather than the backoff
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	require.Len(t, failures, 1)
+	var rpcErr *RPCError
+	require.True(t, errors.As(failures[0], &rpcErr))
+	require.True(t, rpcErr.Throttled())
+	require.Equal(t, 300*time.Millisecond, rpcErr.RetryAfter)
+
+	state := c.ThrottleState()
+	require.False(t, state.Throttled)
+	require.Equal(t, int64(1), state.Count)
+	require.Equal(t, 300*time.Millisecond, state.RetryAfter)
+	require.False(t, state.LastAt.IsZero())
+}
+
+func Test_PublishWithRetryNonRetryable(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishError:      true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	attempts := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	r, err := c.PublishWithRetry(ctx, "test-stream", nil, []byte("test payload"), RetryOptions{
+		MaxAttempts: 5,
+		Retryable: func(err error) bool {
+			attempts++
+			return false
+		},
+	})
+	require.NoError(t, err)
+	var rpcErr *RPCError
+	require.True(t, errors.As(r.Error, &rpcErr))
+	require.True(t, rpcErr.Temporary())
+	require.Equal(t, 1, attempts)
+}
+
+func Test_PublishConsumeOrdering(t *testing.T) {
+	const numMessages = 20
+	payloads := make([]string, numMessages)
+	for i := range payloads {
+		payloads[i] = fmt.Sprintf("message %d", i)
+	}
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishOrder:      map[string][]string{"test-stream": payloads},
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	consumeOrder := test.ExpectOrder(t, payloads...)
+	_, err := c.subscribe("test-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		consumeOrder.Record(string(payload))
+	})
+	require.NoError(t, err)
+
+	for _, p := range payloads {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(ctx, "test-stream", nil, []byte(p))
+		cancel()
+		require.NoError(t, err)
+	}
+	consumeOrder.Wait(5 * time.Second)
+}
+
+func Test_PublishSourceMetadata(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	metadata := map[string]string{"source-service": "test-service", "source-version": "1.0.0"}
+	c := newTestConnection(t, s, Config{
+		PollInterval:   10 * time.Millisecond,
+		SourceMetadata: metadata,
+	})
+	defer c.disconnect()
+
+	received := make(chan map[string]string, 2)
+	_, err := c.subscribe("test-stream", "", func(err error, _ string, headers map[string]string, _ []byte) {
+		require.NoError(t, err)
+		received <- headers
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", map[string]string{"key": "value"}, []byte("payload"))
+	require.NoError(t, err)
+	headers := <-received
+	require.Equal(t, "test-service", headers["source-service"])
+	require.Equal(t, "1.0.0", headers["source-version"])
+	require.Equal(t, "value", headers["key"])
+
+	// per-call headers override the source metadata
+	result := make(chan *PublishResult, 1)
+	_, cancelAsync, err := c.PublishAsync("test-stream", map[string]string{"source-version": "2.0.0"}, []byte("payload"), result)
+	require.NoError(t, err)
+	defer cancelAsync()
+	headers = <-received
+	require.Equal(t, "test-service", headers["source-service"])
+	require.Equal(t, "2.0.0", headers["source-version"])
+	require.Equal(t, map[string]string{"source-service": "test-service", "source-version": "1.0.0"}, metadata)
+}
+
+func Test_PublishDefaultHeaders(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval:   10 * time.Millisecond,
+		SourceMetadata: map[string]string{"source-service": "test-service", "tenant": "metadata"},
+		DefaultHeaders: map[string]string{"tenant": "default-tenant", "app": "default-app"},
+		StreamHeaders: map[string]map[string]string{
+			"default-headers-2": {"app": "stream-app"},
+		},
+	})
+	defer c.disconnect()
+
+	received := make(chan map[string]string, 1)
+	handler := func(err error, _ string, headers map[string]string, _ []byte) {
+		require.NoError(t, err)
+		received <- headers
+	}
+	for _, stream := range []string{"default-headers-1", "default-headers-2"} {
+		_, err := c.subscribe(stream, "", handler)
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	publish := func(stream string, headers map[string]string) map[string]string {
+		r, err := c.Publish(ctx, stream, headers, []byte("payload"))
+		require.NoError(t, err)
+		require.NoError(t, r.Error)
+		select {
+		case h := <-received:
+			return h
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+		return nil
+	}
+
+	// nil headers still get the defaults
+	headers := publish("default-headers-1", nil)
+	require.Equal(t, "test-service", headers["source-service"])
+	require.Equal(t, "default-tenant", headers["tenant"])
+	require.Equal(t, "default-app", headers["app"])
+
+	headers = publish("default-headers-2", nil)
+	require.Equal(t, "default-tenant", headers["tenant"])
+	require.Equal(t, "stream-app", headers["app"])
+
+	// per-call headers take precedence
+	headers = publish("default-headers-2", map[string]string{"app": "call-app"})
+	require.Equal(t, "default-tenant", headers["tenant"])
+	require.Equal(t, "call-app", headers["app"])
+}
+
+func Test_PublishContext(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	// cancelled mid-publish, the server never responds
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	// the pending response entry is dropped
+	require.Zero(t, c.msgHandlers.Len())
+
+	// already cancelled, nothing is sent
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, c.msgHandlers.Len())
+}
+
+func Test_AckCorrelationTTL(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		AckCorrelationTTL: 50 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	// the ack never arrives, the publish fails once its entry is evicted
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	r, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	require.ErrorIs(t, r.Error, ErrAckTimeout)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+	require.Zero(t, c.msgHandlers.Len())
+	require.Zero(t, c.InFlightPublishes())
+
+	// same for an async publish
+	result := make(chan *PublishResult, 1)
+	id, cancelAsync, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+	require.NoError(t, err)
+	defer cancelAsync()
+	select {
+	case r := <-result:
+		require.Equal(t, id, r.ID)
+		require.ErrorIs(t, r.Error, ErrAckTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("publish didn't fail")
+	}
+	require.Zero(t, c.msgHandlers.Len())
+}
+
+func Test_DefaultRPCTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		DefaultRPCTimeout: 50 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	// PublishAsync has no context, the ack is signaled with the timeout
+	result := make(chan *PublishResult, 1)
+	id, cancel, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+	require.NoError(t, err)
+	defer cancel()
+	select {
+	case r := <-result:
+		require.Equal(t, id, r.ID)
+		require.ErrorIs(t, r.Error, ErrAckTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("publish didn't time out")
+	}
+	require.Zero(t, c.msgHandlers.Len())
+	require.Zero(t, c.InFlightPublishes())
+
+	// the deadline of the context takes precedence
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelCtx()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func Test_PublishResultMetadata(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	start := time.Now().Truncate(time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r1, err := c.Publish(ctx, "test-stream-metadata", nil, []byte("test payload 1"))
+	require.NoError(t, err)
+	require.NoError(t, r1.Error)
+	require.False(t, r1.Timestamp.Before(start))
+	require.False(t, r1.Timestamp.After(time.Now()))
+
+	result := make(chan *PublishResult, 1)
+	_, cancelAsync, err := c.PublishAsync("test-stream-metadata", nil, []byte("test payload 2"), result)
+	require.NoError(t, err)
+	defer cancelAsync()
+	r2 := <-result
+	require.NoError(t, r2.Error)
+	require.Equal(t, r1.Offset+1, r2.Offset)
+	require.Equal(t, r1.Partition, r2.Partition)
+	require.False(t, r2.Timestamp.Before(r1.Timestamp))
+}
+
+func Test_MaxInFlightPublishes(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		MaxInFlightPublishes: 2,
+	})
+	defer c.disconnect()
+
+	result := make(chan *PublishResult, 2)
+	for i := 0; i < 2; i++ {
+		_, cancel, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+		require.NoError(t, err)
+		defer cancel()
+	}
+	require.Equal(t, 2, c.InFlightPublishes())
+
+	_, _, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+	require.ErrorIs(t, err, ErrTooManyInFlight)
+
+	// Publish waits for a publish to complete up to its context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 2, c.InFlightPublishes())
+}
+
+func Test_InFlightPublishesReleased(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		MaxInFlightPublishes: 1,
+	})
+	defer c.disconnect()
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+		cancel()
+		require.NoError(t, err)
+	}
+	result := make(chan *PublishResult, 1)
+	_, cancelAsync, err := c.PublishAsync("test-stream", nil, []byte("test payload"), result)
+	require.NoError(t, err)
+	defer cancelAsync()
+	require.NoError(t, (<-result).Error)
+	require.Zero(t, c.InFlightPublishes())
+}
+
+func Test_PublishAsyncFunc(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishFailures:   1,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	results := make(chan *PublishResult, 2)
+	release := make(chan struct{})
+	// the first callback blocks, which must not hold up the result of the second publish
+	id1, err := c.PublishAsyncFunc("test-stream", nil, []byte("test payload 1"), func(r *PublishResult) {
+		<-release
+		results <- r
+	})
+	require.NoError(t, err)
+	id2, err := c.PublishAsyncFunc("test-stream", nil, []byte("test payload 2"), func(r *PublishResult) {
+		results <- r
+	})
+	require.NoError(t, err)
+
+	select {
+	case r := <-results:
+		require.Equal(t, id2, r.ID)
+		require.NoError(t, r.Error)
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the callback")
+	}
+	close(release)
+	select {
+	case r := <-results:
+		require.Equal(t, id1, r.ID)
+		require.Error(t, r.Error)
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the callback")
+	}
+}
+
+func Test_PublishRateLimit(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PublishRateLimit: 20,
+	})
+	defer c.disconnect()
+
+	// the first publish uses the burst, the following ones wait 50ms each
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := c.Publish(ctx, "test-stream", nil, []byte("payload"))
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	results := make(chan *PublishResult, 1)
+	_, err := c.PublishAsyncFunc("test-stream", nil, []byte("payload"), func(r *PublishResult) { results <- r })
+	require.NoError(t, err)
+	require.NoError(t, (<-results).Error)
+	require.GreaterOrEqual(t, time.Since(start), 240*time.Millisecond)
+
+	// waiting would exceed the deadline
+	c.SetPublishRateLimit(1, 1)
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("payload"))
+	require.NoError(t, err)
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	_, err = c.Publish(shortCtx, "test-stream", nil, []byte("payload"))
+	require.True(t, errors.Is(err, ErrRateLimited), "unexpected error: %v", err)
+
+	// no limit
+	c.SetPublishRateLimit(0, 0)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := c.Publish(ctx, "test-stream", nil, []byte("payload"))
+		require.NoError(t, err)
+	}
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func Test_PublishNoAck(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+
+	received := make(chan string, 1)
+	_, err := c.subscribe("no-ack-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.PublishNoAck("no-ack-stream", nil, []byte("payload")))
+	require.Zero(t, c.msgHandlers.Len())
+	select {
+	case payload := <-received:
+		require.Equal(t, "payload", payload)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+
+	c.disconnect()
+	require.ErrorIs(t, c.PublishNoAck("no-ack-stream", nil, []byte("payload")), ErrNotConnected)
+}
+
+// publishUntilQueued retries the publish while the writer is busy
+func publishUntilQueued(b *testing.B, publish func() error) {
+	for {
+		err := publish()
+		if !errors.Is(err, ErrWriterBusy) {
+			require.NoError(b, err)
+			return
+		}
+		time.Sleep(10 * time.Microsecond)
+	}
+}
+
+// Benchmark_Publish compares the overhead of PublishAsync and PublishNoAck, e.g. with
+// -benchtime=1000000x for a million messages
+func Benchmark_Publish(b *testing.B) {
+	s := test.NewRPCServer(b, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	payload := []byte("telemetry payload")
+	b.Run("async", func(b *testing.B) {
+		c := newTestConnection(b, s, Config{})
+		defer c.disconnect()
+		result := make(chan *PublishResult, 1024)
+		go func() {
+			for range result {
+			}
+		}()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			publishUntilQueued(b, func() error {
+				_, _, err := c.PublishAsync("bench-publish-async", nil, payload, result)
+				return err
+			})
+		}
+	})
+	b.Run("no-ack", func(b *testing.B) {
+		c := newTestConnection(b, s, Config{})
+		defer c.disconnect()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			publishUntilQueued(b, func() error {
+				return c.PublishNoAck("bench-publish-no-ack", nil, payload)
+			})
+		}
+	})
+}
+
+func Test_Flush(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, c.Flush(ctx))
+
+	var acked int32
+	for i := 0; i < 20; i++ {
+		_, err := c.PublishAsyncFunc("flush-stream", nil, []byte("test payload"), func(r *PublishResult) {
+			require.NoError(t, r.Error)
+			atomic.AddInt32(&acked, 1)
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, c.Flush(ctx))
+	require.Zero(t, c.InFlightPublishes())
+	// the callbacks run in their own goroutine once the response is received
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&acked) == 20 }, time.Second, 10*time.Millisecond)
+}
+
+func Test_FlushTimeout(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		PublishDrop:       true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	result := make(chan *PublishResult, 2)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, cancel, err := c.PublishAsync("flush-timeout-stream", nil, []byte("test payload"), result)
+		require.NoError(t, err)
+		defer cancel()
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := c.Flush(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	var flushErr *FlushError
+	require.ErrorAs(t, err, &flushErr)
+	require.Equal(t, ids, flushErr.IDs)
+	require.Contains(t, err.Error(), ids[0])
+
+	// an abandoned publish isn't pending anymore
+	pubCtx, pubCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer pubCancel()
+	_, err = c.Publish(pubCtx, "flush-timeout-stream", nil, []byte("test payload"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, c.pendingPublishes.list(), 2)
+
+	c.disconnect()
+	err = c.Flush(context.Background())
+	require.ErrorIs(t, err, ErrNotConnected)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	headerQuotaRemaining = "X-RateLimit-Remaining"
+	headerQuotaReset     = "X-RateLimit-Reset" // unix time in seconds
+)
+
+var defaultQuotaLowThreshold = 10
+
+// quota holds the last quota reported by the server
+type quota struct {
+	remaining int
+	resetAt   time.Time
+	ok        bool
+	sync.Mutex
+}
+
+// updateQuota parses the quota headers of a server response and invokes Config.OnQuotaLow when the
+// remaining quota drops below Config.QuotaLowThreshold.
+func (c *internalConnection) updateQuota(header http.Header) {
+	v := header.Get(headerQuotaRemaining)
+	if v == "" {
+		return
+	}
+	remaining, err := strconv.Atoi(v)
+	if err != nil {
+		c.logger().Warnf("Invalid %s header: %s", headerQuotaRemaining, v)
+		return
+	}
+	var resetAt time.Time
+	if v = header.Get(headerQuotaReset); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			resetAt = time.Unix(secs, 0)
+		} else {
+			c.logger().Warnf("Invalid %s header: %s", headerQuotaReset, v)
+		}
+	}
+
+	c.quota.Lock()
+	wasLow := c.quota.ok && c.quota.remaining < c.config.QuotaLowThreshold
+	c.quota.remaining = remaining
+	c.quota.resetAt = resetAt
+	c.quota.ok = true
+	c.quota.Unlock()
+
+	if remaining < c.config.QuotaLowThreshold && !wasLow {
+		c.logger().Warnf("Quota is low: %d remaining, resets at %v", remaining, resetAt)
+		if c.config.OnQuotaLow != nil {
+			c.config.OnQuotaLow(remaining)
+		}
+	}
+}
+
+// quotaMiddleware is a resty response middleware that records the quota reported by the server
+func (c *internalConnection) quotaMiddleware(_ *resty.Client, resp *resty.Response) error {
+	c.updateQuota(resp.Header())
+	return nil
+}
+
+// Quota returns the remaining quota and the time at which it resets, as last reported by the
+// server. ok is false if the server hasn't reported any quota yet.
+func (c *internalConnection) Quota() (remaining int, resetAt time.Time, ok bool) {
+	c.quota.Lock()
+	defer c.quota.Unlock()
+	return c.quota.remaining, c.quota.resetAt, c.quota.ok
+}
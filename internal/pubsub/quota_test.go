@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_QuotaLow(t *testing.T) {
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ResponseHeaders: http.Header{
+			headerQuotaRemaining: []string{"3"},
+			headerQuotaReset:     []string{strconv.FormatInt(resetAt.Unix(), 10)},
+		},
+	})
+	defer s.Close()
+
+	lowCh := make(chan int, 10)
+	c := newTestConnection(t, s, Config{
+		QuotaLowThreshold: 5,
+		OnQuotaLow: func(remaining int) {
+			lowCh <- remaining
+		},
+	})
+	defer c.disconnect()
+
+	// connect reports the quota once, subscription creation reports the same quota again
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	remaining, reset, ok := c.Quota()
+	require.True(t, ok)
+	require.Equal(t, 3, remaining)
+	require.True(t, resetAt.Equal(reset))
+
+	select {
+	case r := <-lowCh:
+		require.Equal(t, 3, r)
+	case <-time.After(time.Second):
+		require.FailNow(t, "OnQuotaLow was not invoked")
+	}
+	require.Empty(t, lowCh, "OnQuotaLow should be invoked only when the quota drops")
+}
+
+func Test_QuotaNotReported(t *testing.T) {
+	c, err := newInternalConnection(Config{
+		GroupID: "test-client",
+		Domain:  "example.com",
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+	})
+	require.NoError(t, err)
+	_, _, ok := c.Quota()
+	require.False(t, ok)
+
+	c.updateQuota(http.Header{headerQuotaRemaining: []string{"invalid"}})
+	_, _, ok = c.Quota()
+	require.False(t, ok)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/time/rate"
+)
+
+// newPublishLimiter returns the limiter of Config.PublishRateLimit and Config.PublishBurst
+func newPublishLimiter(limit rate.Limit, burst int) *rate.Limiter {
+	if limit <= 0 {
+		limit = rate.Inf
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(limit, burst)
+}
+
+// waitPublishRate waits until Config.PublishRateLimit allows a publish. It fails with
+// ErrRateLimited if the wait would exceed the deadline of ctx.
+func (c *internalConnection) waitPublishRate(ctx context.Context) error {
+	if err := c.config.publishLimiter.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("publish failure: %w", ctx.Err())
+		}
+		return fmt.Errorf("publish failure: %w: %v", ErrRateLimited, err)
+	}
+	return nil
+}
+
+// SetPublishRateLimit changes Config.PublishRateLimit and Config.PublishBurst at runtime. A limit
+// of 0 disables the rate limiting.
+func (c *internalConnection) SetPublishRateLimit(limit rate.Limit, burst int) {
+	if limit <= 0 {
+		limit = rate.Inf
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	c.config.publishLimiter.SetLimit(limit)
+	c.config.publishLimiter.SetBurst(burst)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// HeaderPayloadEncoding is the header that marks the messages whose payload isn't base64
+	// encoded for transport
+	HeaderPayloadEncoding = "payload-encoding"
+	// HeaderPayloadEncodingRaw is the value of HeaderPayloadEncoding for the payloads published as is
+	HeaderPayloadEncodingRaw = "raw"
+)
+
+// isRaw returns true if the headers mark the payload as raw
+func isRaw(headers map[string]string) bool {
+	return headers[HeaderPayloadEncoding] == HeaderPayloadEncodingRaw
+}
+
+// validateRaw returns an error wrapping ErrInvalidPayload if the payload can't be sent as is. The
+// payload is carried in a JSON string, which only holds UTF-8 text.
+func validateRaw(payload []byte) error {
+	if !utf8.Valid(payload) {
+		return fmt.Errorf("%w: raw payload must be valid UTF-8", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// rawPayload returns the payload of a message consumed by a subscription with
+// SubscribeOptions.RawPayloads or marked as raw, it fails if the payload was encoded differently
+// than the subscription expects
+func (sub *subscription) rawPayload(msg *Message) ([]byte, error) {
+	if !sub.opts.RawPayloads {
+		return nil, fmt.Errorf("raw payload consumed without RawPayloads")
+	}
+	if !isRaw(msg.Headers) {
+		return nil, fmt.Errorf("payload not marked as raw consumed with RawPayloads")
+	}
+	if _, ok := msg.Headers[headerContentEncoding]; ok {
+		return nil, fmt.Errorf("compressed payload consumed with RawPayloads")
+	}
+	return []byte(msg.raw), nil
+}
+
+// PublishRaw publishes the payload to the stream like Publish but without base64 encoding or
+// compressing it, for subscribers with SubscribeOptions.RawPayloads. The payload must be valid
+// UTF-8 text, ErrInvalidPayload is returned otherwise. The message is marked with the
+// HeaderPayloadEncoding header, the supplied headers aren't modified.
+func (c *Connection) PublishRaw(ctx context.Context, stream string, headers map[string]string, payload []byte) (*PublishResult, error) {
+	if err := validateRaw(payload); err != nil {
+		return nil, fmt.Errorf("publish failure: %w", err)
+	}
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[HeaderPayloadEncoding] = HeaderPayloadEncodingRaw
+	return c.Publish(ctx, stream, h, payload)
+}
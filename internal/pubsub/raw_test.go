@@ -0,0 +1,145 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+type rawResult struct {
+	headers map[string]string
+	payload []byte
+	err     error
+}
+
+func rawCallback(ch chan rawResult) SubscriptionCallback {
+	return func(err error, _ string, headers map[string]string, payload []byte) {
+		ch <- rawResult{headers: headers, payload: payload, err: err}
+	}
+}
+
+func receiveRaw(t *testing.T, ch chan rawResult) rawResult {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(time.Second):
+		require.FailNow(t, "Consume timed out")
+	}
+	return rawResult{}
+}
+
+func Test_PublishRaw(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		Compression:  CompressionGzip,
+	})
+	defer c.Disconnect()
+
+	received := make(chan rawResult, 1)
+	require.NoError(t, c.Subscribe("raw-stream", rawCallback(received), WithRawPayloads()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	headers := map[string]string{"key": "value"}
+	r, err := c.PublishRaw(ctx, "raw-stream", headers, []byte(`{"text":"not encoded"}`))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	require.Equal(t, map[string]string{"key": "value"}, headers)
+
+	m := receiveRaw(t, received)
+	require.NoError(t, m.err)
+	require.Equal(t, `{"text":"not encoded"}`, string(m.payload))
+	require.Equal(t, HeaderPayloadEncodingRaw, m.headers[HeaderPayloadEncoding])
+
+	// text published by another client without the marker is rejected
+	require.True(t, test.PublishRaw("raw-stream", rpc.PublishParams{MsgID: "msg-1", Payload: "plain text"}))
+	require.ErrorIs(t, receiveRaw(t, received).err, ErrInvalidPayload)
+
+	_, err = c.PublishRaw(ctx, "raw-stream", nil, []byte{0xff, 0xfe})
+	require.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func Test_RawPayloadMismatch(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		Compression:  CompressionGzip,
+	})
+	defer c.Disconnect()
+
+	encoded := make(chan rawResult, 1)
+	require.NoError(t, c.Subscribe("raw-mismatch-encoded", rawCallback(encoded)))
+	raw := make(chan rawResult, 1)
+	require.NoError(t, c.Subscribe("raw-mismatch-raw", rawCallback(raw), WithRawPayloads()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// raw message consumed without RawPayloads
+	r, err := c.PublishRaw(ctx, "raw-mismatch-encoded", nil, []byte("raw payload"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	require.ErrorIs(t, receiveRaw(t, encoded).err, ErrInvalidPayload)
+
+	// compressed message consumed with RawPayloads
+	r, err = c.Publish(ctx, "raw-mismatch-raw", nil, []byte("compressed payload"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	require.ErrorIs(t, receiveRaw(t, raw).err, ErrInvalidPayload)
+}
+
+func Test_RawPayloadMixedPublishers(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	rejected := make(chan error, 1)
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		DecodeErrorHandler: func(stream, msgID string, raw string, err error) {
+			rejected <- err
+		},
+	})
+	defer c.Disconnect()
+
+	received := make(chan rawResult, 1)
+	require.NoError(t, c.Subscribe("raw-mixed-stream", rawCallback(received), WithRawPayloads()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// base64 encoded by a publisher without PublishRaw
+	r, err := c.Publish(ctx, "raw-mixed-stream", nil, []byte("encoded payload"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	select {
+	case err := <-rejected:
+		require.ErrorIs(t, err, ErrInvalidPayload)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Encoded message not rejected")
+	}
+
+	r, err = c.PublishRaw(ctx, "raw-mixed-stream", nil, []byte("raw payload"))
+	require.NoError(t, err)
+	require.NoError(t, r.Error)
+	m := receiveRaw(t, received)
+	require.NoError(t, m.err)
+	require.Equal(t, "raw payload", string(m.payload))
+}
@@ -5,36 +5,112 @@ package pubsub
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cisco-pxgrid/cloud-sdk-go/log"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 )
 
+// ConnectionState represents the state of a Connection
+type ConnectionState int
+
+const (
+	// StateDisconnected is the state before Connect and after the connection is closed
+	StateDisconnected ConnectionState = iota
+	// StateConnected is the state of a healthy connection
+	StateConnected
+	// StateReconnecting is the state while the connection is being re-established
+	StateReconnecting
+	// StateFailed is the state after all the reconnect attempts failed. The connection is
+	// permanently closed and a new one must be created.
+	StateFailed
+	// StateIdle is the state while the connection is closed by Config.IdleConnectionTimeout. It's
+	// reopened by the next operation.
+	StateIdle
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateConnected:
+		return "Connected"
+	case StateReconnecting:
+		return "Reconnecting"
+	case StateFailed:
+		return "Failed"
+	case StateIdle:
+		return "Idle"
+	default:
+		return fmt.Sprintf("ConnectionState(%d)", int(s))
+	}
+}
+
+var (
+	defaultReconnectAttempts = 1
+	defaultReconnectDelay    = 1 * time.Second
+)
+
+// ReconnectPolicy controls how the Connection is re-established after a consume timeout
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of reconnect attempts after which the connection transitions to
+	// StateFailed and ErrReconnectExhausted is sent to the Error channel.
+	// Default is 1.
+	MaxAttempts int
+
+	// Delay is the delay between consecutive reconnect attempts.
+	// Default is 1 second.
+	Delay time.Duration
+}
+
 // Connection represents a connection to the DxHub PubSub server.
 type Connection struct {
+	lastUsed      int64 // time of the last operation in Unix nanoseconds, accessed atomically
 	config        Config
 	conn          *internalConnection
 	Error         chan error
 	ctx           context.Context
 	ctxCancel     context.CancelFunc
 	subscriptions map[string]subscriptionParams
+	state         ConnectionState
+	stateChanged  chan struct{} // closed on the next state change if WaitForConnected is waiting
+	idle          chan struct{} // closed once the connection closed for being idle is reopened
+	inFlight      int           // number of operations in progress, see use
+	mu            sync.Mutex    // lock to protect conn, subscriptions, state, idle, inFlight, outbox and flushing
+	idleMu        sync.Mutex    // serializes closing and reopening an idle connection
+	flushMu       sync.Mutex    // serializes the flushes of the outbox, see flushOutbox
+
+	outbox   []*outboxEntry // publishes held while reconnecting, see Config.OutboxSize
+	flushing bool           // true while the held publishes are sent after reconnecting
 }
 
 type subscriptionParams struct {
 	stream         string
 	subscriptionID string
 	handler        SubscriptionCallback
+	ackHandler     AckSubscriptionCallback
+	opts           []SubscribeOption
+	pattern        string             // pattern of the SubscribeDynamic that subscribed the stream, if any
+	ctxCancel      context.CancelFunc // cancels the context returned by SubscribeContext, if any
 }
 
 // NewConnection creates a new connection object based on the supplied configuration.
 func NewConnection(config Config) (*Connection, error) {
+	if config.EphemeralGroup && config.GroupID != "" {
+		config.GroupID = config.GroupID + "-" + uuid.NewString()
+	}
 	conn, err := newInternalConnection(config)
 	if err != nil {
 		return nil, err
 	}
 	c := &Connection{
-		config:        config,
+		config:        conn.config, // config with the defaults applied
 		conn:          conn,
 		Error:         make(chan error, 1),
 		subscriptions: map[string]subscriptionParams{},
@@ -43,16 +119,100 @@ func NewConnection(config Config) (*Connection, error) {
 }
 
 func (c *Connection) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return fmt.Sprintf("Conn[ID: %s, Domain: %s]", c.config.GroupID, c.config.Domain)
 }
 
+// logger returns the logger of the connection
+func (c *Connection) logger() log.SDKLogger {
+	logger := log.Logger
+	if c.config.Logger != nil {
+		logger = c.config.Logger
+	}
+	return log.WithPrefix(logger, "[conn "+c.ID()+"] ")
+}
+
+// ID returns the short random ID of the connection, which is included in its log messages.
+// A new ID is generated whenever the connection is re-established.
+func (c *Connection) ID() string {
+	return c.current().id
+}
+
+// current returns the current internal connection, which is replaced on reconnect
+func (c *Connection) current() *internalConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// State returns the current state of the connection
+func (c *Connection) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// WaitForConnected blocks until the connection is in StateConnected, e.g. while it's being
+// re-established, and returns nil, or the error of ctx once it's done. A connection in StateIdle
+// counts as connected since the next operation reopens it. ErrReconnectExhausted is returned
+// right away in StateFailed.
+func (c *Connection) WaitForConnected(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		state := c.state
+		if c.stateChanged == nil {
+			c.stateChanged = make(chan struct{})
+		}
+		changed := c.stateChanged
+		c.mu.Unlock()
+		switch state {
+		case StateConnected, StateIdle:
+			return nil
+		case StateFailed:
+			return ErrReconnectExhausted
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setState updates the state and invokes Config.OnStateChange if the state changed
+func (c *Connection) setState(state ConnectionState) {
+	c.mu.Lock()
+	changed := c.state != state
+	c.state = state
+	if changed && c.stateChanged != nil {
+		close(c.stateChanged)
+		c.stateChanged = nil
+	}
+	c.mu.Unlock()
+	if changed {
+		c.logger().Debugf("%v state changed to %v", c, state)
+		if c.config.OnStateChange != nil {
+			c.config.OnStateChange(state)
+		}
+	}
+}
+
 // Connect establishes a connection to the DxHub PubSub server.
 func (c *Connection) Connect(connectCtx context.Context) error {
-	if err := c.conn.connect(connectCtx); err != nil {
+	if err := c.current().connect(connectCtx); err != nil {
 		return err
 	}
-	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	c.ctx, c.ctxCancel = context.WithCancel(c.config.BaseContext())
+	c.setState(StateConnected)
 	go c.errorHandler()
+	if c.config.IdleConnectionTimeout > 0 {
+		atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+		go c.idleWatcher()
+	}
+	if c.config.SubscriptionCheckInterval > 0 {
+		go c.subscriptionChecker()
+	}
 	return nil
 }
 
@@ -61,19 +221,174 @@ func (c *Connection) Disconnect() {
 	if c.ctx != nil {
 		c.ctxCancel()
 	}
-	if c.conn != nil {
-		c.conn.disconnect()
+	if conn := c.current(); conn != nil {
+		conn.disconnect()
 	}
+	c.setState(StateDisconnected)
+}
+
+// Close unsubscribes from all the streams, deleting the subscriptions on the server, and closes the
+// connection. The failures are returned in a *MultiError. It's safe to call more than once, the
+// calls after the first one return nil.
+func (c *Connection) Close() error {
+	if c.ctx != nil {
+		c.ctxCancel()
+	}
+	err := c.current().Close()
+	c.mu.Lock()
+	streams := make([]string, 0, len(c.subscriptions))
+	for stream := range c.subscriptions {
+		streams = append(streams, stream)
+	}
+	c.mu.Unlock()
+	c.forget(streams)
+	c.setState(StateDisconnected)
+	return err
+}
+
+// Drain stops issuing new consume requests and waits until the messages of the consume requests
+// already in flight are delivered to the callbacks before disconnecting, up to the deadline of ctx.
+func (c *Connection) Drain(ctx context.Context) error {
+	if c.ctx != nil {
+		c.ctxCancel()
+	}
+	err := c.current().Drain(ctx)
+	c.setState(StateDisconnected)
+	return err
+}
+
+// DrainSubscription stops issuing new consume requests for the stream and waits until the
+// messages of the consume request already in flight are delivered to the callback, then
+// unsubscribes like Unsubscribe, e.g. to hand the stream over to another instance. The other
+// subscriptions and the connection are left alone. If ctx is done first, the stream is
+// unsubscribed anyway and the context error is returned.
+func (c *Connection) DrainSubscription(ctx context.Context, stream string) error {
+	err := c.current().drainSubscription(ctx, stream)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return err
+	}
+	if unsubErr := c.Unsubscribe(stream); unsubErr != nil {
+		return unsubErr
+	}
+	return err
+}
+
+// Abort tears the connection down immediately without unsubscribing on the server, waiting for
+// the callbacks to return or draining. In-flight publishes fail with ErrNotConnected. Use
+// Disconnect or Drain for a graceful shutdown.
+func (c *Connection) Abort() {
+	if c.ctx != nil {
+		c.ctxCancel()
+	}
+	c.current().Abort()
+	c.setState(StateDisconnected)
 }
 
 // IsDisconnected returns true if c is disconnected from the server.
 func (c *Connection) IsDisconnected() bool {
-	return c.conn.isDisconnected()
+	return c.current().isDisconnected()
+}
+
+// Subscribe subscribes to a DxHub Pubsub Stream. A *SubscriptionError is returned on failure.
+// It's safe to call concurrently: for the same stream only one call succeeds, the others fail
+// with ErrSubscriptionExists, see SubscribeOrGet. It fails with ErrOverlappingSubscription if the
+// stream is subscribed by SubscribeDynamic.
+func (c *Connection) Subscribe(stream string, handler SubscriptionCallback, opts ...SubscribeOption) error {
+	return c.SubscribeWithContext(context.Background(), stream, handler, opts...)
+}
+
+// SubscribeWithContext subscribes to a DxHub Pubsub Stream like Subscribe. The creation of the
+// subscription on the server is cancelled when ctx is done, ctx doesn't end the subscription once
+// it's created.
+func (c *Connection) SubscribeWithContext(ctx context.Context, stream string, handler SubscriptionCallback, opts ...SubscribeOption) error {
+	if err := c.checkOverlap(stream); err != nil {
+		return err
+	}
+	conn, release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	subscriptionID, err := conn.subscribeWithContext(ctx, stream, "", handler, opts...)
+	if err != nil {
+		return err
+	}
+	sub := subscriptionParams{
+		stream:         stream,
+		subscriptionID: subscriptionID,
+		handler:        handler,
+		opts:           opts,
+	}
+	c.mu.Lock()
+	c.subscriptions[stream] = sub
+	c.mu.Unlock()
+	return nil
+}
+
+// SubscribeContext subscribes to a DxHub Pubsub Stream like Subscribe and returns a context that's
+// cancelled when the subscription ends for any reason: the stream is unsubscribed or the connection
+// is closed for good. The context outlives reconnects, which restore the subscription.
+func (c *Connection) SubscribeContext(stream string, handler SubscriptionCallback, opts ...SubscribeOption) (context.Context, error) {
+	parent := c.ctx
+	if parent == nil {
+		parent = c.config.BaseContext()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	if err := c.Subscribe(stream, handler, opts...); err != nil {
+		cancel()
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub, ok := c.subscriptions[stream]
+	if !ok {
+		// unsubscribed in the meantime
+		cancel()
+		return ctx, nil
+	}
+	sub.ctxCancel = cancel
+	c.subscriptions[stream] = sub
+	return ctx, nil
+}
+
+// SubscribeOrGet subscribes to a DxHub Pubsub Stream like Subscribe, except that if the stream is
+// already subscribed the ID of the existing subscription is returned instead of an error and
+// handler is ignored. The ID is empty for a lazy subscription that isn't activated. It's safe to
+// call concurrently, exactly one subscription is created on the server.
+func (c *Connection) SubscribeOrGet(stream string, handler SubscriptionCallback, opts ...SubscribeOption) (string, error) {
+	conn, release, err := c.use()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	subscriptionID, created, err := conn.subscribeOrGet(stream, handler, opts...)
+	if err != nil || !created {
+		return subscriptionID, err
+	}
+	c.mu.Lock()
+	c.subscriptions[stream] = subscriptionParams{
+		stream:         stream,
+		subscriptionID: subscriptionID,
+		handler:        handler,
+		opts:           opts,
+	}
+	c.mu.Unlock()
+	return subscriptionID, nil
 }
 
-// Subscribe subscribes to a DxHub Pubsub Stream
-func (c *Connection) Subscribe(stream string, handler SubscriptionCallback) error {
-	subscriptionID, err := c.conn.subscribe(stream, "", handler)
+// SubscribeFrom subscribes to a DxHub Pubsub Stream and starts consuming from the consume context
+// obtained from Subscription.ConsumeContext. An empty consume context starts from the server
+// default position. A *SubscriptionError is returned on failure.
+func (c *Connection) SubscribeFrom(stream string, consumeCtx string, handler SubscriptionCallback, opts ...SubscribeOption) error {
+	if err := c.checkOverlap(stream); err != nil {
+		return err
+	}
+	conn, release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	subscriptionID, err := conn.subscribeFrom(stream, consumeCtx, handler, opts...)
 	if err != nil {
 		return err
 	}
@@ -81,29 +396,268 @@ func (c *Connection) Subscribe(stream string, handler SubscriptionCallback) erro
 		stream:         stream,
 		subscriptionID: subscriptionID,
 		handler:        handler,
+		opts:           opts,
+	}
+	c.mu.Lock()
+	c.subscriptions[stream] = sub
+	c.mu.Unlock()
+	return nil
+}
+
+// SubscribeWithAck subscribes to a DxHub Pubsub Stream. The messages are delivered to handler
+// and can be acked or nacked. A *SubscriptionError is returned on failure.
+func (c *Connection) SubscribeWithAck(stream string, handler AckSubscriptionCallback, opts ...SubscribeOption) error {
+	if err := c.checkOverlap(stream); err != nil {
+		return err
+	}
+	conn, release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	subscriptionID, err := conn.subscribeWithAck(stream, "", handler, opts...)
+	if err != nil {
+		return err
 	}
+	sub := subscriptionParams{
+		stream:         stream,
+		subscriptionID: subscriptionID,
+		ackHandler:     handler,
+		opts:           opts,
+	}
+	c.mu.Lock()
 	c.subscriptions[stream] = sub
+	c.mu.Unlock()
 	return nil
 }
 
-// Unsubscribe unsubscribes from a DxHub Pubsub Stream
+// Unsubscribe unsubscribes from a DxHub Pubsub Stream. A *SubscriptionError is returned on failure,
+// also if the subscription couldn't be deleted on the server within 15 seconds.
 func (c *Connection) Unsubscribe(stream string) error {
-	if err := c.conn.unsubscribe(stream); err != nil {
+	if err := c.current().unsubscribe(stream); err != nil {
+		return err
+	}
+	c.forget([]string{stream})
+	return nil
+}
+
+// UnsubscribeWithContext unsubscribes from a DxHub Pubsub Stream like Unsubscribe, the deletion of
+// the subscription on the server is cancelled when ctx is done. The stream stays subscribed if the
+// deletion fails.
+func (c *Connection) UnsubscribeWithContext(ctx context.Context, stream string) error {
+	if err := c.current().unsubscribeWithContext(ctx, stream); err != nil {
 		return err
 	}
-	delete(c.subscriptions, stream)
+	c.forget([]string{stream})
 	return nil
 }
 
-// Publish publishes a message to the stream asynchronously.
+// UnsubscribeMany unsubscribes from the streams. A failure doesn't stop the other streams from
+// being unsubscribed, the failures are returned in a *MultiError that lists the failed streams.
+func (c *Connection) UnsubscribeMany(streams []string) error {
+	done, err := c.current().unsubscribeMany(streams)
+	c.forget(done)
+	return err
+}
+
+// UnsubscribeAll unsubscribes from all the streams, see UnsubscribeMany.
+func (c *Connection) UnsubscribeAll() error {
+	done, err := c.current().unsubscribeAll()
+	c.forget(done)
+	return err
+}
+
+// forget removes the streams from the subscriptions restored on reconnect and ends their
+// contexts, see SubscribeContext
+func (c *Connection) forget(streams []string) {
+	c.mu.Lock()
+	for _, stream := range streams {
+		if sub, ok := c.subscriptions[stream]; ok && sub.ctxCancel != nil {
+			sub.ctxCancel()
+		}
+		delete(c.subscriptions, stream)
+	}
+	c.mu.Unlock()
+	c.config.dedupWindows.remove(streams)
+}
+
+// WaitForConsumed blocks until at least n messages have been delivered to the callback of the
+// subscription of the stream or ctx is done. It's meant for tests that would otherwise sleep.
+func (c *Connection) WaitForConsumed(ctx context.Context, stream string, n int) error {
+	return c.current().WaitForConsumed(ctx, stream, n)
+}
+
+// Subscriptions returns the active subscriptions sorted by stream name
+func (c *Connection) Subscriptions() []SubscriptionInfo {
+	return c.current().Subscriptions()
+}
+
+// SubscriptionSnapshot returns the active subscriptions of the current connection indexed by
+// stream. It's safe to call concurrently with subscribing and unsubscribing, e.g. for monitoring.
+func (c *Connection) SubscriptionSnapshot() map[string]SubscriptionInfo {
+	return c.current().SubscriptionSnapshot()
+}
+
+// Publish publishes a message to the stream asynchronously. While the connection is being
+// re-established, the message is held until reconnected if Config.OutboxSize allows it.
 func (c *Connection) Publish(ctx context.Context, stream string, headers map[string]string, payload []byte) (*PublishResult, error) {
-	return c.conn.Publish(ctx, stream, headers, payload)
+	if r, held, err := c.publishHeld(ctx, stream, headers, payload); held {
+		return r, err
+	}
+	conn, release, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	r, err := conn.Publish(ctx, stream, headers, payload)
+	release()
+	if errors.Is(err, ErrNotConnected) {
+		// the connection was lost while publishing
+		if r, held, err := c.publishHeld(ctx, stream, headers, payload); held {
+			return r, err
+		}
+	}
+	return r, err
+}
+
+// PublishWithRetry publishes a message to the stream and retries if the publish fails with a
+// retryable error.
+func (c *Connection) PublishWithRetry(ctx context.Context, stream string, headers map[string]string, payload []byte, opts RetryOptions) (*PublishResult, error) {
+	conn, release, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return conn.PublishWithRetry(ctx, stream, headers, payload, opts)
+}
+
+// PublishNoAck publishes a message to the stream without tracking its response, for high volume
+// messages whose acks don't matter. Only local errors are returned, e.g. ErrWriterBusy when the
+// messages are published faster than they can be sent.
+func (c *Connection) PublishNoAck(stream string, headers map[string]string, payload []byte) error {
+	conn, release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return conn.PublishNoAck(stream, headers, payload)
 }
 
 // PublishAsync publishes a message to the stream asynchronously.
 // Response can be monitored on the supplied channel. The cancel function must be invoked before closing the channel.
 func (c *Connection) PublishAsync(stream string, headers map[string]string, payload []byte, result chan *PublishResult) (msgID string, cancel func(), err error) {
-	return c.conn.PublishAsync(stream, headers, payload, result)
+	conn, release, err := c.use()
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+	return conn.PublishAsync(stream, headers, payload, result)
+}
+
+// PublishAsyncFunc publishes a message to the stream asynchronously and invokes callback with the
+// response in a separate goroutine.
+func (c *Connection) PublishAsyncFunc(stream string, headers map[string]string, payload []byte, callback func(*PublishResult)) (msgID string, err error) {
+	conn, release, err := c.use()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return conn.PublishAsyncFunc(stream, headers, payload, callback)
+}
+
+// PublishChunked publishes the payload read from r to the stream as a sequence of messages of up
+// to chunkSize bytes, which SubscribeReader reassembles.
+func (c *Connection) PublishChunked(ctx context.Context, stream string, headers map[string]string, r io.Reader, chunkSize int) (string, error) {
+	conn, release, err := c.use()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return conn.PublishChunked(ctx, stream, headers, r, chunkSize)
+}
+
+// Echo publishes the payload to the stream and waits for it to be received back through a
+// temporary subscription. The received payload is returned.
+func (c *Connection) Echo(ctx context.Context, stream string, payload []byte) ([]byte, error) {
+	conn, release, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return conn.Echo(ctx, stream, payload)
+}
+
+// Quota returns the remaining quota and the time at which it resets, as last reported by the
+// server. ok is false if the server hasn't reported any quota yet.
+func (c *Connection) Quota() (remaining int, resetAt time.Time, ok bool) {
+	return c.current().Quota()
+}
+
+// RESTPoolStats returns the connection pool statistics of the REST client of the current
+// connection. The statistics start over when the connection is re-established.
+func (c *Connection) RESTPoolStats() RESTPoolStats {
+	return c.current().RESTPoolStats()
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server by the current
+// connection, 0 if not connected yet.
+func (c *Connection) ProtocolVersion() int {
+	return c.current().ProtocolVersion()
+}
+
+// Ping sends a WebSocket ping to the server over the current connection and waits for the pong.
+func (c *Connection) Ping(ctx context.Context) error {
+	conn, release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return conn.Ping(ctx)
+}
+
+// LastActivity returns the time of the most recent successful consume or publish of the current
+// connection, zero if none yet.
+func (c *Connection) LastActivity() time.Time {
+	return c.current().LastActivity()
+}
+
+// SubscribeLatencyPercentiles returns the percentiles of the durations of the most recent
+// subscription creations of the current connection.
+func (c *Connection) SubscribeLatencyPercentiles() LatencyPercentiles {
+	return c.current().SubscribeLatencyPercentiles()
+}
+
+// DeliveryLatencyPercentiles returns the percentiles of the time between the server accepting the
+// most recent messages of the stream and their delivery to the callback of its subscription on the
+// current connection. They're zero if the stream isn't subscribed or the server doesn't report the
+// time of the messages.
+func (c *Connection) DeliveryLatencyPercentiles(stream string) LatencyPercentiles {
+	return c.current().DeliveryLatencyPercentiles(stream)
+}
+
+// SetPublishRateLimit changes Config.PublishRateLimit and Config.PublishBurst at runtime, also for
+// the publishes already waiting. A limit of 0 disables the rate limiting.
+func (c *Connection) SetPublishRateLimit(limit rate.Limit, burst int) {
+	c.current().SetPublishRateLimit(limit, burst)
+}
+
+// ThrottleState returns the state of the throttling of the publish and consume requests of the
+// current connection by the server. It starts over when the connection is re-established.
+func (c *Connection) ThrottleState() ThrottleState {
+	return c.current().ThrottleState()
+}
+
+// InFlightPublishes returns the number of publishes of the current connection waiting for their
+// response from the server.
+func (c *Connection) InFlightPublishes() int {
+	return c.current().InFlightPublishes()
+}
+
+// Flush waits until all the publishes of the current connection sent so far received their
+// response, or until ctx is done. A *FlushError listing the unacked publishes is returned if ctx
+// is done or the connection is closed first. The publishes held in the outbox while reconnecting
+// aren't sent yet and aren't waited for.
+func (c *Connection) Flush(ctx context.Context) error {
+	return c.current().Flush(ctx)
 }
 
 // errorHandler waits for error and puts it in the error channel.
@@ -111,34 +665,114 @@ func (c *Connection) PublishAsync(stream string, headers map[string]string, payl
 func (c *Connection) errorHandler() {
 	var err error
 	defer func() {
+		// the connection is closed for good, which ends the contexts of the subscriptions
+		c.ctxCancel()
+		c.failOutbox()
 		// Always push the err, even if it is nil
 		c.Error <- err
 	}()
 	for {
+		conn := c.current()
 		select {
-		case err = <-c.conn.Error:
-			if !c.conn.consumeTimeout {
-				return
+		case err = <-conn.Error:
+			if conn != c.current() {
+				// the connection was replaced by MigrateGroup
+				continue
 			}
-			log.Logger.Warnf("Consume timeout. Reconnecting")
-			// Create new connection and subscribe with existing subscription ID
-			c.conn, err = newInternalConnection(c.config)
-			if err != nil {
-				return
+			if c.waitIdle() {
+				// the connection closed for being idle was reopened
+				continue
 			}
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer cancel()
-			if err = c.conn.connect(ctx); err != nil {
+			if !conn.consumeTimeout {
+				c.setState(StateDisconnected)
 				return
 			}
-			for _, sub := range c.subscriptions {
-				_, err = c.conn.subscribe(sub.stream, sub.subscriptionID, sub.handler)
-				if err != nil {
-					return
-				}
+			c.logger().Warnf("Consume timeout. Reconnecting")
+			if err = c.reconnect(); err != nil {
+				c.setState(StateFailed)
+				return
 			}
+			go c.flushOutbox()
 		case <-c.ctx.Done():
 			return
 		}
 	}
 }
+
+// reconnect re-establishes the connection according to the ReconnectPolicy
+func (c *Connection) reconnect() error {
+	c.setState(StateReconnecting)
+	policy := c.config.ReconnectPolicy
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(policy.Delay):
+			case <-c.ctx.Done():
+				return c.ctx.Err()
+			}
+		}
+		c.config.Metrics.IncReconnect()
+		if err = c.reconnectOnce(); err == nil {
+			// publishes keep being held until the held ones are sent, see flushOutbox
+			c.mu.Lock()
+			c.flushing = c.config.OutboxSize > 0
+			c.mu.Unlock()
+			c.setState(StateConnected)
+			return nil
+		}
+		c.logger().Errorf("Reconnect attempt %d of %d failed: %v", attempt, policy.MaxAttempts, err)
+	}
+	return fmt.Errorf("%w after %d attempts: %v", ErrReconnectExhausted, policy.MaxAttempts, err)
+}
+
+// reconnectOnce creates a new connection and subscribes with the existing subscription IDs. The
+// new connection replaces the current one only once all the streams are subscribed, it's discarded
+// otherwise.
+func (c *Connection) reconnectOnce() error {
+	c.mu.Lock()
+	config := c.config // GroupID is updated by MigrateGroup
+	subs := make([]subscriptionParams, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+	conn, err := newInternalConnection(config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, 15*time.Second)
+	defer cancel()
+	if err = conn.connect(ctx); err != nil {
+		conn.discard()
+		return err
+	}
+
+	for i, sub := range subs {
+		subscriptionID := sub.subscriptionID
+		if config.EphemeralGroup {
+			// subscriptions of an ephemeral group were deleted when the connection closed
+			subscriptionID = ""
+		}
+		if sub.ackHandler != nil {
+			subscriptionID, err = conn.subscribeWithAck(sub.stream, subscriptionID, sub.ackHandler, sub.opts...)
+		} else {
+			subscriptionID, err = conn.subscribe(sub.stream, subscriptionID, sub.handler, sub.opts...)
+		}
+		if err != nil {
+			conn.discard()
+			return err
+		}
+		subs[i].subscriptionID = subscriptionID
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = conn
+	for _, sub := range subs {
+		if _, ok := c.subscriptions[sub.stream]; ok {
+			c.subscriptions[sub.stream] = sub
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReconnectExhausted(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		RejectReconnect:   true,
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	u, _ := url.Parse(s.URL)
+
+	var mu sync.Mutex
+	authCalls := 0
+	var states []ConnectionState
+	metrics := &testMetrics{}
+	c, err := NewConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			mu.Lock()
+			authCalls++
+			mu.Unlock()
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 3,
+			Delay:       10 * time.Millisecond,
+		},
+		Metrics: metrics,
+		OnStateChange: func(state ConnectionState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+		},
+	})
+	require.NoError(t, err)
+
+	err = c.Connect(context.Background())
+	require.NoError(t, err)
+	err = c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	select {
+	case err = <-c.Error:
+		require.True(t, errors.Is(err, ErrReconnectExhausted), "unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Reconnect did not give up")
+	}
+	require.Equal(t, StateFailed, c.State())
+
+	mu.Lock()
+	defer mu.Unlock()
+	// connect, subscribe and 3 reconnect attempts
+	require.Equal(t, 5, authCalls)
+	require.Equal(t, []ConnectionState{StateConnected, StateReconnecting, StateFailed}, states)
+	require.Equal(t, 3, metrics.reconnects())
+}
+
+func Test_WaitForConnected(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	u, _ := url.Parse(s.URL)
+
+	// the reconnect is held until released
+	var holdMu sync.Mutex
+	var hold chan struct{}
+	release := make(chan struct{})
+	reconnecting := make(chan struct{}, 1)
+	c, err := NewConnection(Config{
+		GroupID: "test-client",
+		Domain:  u.Host,
+		APIKeyProvider: func() ([]byte, error) {
+			holdMu.Lock()
+			h := hold
+			holdMu.Unlock()
+			if h != nil {
+				<-h
+			}
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+		OnStateChange: func(state ConnectionState) {
+			if state == StateReconnecting {
+				select {
+				case reconnecting <- struct{}{}:
+				default:
+				}
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	require.ErrorIs(t, c.WaitForConnected(ctx), context.DeadlineExceeded)
+	cancel()
+
+	require.NoError(t, c.Connect(context.Background()))
+	defer c.Disconnect()
+	require.NoError(t, c.WaitForConnected(context.Background()))
+
+	require.NoError(t, c.Subscribe("wait-connected-stream", func(error, string, map[string]string, []byte) {}))
+	holdMu.Lock()
+	hold = release
+	holdMu.Unlock()
+	select {
+	case <-reconnecting:
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "Reconnect not started")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	require.ErrorIs(t, c.WaitForConnected(ctx), context.DeadlineExceeded)
+	cancel()
+
+	waited := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		waited <- c.WaitForConnected(ctx)
+	}()
+	select {
+	case err = <-waited:
+		require.FailNow(t, "WaitForConnected returned while reconnecting", "%v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	holdMu.Lock()
+	hold = nil
+	holdMu.Unlock()
+	close(release)
+	select {
+	case err = <-waited:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "WaitForConnected not unblocked by the reconnect")
+	}
+}
+
+func Test_EphemeralGroup(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	u, _ := url.Parse(s.URL)
+	config := Config{
+		GroupID:        "test-client",
+		Domain:         u.Host,
+		EphemeralGroup: true,
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		},
+	}
+	c1, err := NewConnection(config)
+	require.NoError(t, err)
+	c2, err := NewConnection(config)
+	require.NoError(t, err)
+	require.Contains(t, c1.config.GroupID, "test-client-")
+	require.NotEqual(t, c1.config.GroupID, c2.config.GroupID)
+	require.Equal(t, c1.config.GroupID, c1.current().config.GroupID)
+
+	err = c1.Connect(context.Background())
+	require.NoError(t, err)
+	defer c1.Disconnect()
+	err = c1.Subscribe("test-stream", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	c1.mu.Lock()
+	id := c1.subscriptions["test-stream"].subscriptionID
+	c1.mu.Unlock()
+	require.True(t, test.HasSubscription(id))
+
+	// consume timeout closes the connection, the subscription must not be left on the server
+	require.Eventually(t, func() bool { return !test.HasSubscription(id) }, 3*time.Second, 10*time.Millisecond)
+
+	// the subscription is re-created on reconnect
+	require.Eventually(t, func() bool {
+		c1.mu.Lock()
+		defer c1.mu.Unlock()
+		return c1.subscriptions["test-stream"].subscriptionID != id
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func Test_ConnectionID(t *testing.T) {
+	c1, err := NewConnection(Config{GroupID: "test-client", Domain: "localhost", APIKeyProvider: func() ([]byte, error) { return nil, nil }})
+	require.NoError(t, err)
+	c2, err := NewConnection(Config{GroupID: "test-client", Domain: "localhost", APIKeyProvider: func() ([]byte, error) { return nil, nil }})
+	require.NoError(t, err)
+	require.Len(t, c1.ID(), 8)
+	require.NotEqual(t, c1.ID(), c2.ID())
+}
+
+func Test_ReconnectResubscribeFailure(t *testing.T) {
+	var open int32
+	var mu sync.Mutex
+	creations := 0
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDrop:       true,
+		OpenConns:         &open,
+		// the subscription fails to be re-created by the first reconnect attempt
+		RejectRequest: func(r *http.Request) bool {
+			if r.Method != http.MethodPost || r.URL.Path != apiPaths.subscriptions {
+				return false
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			creations++
+			return creations == 2
+		},
+	})
+	defer s.Close()
+
+	timeout := consumeResponseTimeout
+	consumeResponseTimeout = 500 * time.Millisecond
+	defer func() { consumeResponseTimeout = timeout }()
+
+	reconnected := make(chan struct{}, 1)
+	reconnecting := false
+	c := newTestPublicConnection(t, s, Config{
+		EphemeralGroup: true,
+		ReconnectPolicy: ReconnectPolicy{
+			MaxAttempts: 3,
+			Delay:       10 * time.Millisecond,
+		},
+		OnStateChange: func(state ConnectionState) {
+			switch state {
+			case StateReconnecting:
+				reconnecting = true
+			case StateConnected:
+				if reconnecting {
+					reconnecting = false
+					select {
+					case reconnected <- struct{}{}:
+					default:
+					}
+				}
+			}
+		},
+	})
+	defer c.Disconnect()
+	old := c.current()
+	require.NoError(t, c.Subscribe("resubscribe-stream", func(error, string, map[string]string, []byte) {}))
+
+	select {
+	case <-reconnected:
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "Not reconnected")
+	}
+	mu.Lock()
+	require.GreaterOrEqual(t, creations, 3)
+	mu.Unlock()
+	// the connection of the failed attempt was closed, only the current one is left
+	require.NotEqual(t, old, c.current())
+	require.Contains(t, c.current().SubscriptionSnapshot(), "resubscribe-stream")
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&open) == 1 }, 400*time.Millisecond, 10*time.Millisecond)
+}
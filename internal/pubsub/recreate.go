@@ -0,0 +1,123 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// SubscriptionRecreatedHandler is invoked when a subscription that no longer exists on the server,
+// e.g. because it expired, is recreated. The subscription continues from the default position of
+// the new subscription ID.
+type SubscriptionRecreatedHandler func(stream, oldID, newID string)
+
+// subscriptionExists returns true if the subscription with the ID exists on the server
+func (c *internalConnection) subscriptionExists(id string) (bool, error) {
+	u := url.URL{
+		Scheme: c.config.Scheme,
+		Host:   c.config.Domain,
+		Path:   path.Join(apiPaths.subscriptions, id),
+	}
+	resp, err := c.restRequest("get subscription", func(r *resty.Request) (*resty.Response, error) {
+		return r.Get(u.String())
+	})
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		c.logger().Errorf("Received unexpected response '%s' while getting the subscription", resp.Status())
+		return false, fmt.Errorf("received unexpected response '%s' while getting the subscription", resp.Status())
+	}
+	return true, nil
+}
+
+// detach stops consuming from the stream without deleting the subscription on the server and waits
+// for the subscriber goroutine to return
+func (c *internalConnection) detach(stream string) error {
+	c.subs.Lock()
+	sub := c.subs.table[stream]
+	err := c.unsubscribeWithoutLock(c.ctx, stream, false)
+	c.subs.Unlock()
+	if err != nil {
+		return err
+	}
+	sub.wg.Wait()
+	return nil
+}
+
+// subscriptionChecker recreates the subscriptions that no longer exist on the server every
+// Config.SubscriptionCheckInterval
+func (c *Connection) subscriptionChecker() {
+	ticker := time.NewTicker(c.config.SubscriptionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.State() == StateConnected {
+				c.checkSubscriptions()
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkSubscriptions recreates the subscriptions that no longer exist on the server and invokes
+// Config.SubscriptionRecreatedHandler for each of them
+func (c *Connection) checkSubscriptions() {
+	conn := c.current()
+	c.mu.Lock()
+	subs := make([]subscriptionParams, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		if sub.subscriptionID != "" {
+			subs = append(subs, sub)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		exists, err := conn.subscriptionExists(sub.subscriptionID)
+		if err != nil {
+			c.logger().Warnf("Failed to check subscription %s for stream %s: %v", sub.subscriptionID, sub.stream, err)
+			continue
+		}
+		if exists {
+			continue
+		}
+		c.logger().Warnf("Subscription %s for stream %s no longer exists, recreating it", sub.subscriptionID, sub.stream)
+		if err = conn.detach(sub.stream); err != nil {
+			// unsubscribed in the meantime
+			continue
+		}
+		var id string
+		if sub.ackHandler != nil {
+			id, err = conn.subscribeWithAck(sub.stream, "", sub.ackHandler, sub.opts...)
+		} else {
+			id, err = conn.subscribe(sub.stream, "", sub.handler, sub.opts...)
+		}
+		if err != nil {
+			c.logger().Errorf("Failed to recreate subscription for stream %s: %v", sub.stream, err)
+			c.forget([]string{sub.stream})
+			continue
+		}
+		c.mu.Lock()
+		if current, ok := c.subscriptions[sub.stream]; ok {
+			current.subscriptionID = id
+			c.subscriptions[sub.stream] = current
+		}
+		c.mu.Unlock()
+		if c.config.SubscriptionRecreatedHandler != nil {
+			c.config.SubscriptionRecreatedHandler(sub.stream, sub.subscriptionID, id)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscriptionRecreated(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	type recreated struct {
+		stream, oldID, newID string
+	}
+	recreatedCh := make(chan recreated, 1)
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval:              10 * time.Millisecond,
+		SubscriptionCheckInterval: 20 * time.Millisecond,
+		SubscriptionRecreatedHandler: func(stream, oldID, newID string) {
+			recreatedCh <- recreated{stream, oldID, newID}
+		},
+	})
+	defer c.Disconnect()
+
+	received := make(chan string, 1)
+	err := c.Subscribe("test-stream", func(err error, _ string, _ map[string]string, payload []byte) {
+		if err == nil {
+			received <- string(payload)
+		}
+	})
+	require.NoError(t, err)
+	oldID := c.Subscriptions()[0].ID
+	require.True(t, test.HasSubscription(oldID))
+
+	test.ExpireSubscription(oldID)
+	var r recreated
+	select {
+	case r = <-recreatedCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not recreated")
+	}
+	require.Equal(t, "test-stream", r.stream)
+	require.Equal(t, oldID, r.oldID)
+	require.NotEqual(t, oldID, r.newID)
+	require.True(t, test.HasSubscription(r.newID))
+	require.Equal(t, r.newID, c.Subscriptions()[0].ID)
+
+	// the recreated subscription consumes
+	_, err = c.Publish(context.Background(), "test-stream", nil, []byte("after recreate"))
+	require.NoError(t, err)
+	select {
+	case payload := <-received:
+		require.Equal(t, "after recreate", payload)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+}
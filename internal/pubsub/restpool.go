@@ -0,0 +1,147 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// RESTPoolStats are the connection pool statistics of the REST client of a connection. The
+// websocket connection isn't included.
+type RESTPoolStats struct {
+	Dials  int64 // number of connections dialed
+	Open   int64 // number of connections currently open
+	Active int64 // number of open connections serving a request
+	Idle   int64 // number of open connections idle in the pool
+	Reused int64 // number of requests served by a reused connection
+}
+
+type websocketDialKey struct{}
+
+// restPool keeps track of the connections of the REST client
+type restPool struct {
+	dials  int64
+	open   int64
+	active int64
+	reused int64
+}
+
+// instrument wraps the dialer of the transport to count the connections
+func (p *restPool) instrument(t *http.Transport) {
+	dial := t.DialContext
+	if dial == nil {
+		if t.Dial != nil {
+			dial = func(_ context.Context, network, addr string) (net.Conn, error) {
+				return t.Dial(network, addr)
+			}
+		} else {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil || ctx.Value(websocketDialKey{}) != nil {
+			return conn, err
+		}
+		atomic.AddInt64(&p.dials, 1)
+		atomic.AddInt64(&p.open, 1)
+		return &countedConn{Conn: conn, open: &p.open}, nil
+	}
+}
+
+type restRequestKey struct{}
+
+// restRequest tracks whether a request holds a connection
+type restRequest struct {
+	gotConn  bool
+	released bool
+	sync.Mutex
+}
+
+// beforeRequest traces the request to count the active and reused connections
+func (p *restPool) beforeRequest(_ *resty.Client, r *resty.Request) error {
+	req := &restRequest{}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			req.Lock()
+			defer req.Unlock()
+			if info.Reused {
+				atomic.AddInt64(&p.reused, 1)
+			}
+			if !req.gotConn {
+				req.gotConn = true
+				atomic.AddInt64(&p.active, 1)
+			}
+		},
+	}
+	ctx := context.WithValue(r.Context(), restRequestKey{}, req)
+	r.SetContext(httptrace.WithClientTrace(ctx, trace))
+	return nil
+}
+
+// afterResponse releases the connection of the request
+func (p *restPool) afterResponse(_ *resty.Client, resp *resty.Response) error {
+	p.release(resp.Request)
+	return nil
+}
+
+// onError releases the connection of the failed request
+func (p *restPool) onError(r *resty.Request, _ error) {
+	p.release(r)
+}
+
+func (p *restPool) release(r *resty.Request) {
+	req, ok := r.Context().Value(restRequestKey{}).(*restRequest)
+	if !ok {
+		return
+	}
+	req.Lock()
+	defer req.Unlock()
+	if req.gotConn && !req.released {
+		req.released = true
+		atomic.AddInt64(&p.active, -1)
+	}
+}
+
+func (p *restPool) stats() RESTPoolStats {
+	s := RESTPoolStats{
+		Dials:  atomic.LoadInt64(&p.dials),
+		Open:   atomic.LoadInt64(&p.open),
+		Active: atomic.LoadInt64(&p.active),
+		Reused: atomic.LoadInt64(&p.reused),
+	}
+	if s.Active > s.Open {
+		s.Active = s.Open
+	}
+	s.Idle = s.Open - s.Active
+	return s
+}
+
+// countedConn decrements the number of open connections once closed
+type countedConn struct {
+	net.Conn
+	open      *int64
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(c.open, -1)
+	})
+	return c.Conn.Close()
+}
+
+// RESTPoolStats returns the connection pool statistics of the REST client. Connections dialed by
+// a Transport with a custom DialTLSContext aren't counted.
+func (c *internalConnection) RESTPoolStats() RESTPoolStats {
+	return c.restPool.stats()
+}
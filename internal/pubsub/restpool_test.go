@@ -0,0 +1,33 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RESTPoolStats(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	defer c.Disconnect()
+
+	// the websocket connection isn't counted
+	require.Equal(t, RESTPoolStats{}, c.RESTPoolStats())
+
+	for _, stream := range []string{"stream-1", "stream-2", "stream-3"} {
+		require.NoError(t, c.Subscribe(stream, func(error, string, map[string]string, []byte) {}))
+	}
+
+	stats := c.RESTPoolStats()
+	require.Equal(t, int64(1), stats.Dials)
+	require.Equal(t, int64(1), stats.Open)
+	require.Equal(t, int64(0), stats.Active)
+	require.Equal(t, int64(1), stats.Idle)
+	require.GreaterOrEqual(t, stats.Reused, int64(2))
+}
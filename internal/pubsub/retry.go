@@ -0,0 +1,202 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+)
+
+// HeaderIdempotencyKey is the header carrying the idempotency key of a message published with
+// PublishWithRetry. All the attempts to publish a message carry the same key so that duplicates
+// can be detected.
+const HeaderIdempotencyKey = "idempotency-key"
+
+var (
+	defaultRetryAttempts       = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+
+	defaultConsumeErrorThreshold = 3
+)
+
+// RetryOptions configures the retries performed by PublishWithRetry
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of publish attempts, including the first one.
+	// Default is 3.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. The delay doubles after each retry.
+	// Default is 100 milliseconds.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries.
+	// Default is 5 seconds.
+	MaxBackoff time.Duration
+
+	// IdempotencyKey is sent in the idempotency-key header of every attempt. If empty, a random key
+	// is generated.
+	IdempotencyKey string
+
+	// Retryable decides if a publish error should be retried.
+	// Default is IsRetryable.
+	Retryable func(err error) bool
+}
+
+// IsRetryable is the default predicate used by PublishWithRetry. It returns true for temporary
+// server errors and for requests that couldn't be sent because the writer was busy.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrWriterBusy) {
+		return true
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Temporary()
+	}
+	return false
+}
+
+// PublishWithRetry publishes a message to the stream and retries with exponential backoff if the
+// publish fails with a retryable error. A throttle error of the server that requests a retry delay
+// is retried after that delay instead, see RPCError.Throttled. Retries stop as soon as ctx is done
+// or a non-retryable error is returned. The result of the last attempt is returned.
+func (c *internalConnection) PublishWithRetry(ctx context.Context, stream string, headers map[string]string, payload []byte, opts RetryOptions) (*PublishResult, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultRetryAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	if opts.IdempotencyKey == "" {
+		opts.IdempotencyKey = uuid.NewString()
+	}
+
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[HeaderIdempotencyKey] = opts.IdempotencyKey
+
+	backoff := opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		r, err := c.Publish(ctx, stream, h, payload)
+		failure := err
+		if failure == nil {
+			failure = r.Error
+		}
+		if failure == nil || attempt >= opts.MaxAttempts || !opts.Retryable(failure) {
+			return r, err
+		}
+		delay := backoff
+		var rpcErr *RPCError
+		if errors.As(failure, &rpcErr) && rpcErr.Throttled() && rpcErr.RetryAfter > 0 {
+			// the server tells when to retry
+			delay = rpcErr.RetryAfter
+		}
+		c.logger().Warnf("Publish attempt %d of %d to stream %s failed, retrying in %v: %v",
+			attempt, opts.MaxAttempts, stream, delay, failure)
+		select {
+		case <-ctx.Done():
+			return r, err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+// restRequestWithRetry sends the REST request with restRequest and retries up to
+// Config.SubscriptionSetupRetries times with exponential backoff if the request can't be sent or
+// the server responds with a 5xx status. The response of the last attempt is returned. Retries
+// stop as soon as ctx is done or the next attempt would start after the deadline of ctx.
+func (c *internalConnection) restRequestWithRetry(ctx context.Context, op string, send func(r *resty.Request) (*resty.Response, error)) (*resty.Response, error) {
+	backoff := defaultRetryInitialBackoff
+	for attempt := 0; ; attempt++ {
+		var sendErr error
+		resp, err := c.restRequest(op, func(r *resty.Request) (*resty.Response, error) {
+			resp, err := send(r.SetContext(ctx))
+			sendErr = err
+			return resp, err
+		})
+		// errors that aren't caused by sending the request, e.g. auth failures, aren't retried
+		retryable := sendErr != nil || (err == nil && resp.StatusCode() >= http.StatusInternalServerError)
+		if !retryable || attempt >= c.config.SubscriptionSetupRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return resp, err
+		}
+		failure := err
+		if failure == nil {
+			failure = errors.New(resp.Status())
+		}
+		c.logger().Warnf("Failed to %s, retrying in %v (%d of %d): %v",
+			op, backoff, attempt+1, c.config.SubscriptionSetupRetries, failure)
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > defaultRetryMaxBackoff {
+			backoff = defaultRetryMaxBackoff
+		}
+	}
+}
+
+// consumeRetry tracks the consecutive retryable consume errors of a subscription, see
+// Config.ConsumeErrorThreshold
+type consumeRetry struct {
+	threshold  int
+	maxBackoff time.Duration
+	failures   int           // consecutive retryable errors
+	backoff    time.Duration // delay before the next consume request, 0 without errors
+}
+
+func newConsumeRetry(config Config) *consumeRetry {
+	return &consumeRetry{threshold: config.ConsumeErrorThreshold, maxBackoff: config.MaxConsumeBackoff}
+}
+
+// failed records a consume error and returns true if it must be delivered to the callback. delay
+// is the current poll interval, the first backoff after a success.
+func (r *consumeRetry) failed(err error, delay time.Duration) bool {
+	if !IsRetryable(err) {
+		r.reset()
+		return true
+	}
+	r.failures++
+	if r.backoff == 0 {
+		r.backoff = delay
+	} else {
+		r.backoff *= 2
+	}
+	if r.backoff > r.maxBackoff {
+		r.backoff = r.maxBackoff
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcErr.RetryAfter > r.backoff {
+		// the server asked to wait longer
+		r.backoff = rpcErr.RetryAfter
+	}
+	return r.failures%r.threshold == 0
+}
+
+// reset forgets the errors after a successful consume request
+func (r *consumeRetry) reset() {
+	r.failures = 0
+	r.backoff = 0
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"errors"
+)
+
+// rewindRequest asks the subscriber goroutine to consume from an earlier consume context
+type rewindRequest struct {
+	consumeCtx string
+	done       chan struct{} // closed once the consume context is reset
+}
+
+// rewind resets the consume context of the subscription of the stream so that the next consume
+// request returns the messages following it. The running subscriber goroutine resets it between
+// two consume requests, a lazy subscription starts from it once activated.
+func (c *internalConnection) rewind(stream string, consumeCtx string) error {
+	if consumeCtx == "" {
+		return &SubscriptionError{Stream: stream, Err: errors.New("empty consume context")}
+	}
+	c.subs.Lock()
+	sub, ok := c.subs.table[stream]
+	if ok && sub.id == "" {
+		// lazy subscription that isn't consuming yet
+		sub.stats.Lock()
+		sub.stats.consumeCtx = consumeCtx
+		sub.stats.Unlock()
+		c.subs.Unlock()
+		return nil
+	}
+	c.subs.Unlock()
+	if !ok {
+		return &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	}
+
+	req := rewindRequest{consumeCtx: consumeCtx, done: make(chan struct{})}
+	select {
+	case sub.rewind <- req:
+	case <-sub.ctx.Done():
+		return &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	case <-sub.drain:
+		return &SubscriptionError{Stream: stream, Err: ErrNotConnected}
+	case <-c.closed:
+		return &SubscriptionError{Stream: stream, Err: ErrNotConnected}
+	}
+	<-req.done
+	return nil
+}
+
+// applyRewind resets the consume context of the subscriber goroutine and returns it
+func (sub *subscription) applyRewind(req rewindRequest) string {
+	defer close(req.done)
+	sub.logger.Infof("Rewinding stream %s to consume context %s", sub.stream, req.consumeCtx)
+	sub.stats.Lock()
+	sub.stats.consumeCtx = req.consumeCtx
+	sub.stats.Unlock()
+	// the messages consumed again aren't duplicates, see SubscribeOptions.Ordered
+	sub.nextOffset = 0
+	return req.consumeCtx
+}
+
+// Rewind resets the consume context of the subscription to one obtained earlier from
+// ConsumeContext, so that the messages following it are consumed and delivered again, e.g. to
+// reprocess recent history. The consume context is reset between two consume requests, Rewind
+// waits for the consume request in progress if any. How far back the subscription can be rewound
+// is bounded by the retention of the stream on the server: the messages the server no longer
+// retains can't be consumed again, whatever the consume context. A *SubscriptionError is returned
+// on failure.
+func (s *Subscription) Rewind(toContext string) error {
+	conn, release, err := s.conn.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return conn.rewind(s.stream, toContext)
+}
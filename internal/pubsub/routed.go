@@ -0,0 +1,47 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"errors"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/log"
+)
+
+// DefaultRoute is the key of the handler of SubscribeRouted that receives the messages the router
+// has no handler for
+const DefaultRoute = ""
+
+// SubscribeRouted subscribes to a DxHub Pubsub Stream and dispatches each message to the handler
+// of handlers keyed by the result of router for the headers of the message. The messages without
+// a matching handler go to the handler keyed by DefaultRoute, they're dropped if there's none.
+// The router is also invoked for errors that aren't associated with a message, with nil headers.
+// A *SubscriptionError is returned on failure.
+func (c *Connection) SubscribeRouted(stream string, router func(headers map[string]string) string, handlers map[string]SubscriptionCallback, opts ...SubscribeOption) error {
+	if router == nil || len(handlers) == 0 {
+		return &SubscriptionError{Stream: stream, Err: errors.New("router and handlers are required")}
+	}
+	return c.Subscribe(stream, routeCallback(c.logger(), stream, router, handlers), opts...)
+}
+
+// routeCallback returns the SubscriptionCallback dispatching the messages for SubscribeRouted.
+// The handlers are copied so that later changes to the map don't affect the subscription.
+func routeCallback(logger log.SDKLogger, stream string, router func(headers map[string]string) string, handlers map[string]SubscriptionCallback) SubscriptionCallback {
+	routes := make(map[string]SubscriptionCallback, len(handlers))
+	for route, handler := range handlers {
+		routes[route] = handler
+	}
+	return func(err error, id string, headers map[string]string, payload []byte) {
+		route := router(headers)
+		handler, ok := routes[route]
+		if !ok {
+			handler, ok = routes[DefaultRoute]
+		}
+		if !ok {
+			logger.Warnf("Dropping message %s of stream %s: no handler for route %q", id, stream, route)
+			return
+		}
+		handler(err, id, headers, payload)
+	}
+}
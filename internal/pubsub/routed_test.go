@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SubscribeRouted(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	received := map[string][]string{}
+	mu := sync.Mutex{}
+	handler := func(route string) SubscriptionCallback {
+		return func(err error, _ string, _ map[string]string, payload []byte) {
+			require.NoError(t, err)
+			mu.Lock()
+			received[route] = append(received[route], string(payload))
+			mu.Unlock()
+		}
+	}
+	router := func(headers map[string]string) string { return headers["type"] }
+	err := c.SubscribeRouted("test-stream", router, map[string]SubscriptionCallback{
+		"created":    handler("created"),
+		"deleted":    handler("deleted"),
+		DefaultRoute: handler("default"),
+	})
+	require.NoError(t, err)
+
+	for _, msg := range []struct{ typ, payload string }{
+		{"created", "1"}, {"deleted", "2"}, {"updated", "3"}, {"created", "4"}, {"", "5"},
+	} {
+		var headers map[string]string
+		if msg.typ != "" {
+			headers = map[string]string{"type": msg.typ}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(ctx, "test-stream", headers, []byte(msg.payload))
+		cancel()
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitForConsumed(ctx, "test-stream", 5))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string][]string{
+		"created": {"1", "4"},
+		"deleted": {"2"},
+		"default": {"3", "5"},
+	}, received)
+}
+
+func Test_RouteCallbackWithoutDefault(t *testing.T) {
+	logger := &testLogger{}
+	called := 0
+	callback := routeCallback(logger, "test-stream", func(headers map[string]string) string {
+		return headers["type"]
+	}, map[string]SubscriptionCallback{
+		"created": func(error, string, map[string]string, []byte) { called++ },
+	})
+
+	callback(nil, "msg-1", map[string]string{"type": "created"}, nil)
+	callback(nil, "msg-2", map[string]string{"type": "deleted"}, nil)
+	callback(errors.New("consume failure"), "", nil, nil)
+	require.Equal(t, 1, called)
+	require.True(t, logger.contains(`no handler for route "deleted"`))
+}
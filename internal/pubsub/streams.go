@@ -0,0 +1,73 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/go-resty/resty/v2"
+)
+
+type streamResp struct {
+	Name string `json:"name"`
+
+	// offset of the last message published to the stream, -1 if it's empty. Only reported when
+	// getting a single stream.
+	LatestOffset *int64 `json:"latestOffset,omitempty"`
+}
+
+// listStreams returns the names of the streams known to the server
+func (c *internalConnection) listStreams() ([]string, error) {
+	u := url.URL{
+		Scheme: c.config.Scheme,
+		Host:   c.config.Domain,
+		Path:   apiPaths.streams,
+	}
+	var streamsResp []streamResp
+	resp, err := c.restRequest("list streams", func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&streamsResp).Get(u.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		c.logger().Errorf("Received unexpected response '%s' while listing the streams", resp.Status())
+		return nil, fmt.Errorf("received unexpected response '%s' while listing the streams", resp.Status())
+	}
+
+	names := make([]string, 0, len(streamsResp))
+	for _, s := range streamsResp {
+		names = append(names, s.Name)
+	}
+	return names, nil
+}
+
+// latestOffset returns the offset of the last message published to the stream, -1 if it's empty
+func (c *internalConnection) latestOffset(stream string) (int64, error) {
+	u := url.URL{
+		Scheme: c.config.Scheme,
+		Host:   c.config.Domain,
+		Path:   path.Join(apiPaths.streams, stream),
+	}
+	var streamResp streamResp
+	resp, err := c.restRequest("get stream", func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&streamResp).Get(u.String())
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		c.logger().Errorf("Received unexpected response '%s' while getting the stream %s", resp.Status(), stream)
+		return 0, fmt.Errorf("received unexpected response '%s' while getting the stream %s", resp.Status(), stream)
+	}
+	if streamResp.LatestOffset == nil {
+		return 0, errors.New("the server didn't report the latest offset of the stream")
+	}
+	return *streamResp.LatestOffset, nil
+}
@@ -5,15 +5,19 @@ package pubsub
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/url"
 	"path"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
 	"github.com/cisco-pxgrid/cloud-sdk-go/log"
+	"github.com/go-resty/resty/v2"
 )
 
 // SubscriptionCallback is the callback that's invoked when a message/error is received for the
@@ -28,83 +32,352 @@ type SubscriptionCallback func(err error, id string, headers map[string]string,
 var consumeResponseTimeout = 15 * time.Second
 
 // subscribe subscribes to a DxHub Pubsub Stream
-func (c *internalConnection) subscribe(stream string, subscriptionID string, handler SubscriptionCallback) (string, error) {
+func (c *internalConnection) subscribe(stream string, subscriptionID string, handler SubscriptionCallback, opts ...SubscribeOption) (string, error) {
+	return c.subscribeWithContext(c.ctx, stream, subscriptionID, handler, opts...)
+}
+
+// subscribeWithContext subscribes to a DxHub Pubsub Stream, the creation of the subscription on the
+// server stops when ctx is done
+func (c *internalConnection) subscribeWithContext(ctx context.Context, stream string, subscriptionID string, handler SubscriptionCallback, opts ...SubscribeOption) (string, error) {
+	return c.addSubscription(ctx, stream, subscriptionID, &subscription{callback: handler, opts: newSubscribeOptions(opts)})
+}
+
+// subscribeOrGet subscribes to a DxHub Pubsub Stream unless it's already subscribed, in which case
+// the ID of the existing subscription is returned and handler is ignored. created is true if the
+// subscription was created by this call.
+func (c *internalConnection) subscribeOrGet(stream string, handler SubscriptionCallback, opts ...SubscribeOption) (id string, created bool, err error) {
+	for {
+		id, err = c.subscribe(stream, "", handler, opts...)
+		if !errors.Is(err, ErrSubscriptionExists) {
+			return id, err == nil, err
+		}
+		c.subs.Lock()
+		sub, ok := c.subs.table[stream]
+		if ok {
+			id = sub.id
+		}
+		created := c.subs.pending[stream]
+		c.subs.Unlock()
+		if ok {
+			return id, false, nil
+		}
+		if created != nil {
+			// being created concurrently, get it once created
+			<-created
+		}
+		// unsubscribed concurrently or failed to be created, subscribe again
+	}
+}
+
+// subscribeFrom subscribes to a DxHub Pubsub Stream and starts consuming from the consume context
+func (c *internalConnection) subscribeFrom(stream string, consumeCtx string, handler SubscriptionCallback, opts ...SubscribeOption) (string, error) {
+	sub := &subscription{callback: handler, opts: newSubscribeOptions(opts)}
+	sub.stats.consumeCtx = consumeCtx
+	return c.addSubscription(c.ctx, stream, "", sub)
+}
+
+// subscribeWithAck subscribes to a DxHub Pubsub Stream, the messages are delivered to handler and
+// can be acked or nacked
+func (c *internalConnection) subscribeWithAck(stream string, subscriptionID string, handler AckSubscriptionCallback, opts ...SubscribeOption) (string, error) {
+	return c.addSubscription(c.ctx, stream, subscriptionID, &subscription{ackCallback: handler, opts: newSubscribeOptions(opts)})
+}
+
+// addSubscription creates the subscription if subscriptionID is empty and starts the subscriber
+// goroutine for sub, which must have its callback set. A lazy subscription without subscriptionID
+// is only registered until it's activated. The creation on the server stops when ctx is done.
+//
+// The stream is reserved while the subscription is created on the server, without holding the subs
+// lock, so concurrent calls for the same stream never create more than one subscription: all but
+// one fail with ErrSubscriptionExists.
+func (c *internalConnection) addSubscription(reqCtx context.Context, stream string, subscriptionID string, sub *subscription) (string, error) {
+	if err := c.config.Mode.checkSubscribe(); err != nil {
+		return "", &SubscriptionError{Stream: stream, Err: err}
+	}
+
 	c.subs.Lock()
 	defer c.subs.Unlock()
 
-	var sub *subscription
-	if _, ok := c.subs.table[stream]; ok {
-		return "", fmt.Errorf("subscription for stream %s already exists", stream)
+	if _, ok := c.subs.table[stream]; ok || c.subs.pending[stream] != nil {
+		return "", &SubscriptionError{Stream: stream, Err: ErrSubscriptionExists}
+	}
+	if sub.opts.InitialCredits > 0 && (sub.ackCallback != nil || c.config.AckMode != AckModeAuto) {
+		// the messages are delivered asynchronously, which the ack handling doesn't support
+		return "", &SubscriptionError{Stream: stream, Err: ErrCreditsUnsupported}
+	}
+	if sub.opts.Concurrency > 1 && sub.opts.InitialCredits > 0 {
+		return "", &SubscriptionError{Stream: stream, Err: ErrConcurrencyUnsupported}
+	}
+	if sub.opts.BatchSize < 0 {
+		return "", &SubscriptionError{Stream: stream, Err: errors.New("batch size must not be negative")}
+	}
+	if sub.opts.Dedup < 0 {
+		return "", &SubscriptionError{Stream: stream, Err: errors.New("dedup window size must not be negative")}
+	}
+	if sub.opts.MaxAge < 0 {
+		return "", &SubscriptionError{Stream: stream, Err: errors.New("max age must not be negative")}
+	}
+	switch sub.opts.OffsetReset {
+	case OffsetResetDefault, OffsetResetEarliest, OffsetResetLatest:
+	default:
+		return "", &SubscriptionError{Stream: stream, Err: fmt.Errorf("unknown offset reset %q", sub.opts.OffsetReset)}
+	}
+	if sub.opts.groupIDSet && sub.opts.GroupID == "" {
+		return "", &SubscriptionError{Stream: stream, Err: errors.New("group ID must not be empty")}
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub.stream = stream
+	sub.ctx = ctx
+	sub.ctxCancel = cancel
+	sub.createdAt = time.Now()
+	sub.drain = make(chan struct{})
+	sub.rewind = make(chan rewindRequest)
+	sub.nackDelay = c.config.NackRedeliveryDelay
+	sub.maxRedeliveries = c.config.MaxRedeliveries
+	sub.ackMode = c.config.AckMode
+	sub.ackTimeout = c.config.AckTimeout
+	sub.breaker = newCircuitBreaker(c.config)
+	sub.batchSize = c.config.ConsumeBatchSize
+	if sub.opts.BatchSize > 0 {
+		sub.batchSize = sub.opts.BatchSize
+	}
+	if c.config.DebugDetectBlockingHandlers {
+		sub.blockThreshold = c.config.BlockingHandlerThreshold
+	}
+	sub.propagator = c.config.Propagator
+	sub.panicHandler = c.config.PanicHandler
+	sub.encoding = c.config.PayloadEncoding
+	sub.encodingFallback = c.config.PayloadEncodingFallback
+	if sub.opts.Dedup > 0 {
+		sub.dedup = c.config.dedupWindows.get(stream, sub.opts.Dedup)
+	}
+	sub.groupID = sub.opts.groupID(c.config.GroupID)
+	sub.stats.lastOffset = -1
+	sub.logger = c.logger()
+
+	if subscriptionID == "" && sub.opts.Lazy {
+		// the subscription is created once activated
+		c.subs.table[stream] = sub
+		c.logger().Infof("Registered lazy subscription for stream %s", stream)
+		return "", nil
 	}
 
 	var id string
 	if subscriptionID != "" {
 		id = subscriptionID
-		log.Logger.Infof("Reuse subscription ID=%s", id)
+		c.logger().Infof("Reuse subscription ID=%s", id)
 	} else {
+		// the creation can take a while with its retries, the other streams aren't held up
+		created := make(chan struct{})
+		c.subs.pending[stream] = created
+		c.subs.Unlock()
 		var err error
-		id, err = c.createSubscription(stream)
+		id, err = c.createSubscription(reqCtx, stream, sub.opts)
+		c.subs.Lock()
+		delete(c.subs.pending, stream)
+		close(created)
 		if err != nil {
-			return "", fmt.Errorf("failed to create subscription for %s: %v", stream, err)
+			cancel()
+			return "", &SubscriptionError{Stream: stream, Err: err}
+		}
+		c.logger().Infof("Created subscription ID=%s", id)
+		if c.isClosed() {
+			// closeNotify ran during the creation, the subscription would never be deleted
+			cancel()
+			c.subs.Unlock()
+			c.deleteUnused(stream, id)
+			c.subs.Lock()
+			return "", &SubscriptionError{Stream: stream, Err: ErrNotConnected}
+		}
+		if _, ok := c.subs.table[stream]; ok {
+			// never happens while the stream is reserved, the subscription isn't replaced anyway
+			cancel()
+			return "", &SubscriptionError{Stream: stream, Err: ErrSubscriptionExists}
 		}
-		log.Logger.Infof("Created subscription ID=%s", id)
 	}
+	c.subs.table[stream] = sub
+	c.startSubscriber(sub, id)
+	return id, nil
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	sub = &subscription{
-		id:        id,
-		stream:    stream,
-		callback:  handler,
-		ctx:       ctx,
-		ctxCancel: cancel,
+// activate creates the lazy subscription of the stream and starts consuming. The subscription ID
+// is returned, also if the subscription was already active. Like addSubscription, the stream is
+// reserved while the subscription is created on the server, concurrent activations wait for it.
+func (c *internalConnection) activate(stream string) (string, error) {
+	c.subs.Lock()
+	defer c.subs.Unlock()
+
+	sub, ok := c.subs.table[stream]
+	for ok && sub.id == "" && c.subs.pending[stream] != nil {
+		// being activated concurrently, get it once created
+		created := c.subs.pending[stream]
+		c.subs.Unlock()
+		<-created
+		c.subs.Lock()
+		sub, ok = c.subs.table[stream]
+	}
+	if !ok {
+		return "", &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	}
+	if sub.id != "" {
+		return sub.id, nil
+	}
+
+	created := make(chan struct{})
+	c.subs.pending[stream] = created
+	c.subs.Unlock()
+	id, err := c.createSubscription(c.ctx, stream, sub.opts)
+	c.subs.Lock()
+	delete(c.subs.pending, stream)
+	close(created)
+	if err != nil {
+		return "", &SubscriptionError{Stream: stream, Err: err}
+	}
+	c.logger().Infof("Created subscription ID=%s", id)
+	if c.isClosed() || c.subs.table[stream] != sub {
+		// closed or unsubscribed during the creation, the subscription would never be deleted
+		err := ErrSubscriptionNotFound
+		if c.isClosed() {
+			err = ErrNotConnected
+		}
+		c.subs.Unlock()
+		c.deleteUnused(stream, id)
+		c.subs.Lock()
+		return "", &SubscriptionError{Stream: stream, Err: err}
+	}
+	c.startSubscriber(sub, id)
+	return id, nil
+}
+
+// startSubscriber starts the subscriber goroutine of sub with the subscription ID. The subs lock
+// must be held.
+func (c *internalConnection) startSubscriber(sub *subscription, id string) {
+	sub.id = id
+	prefix := "[sub " + id + "] "
+	if sub.groupID != c.config.GroupID {
+		// the consume requests of the subscription are served in its own group
+		prefix = "[sub " + id + " group " + sub.groupID + "] "
+	}
+	sub.logger = log.WithPrefix(c.logger(), prefix)
+
+	if sub.opts.InitialCredits > 0 {
+		sub.credits = newCreditWindow(sub.opts.InitialCredits, c.config.CreditReplenishThreshold)
+		sub.buffer = make(chan *Message, sub.opts.InitialCredits)
+		c.wg.Add(1)
+		sub.wg.Add(1)
+		go c.deliverer(sub)
+	}
+	if sub.opts.Concurrency > 1 {
+		c.startWorkers(sub)
 	}
-	c.subs.table[stream] = sub
 
 	c.wg.Add(1)
 	sub.wg.Add(1)
 	go c.subscriber(sub)
-
-	return id, nil
 }
 
+// unsubscribe unsubscribes from a DxHub Pubsub Stream, the deletion of the subscription on the
+// server fails if it doesn't complete within defaultTimeout
 func (c *internalConnection) unsubscribe(stream string) error {
-	log.Logger.Debugf("Unsubscribing from DxHub Pubsub Stream %s", stream)
+	ctx, cancel := context.WithTimeout(c.ctx, defaultTimeout)
+	defer cancel()
+	return c.unsubscribeWithContext(ctx, stream)
+}
+
+// unsubscribeWithContext unsubscribes from a DxHub Pubsub Stream, the deletion of the subscription
+// on the server stops when ctx is done
+func (c *internalConnection) unsubscribeWithContext(ctx context.Context, stream string) error {
+	c.logger().Debugf("Unsubscribing from DxHub Pubsub Stream %s", stream)
 	c.subs.Lock()
-	defer c.subs.Unlock()
+	sub := c.subs.table[stream]
+	err := c.unsubscribeWithoutLock(ctx, stream, true)
+	c.subs.Unlock()
+	if err != nil {
+		return err
+	}
+	// wait outside of the lock so that a blocked callback can't block the other subscriptions
+	sub.wg.Wait()
+	return nil
+}
 
-	return c.unsubscribeWithoutLock(stream, true)
+// unsubscribeMany unsubscribes from the streams. A failure doesn't stop the other streams from
+// being unsubscribed, the failures are returned in a *MultiError along with the streams that were
+// unsubscribed. The deletions on the server must complete within defaultTimeout.
+func (c *internalConnection) unsubscribeMany(streams []string) ([]string, error) {
+	c.logger().Debugf("Unsubscribing from %d DxHub Pubsub Streams", len(streams))
+	c.subs.Lock()
+	return c.unsubscribeManyWithLock(streams)
 }
 
-// unsubscribeWithoutLock unsubscribes from a DxHub Pubsub Stream
-func (c *internalConnection) unsubscribeWithoutLock(stream string, deleteSub bool) error {
+// unsubscribeAll unsubscribes from all the streams, see unsubscribeMany
+func (c *internalConnection) unsubscribeAll() ([]string, error) {
+	c.subs.Lock()
+	streams := make([]string, 0, len(c.subs.table))
+	for stream := range c.subs.table {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	c.logger().Debugf("Unsubscribing from all %d DxHub Pubsub Streams", len(streams))
+	return c.unsubscribeManyWithLock(streams)
+}
+
+// unsubscribeManyWithLock unsubscribes from the streams, it releases the subs lock held by the
+// caller before waiting for the subscribers to stop.
+func (c *internalConnection) unsubscribeManyWithLock(streams []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, defaultTimeout)
+	defer cancel()
+	var done []string
+	var stopped []*subscription
+	var errs []error
+	for _, stream := range streams {
+		sub := c.subs.table[stream]
+		if err := c.unsubscribeWithoutLock(ctx, stream, true); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		done = append(done, stream)
+		stopped = append(stopped, sub)
+	}
+	c.subs.Unlock()
+
+	// wait outside of the lock so that a blocked callback can't block the other subscriptions
+	for _, sub := range stopped {
+		sub.wg.Wait()
+	}
+	if len(errs) > 0 {
+		return done, &MultiError{Errors: errs}
+	}
+	return done, nil
+}
+
+// unsubscribeWithoutLock unsubscribes from a DxHub Pubsub Stream. The subscriber goroutine is
+// cancelled but not waited for, callers must wait on the subscription's wg after releasing the lock.
+// The deletion of the subscription on the server stops when ctx is done.
+func (c *internalConnection) unsubscribeWithoutLock(ctx context.Context, stream string, deleteSub bool) error {
 	sub, ok := c.subs.table[stream]
 	if !ok {
-		return fmt.Errorf("subscription for stream %s doesn't exist", stream)
+		return &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
 	}
-	if deleteSub {
-		err := c.deleteSubscription(sub.id)
+	if deleteSub && sub.id != "" {
+		err := c.deleteSubscription(ctx, sub.id)
 		if err != nil {
-			return fmt.Errorf("failed to unsubscribe from stream %s: %v", stream, err)
+			return &SubscriptionError{Stream: stream, ID: sub.id, Err: err}
 		}
 	}
 
 	delete(c.subs.table, stream)
 	sub.ctxCancel()
-	sub.wg.Wait()
-	log.Logger.Debugf("Successfully unsubscribed from stream %s", stream)
+	c.logger().Debugf("Successfully unsubscribed from stream %s", stream)
 	return nil
 }
 
-func (c *internalConnection) sendConsumeMessage(subscriptionId, consumeCtx string) (<-chan *rpc.Response, error) {
-	req, err := rpc.NewConsumeRequest(subscriptionId, consumeCtx)
+func (c *internalConnection) sendConsumeMessage(subscriptionId, consumeCtx string, limit int) (<-chan *rpc.Response, error) {
+	req, err := rpc.NewConsumeRequestWithCredits(subscriptionId, consumeCtx, limit)
 	if err != nil {
 		return nil, err
 	}
-	respCh := make(chan *rpc.Response, 1) // we expect 1 response back
-	err = c.sendMessage(req, func(resp *rpc.Response) {
-		respCh <- resp
-		close(respCh)
-	})
+	respCh, handler := c.singleResponse("consume")
+	err = c.sendMessage(req, handler)
 	if err != nil {
 		return nil, err
 	}
@@ -112,57 +385,395 @@ func (c *internalConnection) sendConsumeMessage(subscriptionId, consumeCtx strin
 }
 
 type subscription struct {
-	stream    string
-	id        string
-	callback  SubscriptionCallback
-	ctx       context.Context
-	ctxCancel context.CancelFunc
-	wg        sync.WaitGroup
+	stream          string
+	id              string
+	callback        SubscriptionCallback
+	ackCallback     AckSubscriptionCallback // set instead of callback for subscriptions with ack
+	opts            SubscribeOptions
+	ctx             context.Context
+	ctxCancel       context.CancelFunc
+	wg              sync.WaitGroup
+	createdAt       time.Time
+	drain           chan struct{} // closed to stop issuing consume requests
+	drainOnce       sync.Once
+	nackDelay       time.Duration
+	maxRedeliveries int
+	ackMode         AckMode
+	ackTimeout      time.Duration
+	batchSize       int             // maximum number of messages per consume response, 0 for the server default
+	breaker         *circuitBreaker // suspends the consume requests after consecutive errors
+	batch           batch           // acks of the consumed batch with AckModeManual
+	blockThreshold  time.Duration   // callback duration after which a warning is logged, 0 disables detection
+	propagator      Propagator
+	logger          log.SDKLogger
+	stats           struct { // updated by the subscriber goroutine
+		lastConsumeAt time.Time
+		messageCount  int64
+		consumeCtx    string // consume context of the next consume request
+		staleCount    int64  // messages skipped with SubscribeOptions.MaxAge
+		lastOffset    int64  // highest offset consumed, -1 if none is known
+		sync.Mutex
+	}
+	redeliveries struct { // nacked messages waiting to be redelivered
+		queue []redelivery
+		sync.Mutex
+	}
+
+	// time between the server accepting the messages and their delivery to the callback
+	deliveryLatency latencyRecorder
+
+	// flow control with SubscribeOptions.InitialCredits, nil otherwise
+	credits *creditWindow
+	buffer  chan *Message // messages waiting for the deliverer goroutine
+
+	// offset following the last message delivered with SubscribeOptions.Ordered
+	nextOffset int64
+
+	// delivery goroutines with SubscribeOptions.Concurrency, nil otherwise
+	workers []chan *Message
+
+	// consume context resets for the subscriber goroutine, see Subscription.Rewind
+	rewind chan rewindRequest
+
+	// consumer group of the subscription, see SubscribeOptions.GroupID
+	groupID string
+
+	panicHandler func(err *PanicError) // see Config.PanicHandler
+
+	encoding         PayloadEncoding // see Config.PayloadEncoding
+	encodingFallback bool            // see Config.PayloadEncodingFallback
+
+	// IDs of the recently consumed messages with SubscribeOptions.Dedup, nil otherwise
+	dedup *dedupWindow
+
+	noTimestampOnce sync.Once // see stale
+}
+
+// notifyError invokes the callback with an error that isn't associated with a message
+func (sub *subscription) notifyError(err error, id string) {
+	if sub.ackCallback != nil {
+		sub.call("", func() { sub.ackCallback(err, nil) })
+		return
+	}
+	sub.call("", func() { sub.callback(err, id, nil, nil) })
+}
+
+// call invokes fn, which invokes the callback for the message with the ID, and recovers from a
+// panic of the callback. It returns false if the callback panicked.
+func (sub *subscription) call(id string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := &PanicError{Stream: sub.stream, MessageID: id, Value: r, Stack: debug.Stack()}
+			sub.logger.Errorf("%v\n%s", err, err.Stack)
+			if sub.panicHandler != nil {
+				sub.panicHandler(err)
+			}
+		}
+	}()
+	fn()
+	return true
+}
+
+// deliver invokes the callback with the message. With ack, the message is acked if the callback
+// didn't settle it, unless the subscription uses AckModeManual.
+func (sub *subscription) deliver(err error, m *Message) {
+	sub.logger.Debugf("Delivering message %s of stream %s", m.ID, sub.stream)
+	if !m.timestamp.IsZero() {
+		d := time.Since(m.timestamp)
+		if d < 0 {
+			// clock skew between the server and the client
+			d = 0
+		}
+		sub.deliveryLatency.observe(d)
+	}
+	end := sub.startConsumeSpan(m)
+	defer end()
+	if sub.blockThreshold > 0 {
+		defer sub.detectBlocking(m.ID)()
+	}
+	if sub.ackCallback == nil {
+		sub.call(m.ID, func() { sub.callback(err, m.ID, m.Headers, m.Payload) })
+		m.Ack()
+	} else if !sub.call(m.ID, func() { sub.ackCallback(err, m) }) {
+		// redelivered unless settled before the panic
+		m.Nack()
+	} else if sub.ackMode == AckModeAuto {
+		m.Ack()
+	}
+	sub.stats.Lock()
+	sub.stats.messageCount++
+	sub.stats.Unlock()
+}
+
+// detectBlocking logs a warning if the callback for the message doesn't return within the blocking
+// threshold. The returned function must be invoked once the callback returned.
+func (sub *subscription) detectBlocking(id string) func() {
+	start := time.Now()
+	var blocked int32
+	timer := time.AfterFunc(sub.blockThreshold, func() {
+		atomic.StoreInt32(&blocked, 1)
+		sub.logger.Warnf("Callback for message %s of stream %s hasn't returned after %v, it blocks the subscriber", id, sub.stream, sub.blockThreshold)
+	})
+	return func() {
+		if !timer.Stop() && atomic.LoadInt32(&blocked) == 1 {
+			sub.logger.Warnf("Callback for message %s of stream %s returned after %v", id, sub.stream, time.Since(start))
+		}
+	}
+}
+
+// SubscriptionInfo describes an active subscription
+type SubscriptionInfo struct {
+	Stream        string    // stream name
+	ID            string    // subscription ID
+	CreatedAt     time.Time // time the subscription was added to the connection
+	LastConsumeAt time.Time // time of the last successful consume response, zero if none yet
+	MessageCount  int64     // number of messages delivered to the callback
+	Duplicates    int64     // number of duplicate messages suppressed with SubscribeOptions.Dedup
+	Stale         int64     // number of stale messages skipped with SubscribeOptions.MaxAge
+
+	GroupID string // consumer group of the subscription, see SubscribeOptions.GroupID
+}
+
+func (sub *subscription) info() SubscriptionInfo {
+	sub.stats.Lock()
+	defer sub.stats.Unlock()
+	return SubscriptionInfo{
+		Stream:        sub.stream,
+		ID:            sub.id,
+		CreatedAt:     sub.createdAt,
+		LastConsumeAt: sub.stats.lastConsumeAt,
+		MessageCount:  sub.stats.messageCount,
+		Duplicates:    sub.dedup.duplicates(),
+		Stale:         sub.stats.staleCount,
+		GroupID:       sub.groupID,
+	}
+}
+
+// consumeContext returns the consume context of the subscription of the stream, empty if the
+// stream isn't subscribed
+func (c *internalConnection) consumeContext(stream string) string {
+	c.subs.Lock()
+	sub, ok := c.subs.table[stream]
+	c.subs.Unlock()
+	if !ok {
+		return ""
+	}
+	sub.stats.Lock()
+	defer sub.stats.Unlock()
+	return sub.stats.consumeCtx
+}
+
+// waitForConsumedTick is the interval at which WaitForConsumed checks the delivered messages
+var waitForConsumedTick = 10 * time.Millisecond
+
+// WaitForConsumed blocks until at least n messages have been delivered to the callback of the
+// subscription of the stream or ctx is done. The stream doesn't have to be subscribed yet.
+func (c *internalConnection) WaitForConsumed(ctx context.Context, stream string, n int) error {
+	ticker := time.NewTicker(waitForConsumedTick)
+	defer ticker.Stop()
+	for {
+		var delivered int64
+		c.subs.Lock()
+		sub, ok := c.subs.table[stream]
+		if ok {
+			// info reads the subscription ID, which is set under the subs lock
+			delivered = sub.info().MessageCount
+		}
+		c.subs.Unlock()
+		if ok && delivered >= int64(n) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d messages on stream %s, %d delivered: %w", n, stream, delivered, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Subscriptions returns the active subscriptions sorted by stream name
+func (c *internalConnection) Subscriptions() []SubscriptionInfo {
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	infos := make([]SubscriptionInfo, 0, len(c.subs.table))
+	for _, sub := range c.subs.table {
+		infos = append(infos, sub.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Stream < infos[j].Stream
+	})
+	return infos
+}
+
+// SubscriptionSnapshot returns the active subscriptions indexed by stream. The map is a copy taken
+// under the lock, it doesn't change with the subscriptions.
+func (c *internalConnection) SubscriptionSnapshot() map[string]SubscriptionInfo {
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	snapshot := make(map[string]SubscriptionInfo, len(c.subs.table))
+	for stream, sub := range c.subs.table {
+		snapshot[stream] = sub.info()
+	}
+	return snapshot
 }
 
 // subscriber goroutine is spawned for each subscription to a stream
 func (c *internalConnection) subscriber(sub *subscription) {
 	defer sub.wg.Done()
 	defer c.wg.Done()
-	log.Logger.Debugf("Starting subscriber thread for %s", sub.stream)
+	if sub.buffer != nil {
+		defer close(sub.buffer)
+	}
+	defer sub.stopWorkers()
+	sub.logger.Debugf("Starting subscriber thread for %s", sub.stream)
 
-	consumeCtx := ""
+	sub.stats.Lock()
+	consumeCtx := sub.stats.consumeCtx
+	sub.stats.Unlock()
+	poll := newPollInterval(c.config)
+	delay := poll.current
+	retry := newConsumeRetry(c.config)
 loop:
 	for {
+		select {
+		case <-sub.drain:
+			// subscription is being drained, no more consume requests
+			break loop
+		case req := <-sub.rewind:
+			consumeCtx = sub.applyRewind(req)
+		default:
+		}
+		// with flow control, the credits not spent by buffered messages are returned at the end of
+		// the iteration
+		granted, spent, full := 0, 0, false
+		if sub.credits != nil {
+			var ok bool
+			if granted, ok = sub.credits.acquire(sub.ctx.Done(), sub.drain); !ok {
+				break loop
+			}
+		}
+		// the consume response is limited to the granted credits and the batch size
+		limit := granted
+		if sub.batchSize > 0 && (limit == 0 || sub.batchSize < limit) {
+			limit = sub.batchSize
+		}
+		sub.redeliver()
+		sub.breaker.trial()
 		// send consume message for requesting data from the server
-		respCh, err := c.sendConsumeMessage(sub.id, consumeCtx)
+		sentAt := time.Now()
+		respCh, err := c.sendConsumeMessage(sub.id, consumeCtx, limit)
 		if err != nil {
-			log.Logger.Errorf("Failed to start consumption for stream %s: %v", sub.stream, err)
-			sub.callback(err, "", nil, nil)
+			c.consumeFailed(sub, retry, err, "", delay)
 		} else {
 			select {
 			case resp := <-respCh:
 				// received consume response from the processor
+				c.config.Metrics.ObserveConsumeLatency(sub.stream, time.Since(sentAt))
 				if resp.Error.Code != 0 {
-					log.Logger.Errorf("Consume error for stream %s: %v", sub.stream, resp.Error)
-					sub.callback(fmt.Errorf("consume error: %v", resp.Error), resp.ID, nil, nil)
+					c.consumeFailed(sub, retry, fmt.Errorf("consume error: %w", c.newRPCError(resp.Error)), resp.ID, delay)
 					break
 				}
 				res, err := resp.ConsumeResult()
 				if err != nil {
-					log.Logger.Errorf("Consume error for stream %s: %v", sub.stream, err)
-					sub.callback(fmt.Errorf("consume error: %v", err), resp.ID, nil, nil)
+					c.consumeFailed(sub, retry, fmt.Errorf("consume error: %v", err), resp.ID, delay)
 					break
 				}
-				consumeCtx = res.ConsumeContext
+				retry.reset()
+				if sub.breaker.succeeded() {
+					sub.logger.Infof("Closed the circuit of stream %s, consuming again", sub.stream)
+				}
+				c.touch()
+				sub.stats.Lock()
+				sub.stats.lastConsumeAt = time.Now()
+				if sub.ackMode == AckModeAuto {
+					consumeCtx = res.ConsumeContext
+					sub.stats.consumeCtx = consumeCtx
+				}
+				sub.stats.Unlock()
+				count := 0
+				for _, messages := range res.Messages {
+					count += len(messages)
+				}
+				delay = poll.update(count)
+				// the server may have more messages
+				full = limit > 0 && count >= limit
+				c.config.Metrics.IncConsume(sub.stream, count)
+				var msgs []*Message
+				lastOffset := int64(-1)
 				for stream, messages := range res.Messages {
 					if stream != sub.stream {
-						log.Logger.Errorf("Received consume message for stream %s, was expecting messages for stream %s", stream, sub.stream)
+						sub.logger.Errorf("Received consume message for stream %s, was expecting messages for stream %s", stream, sub.stream)
 						continue
 					}
 					for _, m := range messages {
-						payload, err := base64.StdEncoding.DecodeString(m.Payload)
-						sub.callback(err, m.MsgID, m.Headers, payload)
+						msg := &Message{ID: m.MsgID, Headers: m.Headers, raw: m.Payload, sub: sub, offset: -1}
+						if m.Timestamp != 0 {
+							msg.timestamp = time.Unix(0, m.Timestamp*int64(time.Millisecond))
+						}
+						if m.Offset != nil {
+							msg.offset = *m.Offset
+							if msg.offset > lastOffset {
+								lastOffset = msg.offset
+							}
+						}
+						sub.addMetadataHeaders(msg)
+						if sub.dedup != nil && sub.dedup.seen(msg.ID) {
+							sub.logger.Debugf("Skipping duplicate message %s of stream %s", msg.ID, sub.stream)
+							continue
+						}
+						if sub.stale(msg) {
+							sub.logger.Debugf("Skipping stale message %s of stream %s accepted at %v", msg.ID, sub.stream, msg.timestamp)
+							continue
+						}
+						if sub.filtered(msg) {
+							sub.logger.Debugf("Skipping message %s of stream %s rejected by the filter", msg.ID, sub.stream)
+							continue
+						}
+						msgs = append(msgs, msg)
+					}
+				}
+				sub.recordOffset(lastOffset)
+				nextOffset := sub.nextOffset
+				if sub.opts.Ordered {
+					msgs = sub.order(msgs)
+				}
+				if sub.credits != nil {
+					// the buffer has room for the granted messages, the deliverer goroutine
+					// returns their credits once processed
+					for _, msg := range msgs {
+						sub.buffer <- msg
 					}
+					spent = len(msgs)
+					break
+				}
+				if sub.ackMode == AckModeManual {
+					msgs = sub.batch.start(msgs)
+				}
+				for _, msg := range msgs {
+					c.dispatch(sub, msg)
+				}
+				if sub.ackMode == AckModeManual {
+					if !sub.waitAcked(delay) {
+						if sub.ctx.Err() != nil {
+							break
+						}
+						sub.logger.Warnf("Messages of stream %s weren't acked within %v, consuming them again", sub.stream, sub.ackTimeout)
+						// the messages consumed again aren't duplicates
+						sub.nextOffset = nextOffset
+						if sub.dedup != nil {
+							sub.dedup.forget(msgs)
+						}
+						break
+					}
+					consumeCtx = res.ConsumeContext
+					sub.batch.reset()
+					sub.stats.Lock()
+					sub.stats.consumeCtx = consumeCtx
+					sub.stats.Unlock()
 				}
 			case <-time.After(consumeResponseTimeout):
 				// Consume timeout. Disconnect will trigger reconnect.
-				log.Logger.Warnf("Consume timeout. Disconnecting")
+				sub.logger.Warnf("Consume timeout. Disconnecting")
 				c.consumeTimeout = true
 				// This requires a go routine otherwise the waitgroup blocks forever
 				go c.disconnect()
@@ -172,81 +783,306 @@ loop:
 				break loop
 			}
 		}
+		if sub.credits != nil {
+			sub.credits.release(granted - spent)
+		}
+		wait := poll.jittered(delay)
+		if full {
+			// consume again right away, or as soon as credits are replenished
+			wait = 0
+		}
+		if retry.backoff > 0 {
+			wait = retry.backoff
+		}
+		if sub.breaker.State() == CircuitOpen {
+			wait = sub.breaker.cooldown
+		}
 		select {
 		case <-sub.ctx.Done():
 			// user unsubscribed from the stream
 			break loop
-		case <-time.After(c.config.PollInterval):
+		case <-sub.drain:
+			// subscription is being drained, no more consume requests
+			break loop
+		case req := <-sub.rewind:
+			// consume from the earlier context right away
+			consumeCtx = sub.applyRewind(req)
+		case <-time.After(wait):
+		}
+	}
+	sub.logger.Debugf("Stopped subscriber thread for %s", sub.stream)
+}
+
+// consumeFailed handles a failed consume request of the subscription. Retryable errors are only
+// delivered to the callback once they reach Config.ConsumeErrorThreshold, the next consume request
+// is delayed by the backoff of retry in the meantime. Once the circuit breaker opens, only
+// ErrCircuitOpen is delivered until it closes again.
+func (c *internalConnection) consumeFailed(sub *subscription, retry *consumeRetry, err error, id string, delay time.Duration) {
+	notify := retry.failed(err, delay)
+	if sub.breaker.failed() {
+		sub.logger.Errorf("Consume error %d for stream %s, opening the circuit for %v: %v",
+			sub.breaker.failures, sub.stream, sub.breaker.cooldown, err)
+		sub.notifyError(fmt.Errorf("%w: %v", ErrCircuitOpen, err), id)
+		return
+	}
+	if sub.breaker.State() != CircuitClosed {
+		sub.logger.Warnf("Consume error %d for stream %s, circuit still open for %v: %v",
+			sub.breaker.failures, sub.stream, sub.breaker.cooldown, err)
+		return
+	}
+	if !notify {
+		sub.logger.Warnf("Consume error %d for stream %s, retrying in %v: %v",
+			retry.failures, sub.stream, retry.backoff, err)
+		return
+	}
+	sub.logger.Errorf("Consume error for stream %s: %v", sub.stream, err)
+	sub.notifyError(err, id)
+}
+
+// deliverMessage decodes the message and delivers it to the callback of the subscription
+func (c *internalConnection) deliverMessage(sub *subscription, msg *Message) {
+	payload, err := msg.Payload, error(nil)
+	if !msg.decoded {
+		payload, err = sub.decodePayload(msg)
+	}
+	if err != nil {
+		// a message that can't be decoded is delivered with the error and is settled, it doesn't
+		// hold back the rest of the batch
+		sub.logger.Errorf("Failed to decode message %s of stream %s: %v", msg.ID, sub.stream, err)
+		err = fmt.Errorf("%w: message %s: %v", ErrInvalidPayload, msg.ID, err)
+		if c.config.DecodeErrorHandler != nil {
+			c.config.DecodeErrorHandler(sub.stream, msg.ID, msg.raw, err)
+			sub.ack(msg.ID)
+			return
 		}
+		msg.settled = true
+	}
+	msg.Payload = payload
+	sub.deliver(err, msg)
+	if err != nil {
+		sub.ack(msg.ID)
+	}
+}
+
+// stale returns true if the message is older than SubscribeOptions.MaxAge. Messages without a
+// timestamp are never stale, which is logged once per subscription.
+func (sub *subscription) stale(msg *Message) bool {
+	if sub.opts.MaxAge <= 0 {
+		return false
+	}
+	if msg.timestamp.IsZero() {
+		sub.noTimestampOnce.Do(func() {
+			sub.logger.Warnf("Messages of stream %s have no timestamp, they're delivered regardless of their age", sub.stream)
+		})
+		return false
+	}
+	if time.Since(msg.timestamp) <= sub.opts.MaxAge {
+		return false
+	}
+	sub.stats.Lock()
+	sub.stats.staleCount++
+	sub.stats.Unlock()
+	return true
+}
+
+// filtered returns true if SubscribeOptions.Filter rejects the message. The decoded payload is kept
+// for the delivery.
+func (sub *subscription) filtered(msg *Message) bool {
+	if sub.opts.Filter == nil {
+		return false
+	}
+	payload, err := sub.decodePayload(msg)
+	if err != nil {
+		// delivered with the error
+		return false
+	}
+	msg.Payload = payload
+	msg.decoded = true
+	return !sub.opts.Filter(msg.Headers, payload)
+}
+
+// order sorts the messages of a consume response by offset and drops the messages at or before the
+// offset of a message already delivered, see SubscribeOptions.Ordered
+func (sub *subscription) order(msgs []*Message) []*Message {
+	for _, msg := range msgs {
+		if msg.offset < 0 {
+			// the offsets aren't reported by the server
+			return msgs
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].offset < msgs[j].offset })
+	ordered := msgs[:0]
+	for _, msg := range msgs {
+		if msg.offset < sub.nextOffset {
+			sub.logger.Warnf("Skipping duplicate message %s of stream %s at offset %d", msg.ID, sub.stream, msg.offset)
+			continue
+		}
+		sub.nextOffset = msg.offset + 1
+		ordered = append(ordered, msg)
+	}
+	return ordered
+}
+
+// deliverer delivers the messages buffered by the subscriber goroutine of a subscription with
+// flow control and replenishes their credits
+func (c *internalConnection) deliverer(sub *subscription) {
+	defer sub.wg.Done()
+	defer c.wg.Done()
+	for msg := range sub.buffer {
+		if sub.ctx.Err() == nil {
+			c.deliverMessage(sub, msg)
+		}
+		sub.credits.release(1)
+	}
+}
+
+// decodePayload decodes the payload of a consumed message
+func (sub *subscription) decodePayload(msg *Message) ([]byte, error) {
+	if sub.opts.RawPayloads || isRaw(msg.Headers) {
+		return sub.rawPayload(msg)
+	}
+	payload, err := sub.encoding.decode(msg.raw, sub.encodingFallback)
+	if err != nil {
+		return nil, err
+	}
+	payload, known, err := decompress(msg.Headers, payload)
+	if !known {
+		sub.logger.Warnf("Unknown content encoding %s of message %s of stream %s, delivering the payload as is",
+			msg.Headers[headerContentEncoding], msg.ID, sub.stream)
 	}
-	log.Logger.Debugf("Stopped subscriber thread for %s", sub.stream)
+	return payload, err
 }
 
 type subscriptionReq struct {
-	GroupID string   `json:"groupId"`
-	Streams []string `json:"streams"`
+	GroupID     string      `json:"groupId"`
+	Streams     []string    `json:"streams"`
+	Prefetch    int         `json:"prefetch,omitempty"`
+	OffsetReset OffsetReset `json:"offsetReset,omitempty"`
+
+	// SupportedCompressions are the content encodings the server may compress the consumed
+	// payloads with
+	SupportedCompressions []string `json:"supportedCompressions,omitempty"`
 }
 
 type subscriptionResp struct {
 	ID string `json:"_id"`
 }
 
-func (c *internalConnection) createSubscription(stream string) (string, error) {
+// requestContext returns a context for a REST request that's done when either ctx or the
+// connection's context is done
+func (c *internalConnection) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (c *internalConnection) createSubscription(ctx context.Context, stream string, opts SubscribeOptions) (string, error) {
 	subReq := subscriptionReq{
-		GroupID: c.config.GroupID,
-		Streams: []string{stream},
+		GroupID:     opts.groupID(c.config.GroupID),
+		Streams:     []string{stream},
+		Prefetch:    opts.Prefetch,
+		OffsetReset: opts.OffsetReset,
+	}
+	if !opts.RawPayloads {
+		// raw payloads are delivered as consumed
+		subReq.SupportedCompressions = supportedCompressions
 	}
 	subResp := subscriptionResp{}
 	u := url.URL{
-		Scheme: httpScheme,
+		Scheme: c.config.Scheme,
 		Host:   c.config.Domain,
 		Path:   apiPaths.subscriptions,
 	}
-	authValue, err := c.authHeader.provider()
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	start := time.Now()
+	resp, err := c.restRequestWithRetry(ctx, "create subscription", func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(subReq).SetResult(&subResp).Post(u.String())
+	})
 	if err != nil {
-		log.Logger.Errorf("Failed to obtain auth header: %v", err)
 		return "", err
 	}
-	resp, err := c.restClient.R().
-		SetHeader(c.authHeader.key, string(authValue)).
-		SetBody(subReq).
-		SetResult(&subResp).
-		Post(u.String())
-	if err != nil {
-		return "", fmt.Errorf("failed to create subscription for %s: %v", stream, err)
-	}
 
 	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
-		log.Logger.Errorf("Received unexpected response '%s' while creating the subscription", resp.Status())
+		c.logger().Errorf("Received unexpected response '%s' while creating the subscription", resp.Status())
 		return "", fmt.Errorf("received unexpected response '%s' while creating the subscription", resp.Status())
 	}
 
-	log.Logger.Debugf("Subscription created: %+v", subResp)
+	c.logger().Debugf("Subscription created: %+v", subResp)
 	if subResp.ID == "" {
-		return "", fmt.Errorf("received empty subscriptions ID")
+		return "", ErrEmptySubscriptionID
 	}
+	d := time.Since(start)
+	c.subscribeLatency.observe(d)
+	c.config.Metrics.ObserveSubscribeLatency(stream, d)
 
 	return subResp.ID, nil
 }
 
-func (c *internalConnection) deleteSubscription(id string) error {
-	log.Logger.Debugf("Deleting subscription '%s'", id)
+// deleteSubscription deletes the subscription on the server, the request stops when ctx or the
+// connection's context is done
+func (c *internalConnection) deleteSubscription(ctx context.Context, id string) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	return c.deleteSubscriptionDetached(ctx, id)
+}
+
+// deleteSubscriptionDetached deletes the subscription like deleteSubscription, except that the
+// request only stops when ctx is done, not when the connection's context is
+func (c *internalConnection) deleteSubscriptionDetached(ctx context.Context, id string) error {
+	c.logger().Debugf("Deleting subscription '%s'", id)
 	u := url.URL{
-		Scheme: httpScheme,
+		Scheme: c.config.Scheme,
 		Host:   c.config.Domain,
 		Path:   path.Join(apiPaths.subscriptions, id),
 	}
 
-	token, err := c.authHeader.provider()
+	resp, err := c.restRequestWithRetry(ctx, "delete subscription", func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete(u.String())
+	})
 	if err != nil {
-		return fmt.Errorf("failed to obtain auth header for subscription %s deletion: %v", id, err)
+		return err
 	}
-	resp, err := c.restClient.R().
-		SetHeader(c.authHeader.key, string(token)).
-		Delete(u.String())
-	if err != nil || (resp.StatusCode() < 200 && resp.StatusCode() >= 300) {
-		return fmt.Errorf("failed to delete subscription: %v", err)
+
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		c.logger().Errorf("Received unexpected response '%s' while deleting the subscription", resp.Status())
+		return fmt.Errorf("received unexpected response '%s' while deleting the subscription", resp.Status())
 	}
 
 	return nil
 }
+
+// deleteUnused deletes the subscription of the stream created for a connection that was closed
+// meanwhile, within defaultTimeout. The connection's context may be done already.
+func (c *internalConnection) deleteUnused(stream, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	if err := c.deleteSubscriptionDetached(ctx, id); err != nil {
+		c.logger().Errorf("Failed to delete unused subscription %s of stream %s: %v", id, stream, err)
+	}
+}
+
+// DeliveryLatencyPercentiles returns the percentiles of the time between the server accepting the
+// most recent messages of the stream and their delivery to the callback of its subscription. They're
+// zero if the stream isn't subscribed or the server doesn't report the time of the messages.
+func (c *internalConnection) DeliveryLatencyPercentiles(stream string) LatencyPercentiles {
+	c.subs.Lock()
+	sub := c.subs.table[stream]
+	c.subs.Unlock()
+	if sub == nil {
+		return LatencyPercentiles{}
+	}
+	return sub.deliveryLatency.percentiles()
+}
+
+// SubscribeLatencyPercentiles returns the percentiles of the durations of the most recent
+// subscription creations.
+func (c *internalConnection) SubscribeLatencyPercentiles() LatencyPercentiles {
+	return c.subscribeLatency.percentiles()
+}
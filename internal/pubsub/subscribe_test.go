@@ -0,0 +1,1057 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/cisco-pxgrid/cloud-sdk-go/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Subscriptions(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	require.Empty(t, c.Subscriptions())
+
+	start := time.Now()
+	received := make(chan struct{}, 2)
+	handler := func(error, string, map[string]string, []byte) { received <- struct{}{} }
+	id1, err := c.subscribe("test-stream-1", "", handler)
+	require.NoError(t, err)
+	id2, err := c.subscribe("test-stream-2", "", handler)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(ctx, "test-stream-1", nil, []byte("test payload"))
+		cancel()
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+	}
+
+	infos := c.Subscriptions()
+	require.Len(t, infos, 2)
+	require.Equal(t, "test-stream-1", infos[0].Stream)
+	require.Equal(t, id1, infos[0].ID)
+	require.Equal(t, int64(2), infos[0].MessageCount)
+	require.False(t, infos[0].CreatedAt.Before(start))
+	require.False(t, infos[0].LastConsumeAt.Before(infos[0].CreatedAt))
+	require.Equal(t, "test-stream-2", infos[1].Stream)
+	require.Equal(t, id2, infos[1].ID)
+	require.Zero(t, infos[1].MessageCount)
+
+	require.NoError(t, c.unsubscribe("test-stream-1"))
+	infos = c.Subscriptions()
+	require.Len(t, infos, 1)
+	require.Equal(t, "test-stream-2", infos[0].Stream)
+}
+
+func Test_SubscriptionIsolation(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:          apiPaths.pubsub,
+		SubscriptionsPath:   apiPaths.subscriptions,
+		ConsumeErrorStreams: []string{"failing-stream"},
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	// failing stream gets an error on every consume
+	_, err := c.subscribe("failing-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	// blocked stream never returns from the callback until released
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	releaseBlocked := func() { releaseOnce.Do(func() { close(release) }) }
+	defer releaseBlocked()
+	_, err = c.subscribe("blocked-stream", "", func(err error, _ string, _ map[string]string, _ []byte) {
+		if err != nil {
+			return
+		}
+		select {
+		case blocked <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	require.NoError(t, err)
+
+	const numStreams, numMessages = 4, 50
+	counts := make([]int32, numStreams)
+	for i := 0; i < numStreams; i++ {
+		i := i
+		_, err = c.subscribe(fmt.Sprintf("healthy-stream-%d", i), "", func(err error, _ string, _ map[string]string, _ []byte) {
+			if err == nil {
+				atomic.AddInt32(&counts[i], 1)
+			}
+		})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "blocked-stream", nil, []byte("block"))
+	require.NoError(t, err)
+	select {
+	case <-blocked:
+	case <-ctx.Done():
+		t.Fatal("blocked callback was not invoked")
+	}
+
+	for n := 0; n < numMessages; n++ {
+		for i := 0; i < numStreams; i++ {
+			_, err = c.Publish(ctx, fmt.Sprintf("healthy-stream-%d", i), nil, []byte("test payload"))
+			require.NoError(t, err)
+		}
+	}
+	require.Eventually(t, func() bool {
+		for i := range counts {
+			if atomic.LoadInt32(&counts[i]) != numMessages {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// unsubscribing the blocked stream waits for its callback, but must not block the others
+	blockedDone := make(chan error, 1)
+	go func() { blockedDone <- c.unsubscribe("blocked-stream") }()
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := c.unsubscribe("healthy-stream-0"); err != nil {
+			done <- err
+			return
+		}
+		_, err := c.subscribe("new-stream", "", func(error, string, map[string]string, []byte) {})
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription changes blocked by another subscription's callback")
+	}
+	require.Len(t, c.Subscriptions(), numStreams+1)
+
+	releaseBlocked()
+	require.NoError(t, <-blockedDone)
+}
+
+func Test_SubscribePrefetch(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	_, err := c.subscribe("test-stream-1", "", handler, WithPrefetch(100))
+	require.NoError(t, err)
+	_, err = c.subscribe("test-stream-2", "", handler)
+	require.NoError(t, err)
+
+	req, ok := test.GetSubscriptionRequest("test-stream-1")
+	require.True(t, ok)
+	require.Equal(t, "test-client", req.GroupID)
+	require.Equal(t, []string{"test-stream-1"}, req.Streams)
+	require.Equal(t, 100, req.Prefetch)
+
+	req, ok = test.GetSubscriptionRequest("test-stream-2")
+	require.True(t, ok)
+	require.Zero(t, req.Prefetch)
+}
+
+func Test_DecodeErrorHandler(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	type deadLetter struct {
+		stream, msgID, raw string
+		err                error
+	}
+	deadLetters := make(chan deadLetter, 1)
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		DecodeErrorHandler: func(stream, msgID string, raw string, err error) {
+			deadLetters <- deadLetter{stream: stream, msgID: msgID, raw: raw, err: err}
+		},
+	})
+	defer c.disconnect()
+
+	received := make(chan string, 2)
+	_, err := c.subscribe("test-stream", "", func(err error, id string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- id
+	})
+	require.NoError(t, err)
+
+	require.True(t, test.PublishRaw("test-stream",
+		rpc.PublishParams{MsgID: "msg-1", Stream: "test-stream", Payload: "not base64!"},
+		rpc.PublishParams{MsgID: "msg-2", Stream: "test-stream", Payload: base64.StdEncoding.EncodeToString([]byte("payload"))},
+	))
+
+	select {
+	case d := <-deadLetters:
+		require.Equal(t, "test-stream", d.stream)
+		require.Equal(t, "msg-1", d.msgID)
+		require.Equal(t, "not base64!", d.raw)
+		require.ErrorIs(t, d.err, ErrInvalidPayload)
+	case <-time.After(time.Second):
+		t.Fatal("decode error handler not invoked")
+	}
+	require.Equal(t, "msg-2", <-received)
+	require.Empty(t, received)
+}
+
+func Test_DebugDetectBlockingHandlers(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	for _, debug := range []bool{false, true} {
+		logger := &testLogger{}
+		c := newTestConnection(t, s, Config{
+			PollInterval:                10 * time.Millisecond,
+			Logger:                      logger,
+			DebugDetectBlockingHandlers: debug,
+			BlockingHandlerThreshold:    50 * time.Millisecond,
+		})
+
+		done := make(chan struct{})
+		_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {
+			// blocking handler
+			time.Sleep(200 * time.Millisecond)
+			close(done)
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+		cancel()
+		require.NoError(t, err)
+		<-done
+		c.disconnect()
+
+		require.Equal(t, debug, logger.contains("hasn't returned after 50ms"))
+		require.Equal(t, debug, logger.contains("of stream test-stream returned after"))
+	}
+}
+
+func Test_SubscriptionSetupRetries(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		CreateFailures:    2,
+		DeleteFailures:    2,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		SubscriptionSetupRetries: 3,
+	})
+	defer c.disconnect()
+
+	id, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	require.True(t, test.HasSubscription(id))
+
+	require.NoError(t, c.unsubscribe("test-stream"))
+	require.False(t, test.HasSubscription(id))
+}
+
+func Test_SubscriptionSetupRetriesExhausted(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		CreateFailures:    2,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		SubscriptionSetupRetries: 1,
+	})
+	defer c.disconnect()
+
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "503")
+}
+
+func Test_SubscriptionSetupContext(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:         apiPaths.pubsub,
+		SubscriptionsPath:  apiPaths.subscriptions,
+		SubscriptionsDelay: 500 * time.Millisecond,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.subscribeWithContext(ctx, "setup-context-stream", "", handler)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	id, err := c.subscribeWithContext(context.Background(), "setup-context-stream", "", handler)
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = c.unsubscribeWithContext(ctx, "setup-context-stream")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Len(t, c.SubscriptionSnapshot(), 1)
+
+	require.NoError(t, c.unsubscribe("setup-context-stream"))
+	require.False(t, test.HasSubscription(id))
+}
+
+func Test_SubscriptionSetupConcurrent(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var creations int32
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		// the first creation is held until released
+		OnRequest: func(r *http.Request) {
+			if r.Method == http.MethodPost && atomic.AddInt32(&creations, 1) == 1 {
+				close(blocked)
+				<-release
+			}
+		},
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	slow := make(chan error, 1)
+	go func() {
+		_, err := c.subscribe("setup-slow-stream", "", handler)
+		slow <- err
+	}()
+	<-blocked
+
+	// the other streams aren't held up by the slow creation
+	_, err := c.subscribe("setup-fast-stream", "", handler)
+	require.NoError(t, err)
+	require.Len(t, c.SubscriptionSnapshot(), 1)
+	require.NoError(t, c.unsubscribe("setup-fast-stream"))
+	// the stream being created can't be subscribed twice
+	_, err = c.subscribe("setup-slow-stream", "", handler)
+	require.ErrorIs(t, err, ErrSubscriptionExists)
+
+	close(release)
+	require.NoError(t, <-slow)
+	require.Contains(t, c.SubscriptionSnapshot(), "setup-slow-stream")
+}
+
+func Test_SubscriptionSetupClosed(t *testing.T) {
+	created := make(chan struct{})
+	release := make(chan struct{})
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		// the creation is held until released
+		OnRequest: func(r *http.Request) {
+			if r.Method == http.MethodPost {
+				close(created)
+				<-release
+			}
+		},
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := c.subscribe("setup-closed-stream", "", func(error, string, map[string]string, []byte) {})
+		subscribed <- err
+	}()
+	<-created
+
+	// the connection is closed once the subscription is created, before it's added
+	c.subs.Lock()
+	go c.disconnect()
+	require.Eventually(t, c.isClosed, time.Second, time.Millisecond)
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+	c.subs.Unlock()
+
+	require.ErrorIs(t, <-subscribed, ErrNotConnected)
+	// the subscription isn't left behind on the server
+	_, ok := test.GetSubscriptionRequest("setup-closed-stream")
+	require.False(t, ok)
+	require.Empty(t, c.SubscriptionSnapshot())
+}
+
+func Test_UnsubscribeMany(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	for i := 0; i < 3; i++ {
+		_, err := c.subscribe(fmt.Sprintf("test-stream-%d", i), "", handler)
+		require.NoError(t, err)
+	}
+
+	done, err := c.unsubscribeMany([]string{"test-stream-0", "unknown-stream", "test-stream-2"})
+	require.Equal(t, []string{"test-stream-0", "test-stream-2"}, done)
+	var multiErr *MultiError
+	require.ErrorAs(t, err, &multiErr)
+	require.Equal(t, []string{"unknown-stream"}, multiErr.Streams())
+	require.ErrorIs(t, err, ErrSubscriptionNotFound)
+
+	infos := c.Subscriptions()
+	require.Len(t, infos, 1)
+	require.Equal(t, "test-stream-1", infos[0].Stream)
+}
+
+func Test_UnsubscribeAll(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		DeleteFailures:    1,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{})
+	defer c.disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	ids := map[string]string{}
+	for i := 0; i < 5; i++ {
+		stream := fmt.Sprintf("test-stream-%d", i)
+		id, err := c.subscribe(stream, "", handler)
+		require.NoError(t, err)
+		ids[stream] = id
+	}
+
+	// the first deletion fails, the other streams are still unsubscribed
+	done, err := c.unsubscribeAll()
+	require.Len(t, done, 4)
+	var multiErr *MultiError
+	require.ErrorAs(t, err, &multiErr)
+	require.Equal(t, []string{"test-stream-0"}, multiErr.Streams())
+	for _, stream := range done {
+		require.False(t, test.HasSubscription(ids[stream]))
+	}
+	require.Len(t, c.Subscriptions(), 1)
+
+	done, err = c.unsubscribeAll()
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-stream-0"}, done)
+	require.Empty(t, c.Subscriptions())
+}
+
+func Test_WaitForConsumed(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	_, err := c.subscribe("test-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+		cancel()
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitForConsumed(ctx, "test-stream", 3))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = c.WaitForConsumed(ctx, "test-stream", 4)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "3 delivered")
+}
+
+func Test_SubscribeFilter(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	received := make(chan string, 4)
+	_, err := c.subscribe("filter-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	}, WithFilter(func(headers map[string]string, payload []byte) bool {
+		return headers["type"] == "keep"
+	}))
+	require.NoError(t, err)
+	// offset of the stream before publishing
+	require.Eventually(t, func() bool { return c.consumeContext("filter-stream") != "" }, time.Second, 10*time.Millisecond)
+	start, err := strconv.Atoi(c.consumeContext("filter-stream"))
+	require.NoError(t, err)
+
+	publish := func(kind, payload string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := c.Publish(ctx, "filter-stream", map[string]string{"type": kind}, []byte(payload))
+		require.NoError(t, err)
+	}
+	publish("skip", "1")
+	publish("keep", "2")
+	publish("skip", "3")
+	publish("skip", "4")
+	publish("keep", "5")
+	publish("skip", "6")
+
+	for _, want := range []string{"2", "5"} {
+		select {
+		case got := <-received:
+			require.Equal(t, want, got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	// consumed past the trailing filtered message
+	end := strconv.Itoa(start + 6)
+	require.Eventually(t, func() bool { return c.consumeContext("filter-stream") == end }, 2*time.Second, 10*time.Millisecond)
+	require.Empty(t, received)
+}
+
+func Test_SubscribeMaxAge(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	received := make(chan string, 4)
+	_, err := c.subscribe("max-age-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	}, WithMaxAge(time.Minute))
+	require.NoError(t, err)
+
+	message := func(payload string) rpc.PublishParams {
+		return rpc.PublishParams{MsgID: payload, Payload: base64.StdEncoding.EncodeToString([]byte(payload))}
+	}
+	require.True(t, test.PublishRawAt("max-age-stream", time.Now().Add(-time.Hour), message("stale")))
+	require.True(t, test.PublishRawAt("max-age-stream", time.Now(), message("fresh")))
+	// without timestamp
+	require.True(t, test.PublishRaw("max-age-stream", message("unknown")))
+
+	for _, want := range []string{"fresh", "unknown"} {
+		select {
+		case got := <-received:
+			require.Equal(t, want, got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	require.Equal(t, int64(1), c.Subscriptions()[0].Stale)
+	require.Empty(t, received)
+}
+
+func Test_SubscribeOffsetReset(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	for _, reset := range []OffsetReset{OffsetResetEarliest, OffsetResetLatest} {
+		stream := "offset-reset-stream-" + string(reset)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.Publish(ctx, stream, nil, []byte("before"))
+		cancel()
+		require.NoError(t, err)
+
+		received := make(chan string, 2)
+		_, err = c.subscribe(stream, "", func(err error, _ string, _ map[string]string, payload []byte) {
+			require.NoError(t, err)
+			received <- string(payload)
+		}, WithOffsetReset(reset))
+		require.NoError(t, err)
+		req, ok := test.GetSubscriptionRequest(stream)
+		require.True(t, ok)
+		require.Equal(t, string(reset), req.OffsetReset)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		_, err = c.Publish(ctx, stream, nil, []byte("after"))
+		cancel()
+		require.NoError(t, err)
+
+		want := []string{"after"}
+		if reset == OffsetResetEarliest {
+			want = []string{"before", "after"}
+		}
+		for _, w := range want {
+			select {
+			case got := <-received:
+				require.Equal(t, w, got, "offset reset %s", reset)
+			case <-time.After(2 * time.Second):
+				t.Fatal("message not received")
+			}
+		}
+	}
+
+	_, err := c.subscribe("offset-reset-stream-invalid", "", func(error, string, map[string]string, []byte) {}, WithOffsetReset("middle"))
+	require.Error(t, err)
+}
+
+func Test_SubscribeGroupID(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	received := make(chan string, 1)
+	handler := func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	}
+	_, err := c.subscribe("group-stream-override", "", handler, WithGroupID("other-group"))
+	require.NoError(t, err)
+	_, err = c.subscribe("group-stream-default", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+
+	req, ok := test.GetSubscriptionRequest("group-stream-override")
+	require.True(t, ok)
+	require.Equal(t, "other-group", req.GroupID)
+	req, ok = test.GetSubscriptionRequest("group-stream-default")
+	require.True(t, ok)
+	require.Equal(t, c.config.GroupID, req.GroupID)
+
+	snapshot := c.SubscriptionSnapshot()
+	require.Equal(t, "other-group", snapshot["group-stream-override"].GroupID)
+	require.Equal(t, c.config.GroupID, snapshot["group-stream-default"].GroupID)
+
+	// the subscription consumes in its own group
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "group-stream-override", nil, []byte("payload"))
+	require.NoError(t, err)
+	select {
+	case got := <-received:
+		require.Equal(t, "payload", got)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	_, err = c.subscribe("group-stream-empty", "", handler, WithGroupID(""))
+	require.Error(t, err)
+	_, ok = test.GetSubscriptionRequest("group-stream-empty")
+	require.False(t, ok)
+}
+
+func Test_SubscribeOrdering(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeShuffle:    true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.disconnect()
+
+	received := make(chan string, 50)
+	_, err := c.subscribe("ordered-stream", "", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	}, WithOrdering(true))
+	require.NoError(t, err)
+
+	// batches of messages published in sequence
+	for batch := 0; batch < 5; batch++ {
+		var msgs []rpc.PublishParams
+		for i := 0; i < 10; i++ {
+			n := strconv.Itoa(batch*10 + i)
+			msgs = append(msgs, rpc.PublishParams{
+				MsgID:   "msg-" + n,
+				Stream:  "ordered-stream",
+				Payload: base64.StdEncoding.EncodeToString([]byte(n)),
+			})
+		}
+		require.True(t, test.PublishRaw("ordered-stream", msgs...))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case got := <-received:
+			require.Equal(t, strconv.Itoa(i), got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+}
+
+func Test_SubscriptionOrder(t *testing.T) {
+	sub := &subscription{stream: "test-stream", logger: log.Logger}
+	msg := func(id string, offset int64) *Message {
+		return &Message{ID: id, offset: offset}
+	}
+	ids := func(msgs []*Message) []string {
+		var ids []string
+		for _, m := range msgs {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	// sorted by offset
+	got := sub.order([]*Message{msg("c", 2), msg("a", 0), msg("b", 1)})
+	require.Equal(t, []string{"a", "b", "c"}, ids(got))
+	// duplicates of the delivered messages are skipped
+	got = sub.order([]*Message{msg("d", 3), msg("b", 1), msg("e", 4), msg("e", 4)})
+	require.Equal(t, []string{"d", "e"}, ids(got))
+	require.Equal(t, int64(5), sub.nextOffset)
+	// left as is without offsets
+	got = sub.order([]*Message{msg("g", -1), msg("f", 0)})
+	require.Equal(t, []string{"g", "f"}, ids(got))
+}
+
+func Test_ConsumeBatchSize(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	// full responses are followed by the next consume request without waiting for the interval
+	c := newTestConnection(t, s, Config{
+		PollInterval:     time.Second,
+		ConsumeBatchSize: 3,
+	})
+	defer c.disconnect()
+
+	callback := func(err error, _ string, _ map[string]string, _ []byte) {
+		require.NoError(t, err)
+	}
+	_, err := c.subscribe("batch-stream-1", "", callback)
+	require.NoError(t, err)
+	_, err = c.subscribe("batch-stream-2", "", callback, WithBatchSize(5))
+	require.NoError(t, err)
+
+	for _, stream := range []string{"batch-stream-1", "batch-stream-2"} {
+		var msgs []rpc.PublishParams
+		for i := 0; i < 10; i++ {
+			msgs = append(msgs, rpc.PublishParams{
+				MsgID:   fmt.Sprintf("msg-%d", i),
+				Stream:  stream,
+				Payload: base64.StdEncoding.EncodeToString([]byte("payload")),
+			})
+		}
+		require.True(t, test.PublishRaw(stream, msgs...))
+	}
+	// the first consume requests may be sent before publishing
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitForConsumed(ctx, "batch-stream-1", 10))
+	require.NoError(t, c.WaitForConsumed(ctx, "batch-stream-2", 10))
+
+	for stream, size := range map[string]int{"batch-stream-1": 3, "batch-stream-2": 5} {
+		credits := test.ConsumeCredits(stream)
+		require.NotEmpty(t, credits)
+		for _, n := range credits {
+			require.Equal(t, size, n)
+		}
+	}
+
+	_, err = c.subscribe("batch-stream-3", "", callback, WithBatchSize(-1))
+	require.Error(t, err)
+	_, err = newInternalConnection(Config{GroupID: "test-client", Domain: "localhost", ConsumeBatchSize: -1})
+	require.EqualError(t, err, "Config ConsumeBatchSize must not be negative")
+}
+
+func Test_CallbackPanic(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	panics := make(chan *PanicError, 2)
+	c := newTestConnection(t, s, Config{
+		PollInterval:        10 * time.Millisecond,
+		NackRedeliveryDelay: 10 * time.Millisecond,
+		PanicHandler: func(err *PanicError) {
+			panics <- err
+		},
+	})
+	defer c.disconnect()
+
+	received := make(chan string, 2)
+	_, err := c.subscribe("panic-stream", "", func(err error, id string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		if string(payload) == "1" {
+			panic("boom")
+		}
+		received <- string(payload)
+	})
+	require.NoError(t, err)
+	redelivered := make(chan int, 2)
+	_, err = c.subscribeWithAck("panic-ack-stream", "", func(err error, m *Message) {
+		require.NoError(t, err)
+		if m.Redeliveries == 0 {
+			panic("boom")
+		}
+		redelivered <- m.Redeliveries
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, payload := range []string{"1", "2"} {
+		_, err = c.Publish(ctx, "panic-stream", nil, []byte(payload))
+		require.NoError(t, err)
+	}
+
+	// the subscription keeps delivering the following messages
+	select {
+	case payload := <-received:
+		require.Equal(t, "2", payload)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message after panic not received")
+	}
+	select {
+	case err := <-panics:
+		require.Equal(t, "panic-stream", err.Stream)
+		require.Equal(t, "boom", err.Value)
+		require.Contains(t, string(err.Stack), "Test_CallbackPanic")
+		require.Contains(t, err.Error(), "panicked: boom")
+	case <-time.After(time.Second):
+		t.Fatal("panic handler not invoked")
+	}
+
+	// the message whose ack callback panicked is nacked
+	_, err = c.Publish(ctx, "panic-ack-stream", nil, []byte("1"))
+	require.NoError(t, err)
+	select {
+	case n := <-redelivered:
+		require.Equal(t, 1, n)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not redelivered after panic")
+	}
+	require.Equal(t, "panic-ack-stream", (<-panics).Stream)
+}
+
+func Test_ConsumeRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		code      int
+		errors    int // number of errors delivered to the callback
+		retryable bool
+	}{
+		{name: "retryable", errors: 1, retryable: true},
+		{name: "fatal", code: -32600, errors: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := test.NewRPCServer(t, test.Config{
+				PubSubPath:        apiPaths.pubsub,
+				SubscriptionsPath: apiPaths.subscriptions,
+				ConsumeFailures:   4,
+				ConsumeErrorCode:  tc.code,
+			})
+			defer s.Close()
+
+			c := newTestConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+			defer c.disconnect()
+
+			stream := "consume-retry-" + tc.name
+			errs := make(chan error, 10)
+			received := make(chan string, 1)
+			_, err := c.subscribe(stream, "", func(err error, id string, _ map[string]string, _ []byte) {
+				if err != nil {
+					errs <- err
+					return
+				}
+				received <- id
+			})
+			require.NoError(t, err)
+			require.True(t, test.PublishRaw(stream, rpc.PublishParams{MsgID: "msg-1", Payload: base64.StdEncoding.EncodeToString([]byte("payload"))}))
+
+			select {
+			case id := <-received:
+				require.Equal(t, "msg-1", id)
+			case <-time.After(2 * time.Second):
+				require.FailNow(t, "Consume timed out")
+			}
+			require.Len(t, errs, tc.errors)
+			err = <-errs
+			var rpcErr *RPCError
+			require.ErrorAs(t, err, &rpcErr)
+			require.Equal(t, tc.retryable, rpcErr.Temporary())
+		})
+	}
+}
+
+func Test_ConsumeRetryBackoff(t *testing.T) {
+	r := newConsumeRetry(Config{ConsumeErrorThreshold: 2, MaxConsumeBackoff: 35 * time.Millisecond})
+	temporary := &RPCError{Code: -32099}
+	require.False(t, r.failed(temporary, 10*time.Millisecond))
+	require.Equal(t, 10*time.Millisecond, r.backoff)
+	require.True(t, r.failed(temporary, 10*time.Millisecond))
+	require.Equal(t, 20*time.Millisecond, r.backoff)
+	require.False(t, r.failed(ErrWriterBusy, 10*time.Millisecond))
+	require.Equal(t, 35*time.Millisecond, r.backoff)
+	require.True(t, r.failed(temporary, 10*time.Millisecond))
+
+	// fatal errors are delivered right away and reset the backoff
+	require.True(t, r.failed(&RPCError{Code: -32600}, 10*time.Millisecond))
+	require.Zero(t, r.backoff)
+	require.False(t, r.failed(temporary, 10*time.Millisecond))
+	r.reset()
+	require.Zero(t, r.failures)
+	require.Zero(t, r.backoff)
+
+	// the delay requested by the server takes precedence
+	require.False(t, r.failed(&RPCError{Code: rpc.CodeThrottled, RetryAfter: 100 * time.Millisecond}, 10*time.Millisecond))
+	require.Equal(t, 100*time.Millisecond, r.backoff)
+}
+
+func Test_ConsumeThrottled(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:         apiPaths.pubsub,
+		SubscriptionsPath:  apiPaths.subscriptions,
+		ConsumeFailures:    1,
+		ConsumeErrorCode:   rpc.CodeThrottled,
+		ThrottleRetryAfter: 200 * time.Millisecond,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.disconnect()
+
+	_, err := c.subscribe("consume-throttled-stream", "", func(error, string, map[string]string, []byte) {})
+	require.NoError(t, err)
+	// the throttling of the consume requests is recorded like the publishes'
+	require.Eventually(t, func() bool { return c.ThrottleState().Count == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, 200*time.Millisecond, c.ThrottleState().RetryAfter)
+}
+
+func Test_ConsumeDuplicateResponse(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		ConsumeDuplicate:  true,
+	})
+	defer s.Close()
+
+	c := newTestConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.disconnect()
+
+	received := make(chan string, 10)
+	_, err := c.subscribe("duplicate-response-stream", "", func(err error, id string, _ map[string]string, _ []byte) {
+		require.NoError(t, err)
+		received <- id
+	})
+	require.NoError(t, err)
+	for _, id := range []string{"msg-1", "msg-2"} {
+		require.True(t, test.PublishRaw("duplicate-response-stream", rpc.PublishParams{MsgID: id, Payload: base64.StdEncoding.EncodeToString([]byte(id))}))
+	}
+	for _, expected := range []string{"msg-1", "msg-2"} {
+		select {
+		case id := <-received:
+			require.Equal(t, expected, id)
+		case <-time.After(time.Second):
+			require.FailNow(t, "Consume timed out")
+		}
+	}
+	select {
+	case id := <-received:
+		require.FailNow(t, "Message delivered twice", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the RPC layer invoking the handler twice neither blocks nor panics
+	respCh, handler := c.singleResponse("consume")
+	first := rpc.NewErrorResponse("req-1", fmt.Errorf("first"))
+	handler(first)
+	handler(rpc.NewErrorResponse("req-1", fmt.Errorf("second")))
+	require.Same(t, first, <-respCh)
+	_, ok := <-respCh
+	require.False(t, ok)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+// Subscription is a handle to the subscription of a stream. It remains valid across reconnects.
+type Subscription struct {
+	conn   *Connection
+	stream string
+}
+
+// Subscription returns the handle to the subscription of the stream, or nil if the stream isn't
+// subscribed.
+func (c *Connection) Subscription(stream string) *Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subscriptions[stream]; !ok {
+		return nil
+	}
+	return &Subscription{conn: c, stream: stream}
+}
+
+// Stream returns the stream name of the subscription
+func (s *Subscription) Stream() string {
+	return s.stream
+}
+
+// Activate creates a lazy subscription on the server and starts consuming. It has no effect if the
+// subscription is already active. A *SubscriptionError is returned on failure.
+func (s *Subscription) Activate() error {
+	conn, release, err := s.conn.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	id, err := conn.activate(s.stream)
+	if err != nil {
+		return err
+	}
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	if sub, ok := s.conn.subscriptions[s.stream]; ok {
+		sub.subscriptionID = id
+		s.conn.subscriptions[s.stream] = sub
+	}
+	return nil
+}
+
+// ConsumeContext returns the consume context of the subscription, which identifies the position
+// after the messages consumed so far. It can be persisted to resume consuming from the same
+// position with SubscribeFrom, e.g. after a restart. It's empty until the first consume response
+// is received or if the stream isn't subscribed anymore.
+func (s *Subscription) ConsumeContext() string {
+	return s.conn.current().consumeContext(s.stream)
+}
@@ -0,0 +1,434 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPublicConnection creates and connects a Connection to the test server
+func newTestPublicConnection(t *testing.T, s *httptest.Server, config Config) *Connection {
+	u, _ := url.Parse(s.URL)
+	if config.GroupID == "" {
+		config.GroupID = "test-client"
+	}
+	config.Domain = u.Host
+	if config.APIKeyProvider == nil && config.AuthTokenProvider == nil && config.AuthTokenProviderWithExpiry == nil {
+		config.APIKeyProvider = func() ([]byte, error) {
+			return []byte("xyz"), nil
+		}
+	}
+	if config.Transport == nil {
+		config.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // no verification for test server
+		}
+	}
+	c, err := NewConnection(config)
+	require.NoError(t, err)
+	err = c.Connect(context.Background())
+	require.NoError(t, err)
+	return c
+}
+
+func Test_LazySubscription(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	received := make(chan []byte, 1)
+	err := c.Subscribe("test-stream", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- payload
+	}, WithLazy())
+	require.NoError(t, err)
+
+	// registered, but not created on the server
+	_, ok := test.GetSubscriptionRequest("test-stream")
+	require.False(t, ok)
+	subs := c.Subscriptions()
+	require.Len(t, subs, 1)
+	require.Equal(t, "test-stream", subs[0].Stream)
+	require.Empty(t, subs[0].ID)
+	time.Sleep(50 * time.Millisecond)
+	_, ok = test.GetSubscriptionRequest("test-stream")
+	require.False(t, ok)
+
+	require.Nil(t, c.Subscription("other-stream"))
+	sub := c.Subscription("test-stream")
+	require.NotNil(t, sub)
+	require.Equal(t, "test-stream", sub.Stream())
+	err = sub.Activate()
+	require.NoError(t, err)
+	_, ok = test.GetSubscriptionRequest("test-stream")
+	require.True(t, ok)
+	id := c.Subscriptions()[0].ID
+	require.NotEmpty(t, id)
+	require.True(t, test.HasSubscription(id))
+
+	// activating again has no effect
+	err = sub.Activate()
+	require.NoError(t, err)
+	require.Equal(t, id, c.Subscriptions()[0].ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(ctx, "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	select {
+	case payload := <-received:
+		require.Equal(t, []byte("test payload"), payload)
+	case <-ctx.Done():
+		t.Fatal("message not received after activation")
+	}
+}
+
+func Test_LazySubscriptionActivateConcurrent(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var creations int32
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		// the first creation is held until released
+		OnRequest: func(r *http.Request) {
+			if r.Method == http.MethodPost && atomic.AddInt32(&creations, 1) == 1 {
+				close(blocked)
+				<-release
+			}
+		},
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	defer c.Disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	require.NoError(t, c.Subscribe("lazy-concurrent-stream", handler, WithLazy()))
+	sub := c.Subscription("lazy-concurrent-stream")
+	activated := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			activated <- sub.Activate()
+		}()
+	}
+	<-blocked
+
+	// the other streams aren't held up by the activation
+	require.NoError(t, c.Subscribe("activate-other-stream", handler))
+	require.Len(t, c.SubscriptionSnapshot(), 2)
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-activated)
+	}
+	// a single subscription is created for both activations
+	require.Equal(t, int32(2), atomic.LoadInt32(&creations))
+	for _, info := range c.Subscriptions() {
+		require.NotEmpty(t, info.ID)
+	}
+}
+
+func Test_LazySubscriptionUnsubscribe(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+		DeleteError:       true,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	defer c.Disconnect()
+
+	err := c.Subscribe("test-stream", func(error, string, map[string]string, []byte) {}, WithLazy())
+	require.NoError(t, err)
+
+	// nothing to delete on the server
+	err = c.Unsubscribe("test-stream")
+	require.NoError(t, err)
+	require.Empty(t, c.Subscriptions())
+
+	sub := &Subscription{conn: c, stream: "test-stream"}
+	err = sub.Activate()
+	require.True(t, errors.Is(err, ErrSubscriptionNotFound))
+}
+
+func Test_SubscribeFrom(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	publish := func(c *Connection, payloads ...string) {
+		for _, p := range payloads {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := c.Publish(ctx, "resume-stream", nil, []byte(p))
+			cancel()
+			require.NoError(t, err)
+		}
+	}
+	receive := func(received chan string, n int) []string {
+		var payloads []string
+		for i := 0; i < n; i++ {
+			select {
+			case p := <-received:
+				payloads = append(payloads, p)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("received %d of %d messages", i, n)
+			}
+		}
+		return payloads
+	}
+
+	c1 := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	received := make(chan string, 10)
+	handler := func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	}
+	err := c1.Subscribe("resume-stream", handler)
+	require.NoError(t, err)
+	publish(c1, "1", "2")
+	require.Equal(t, []string{"1", "2"}, receive(received, 2))
+
+	// checkpoint, then messages are published while the consumer is down
+	require.Eventually(t, func() bool {
+		return c1.Subscription("resume-stream").ConsumeContext() != ""
+	}, time.Second, 10*time.Millisecond)
+	checkpoint := c1.Subscription("resume-stream").ConsumeContext()
+	c1.Disconnect()
+	require.Empty(t, c1.current().consumeContext("resume-stream"))
+
+	c2 := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c2.Disconnect()
+	publish(c2, "3", "4")
+
+	// resumes exactly after the checkpoint
+	err = c2.SubscribeFrom("resume-stream", checkpoint, handler)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3", "4"}, receive(received, 2))
+	publish(c2, "5")
+	require.Equal(t, []string{"5"}, receive(received, 1))
+}
+
+func Test_SubscribeOrGetConcurrent(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	metrics := &testMetrics{}
+	c := newTestPublicConnection(t, s, Config{
+		Metrics: metrics,
+	})
+	defer c.Disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	n := 20
+	ids := make(chan string, n)
+	subscribeErrs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			id, err := c.SubscribeOrGet("test-stream-or-get", handler)
+			require.NoError(t, err)
+			ids <- id
+		}()
+		go func() {
+			defer wg.Done()
+			subscribeErrs <- c.Subscribe("test-stream-or-get", handler)
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(subscribeErrs)
+
+	// a single subscription is created on the server
+	metrics.Lock()
+	require.Equal(t, 1, metrics.subscribes["test-stream-or-get"])
+	metrics.Unlock()
+	subs := c.Subscriptions()
+	require.Len(t, subs, 1)
+	for id := range ids {
+		require.Equal(t, subs[0].ID, id)
+	}
+	succeeded := 0
+	for err := range subscribeErrs {
+		if err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, ErrSubscriptionExists)
+		}
+	}
+	require.LessOrEqual(t, succeeded, 1)
+}
+
+func Test_SubscribeContext(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{})
+	callback := func(error, string, map[string]string, []byte) {}
+
+	// ends when unsubscribed
+	ctx1, err := c.SubscribeContext("stream-1", callback)
+	require.NoError(t, err)
+	require.NoError(t, ctx1.Err())
+	require.NoError(t, c.Unsubscribe("stream-1"))
+	select {
+	case <-ctx1.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after unsubscribe")
+	}
+
+	// ends when the connection is closed
+	ctx2, err := c.SubscribeContext("stream-2", callback)
+	require.NoError(t, err)
+	require.NoError(t, ctx2.Err())
+	c.Disconnect()
+	select {
+	case <-ctx2.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after disconnect")
+	}
+
+	// not returned on failure
+	_, err = c.SubscribeContext("stream-2", callback)
+	require.Error(t, err)
+}
+
+func Test_SubscriptionRewind(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer c.Disconnect()
+
+	received := make(chan string, 6)
+	err := c.Subscribe("rewind-stream", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	})
+	require.NoError(t, err)
+	sub := c.Subscription("rewind-stream")
+	require.Eventually(t, func() bool { return sub.ConsumeContext() != "" }, time.Second, 10*time.Millisecond)
+	start := sub.ConsumeContext()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, payload := range []string{"1", "2", "3"} {
+		_, err = c.Publish(ctx, "rewind-stream", nil, []byte(payload))
+		require.NoError(t, err)
+	}
+	receive := func() string {
+		select {
+		case payload := <-received:
+			return payload
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+			return ""
+		}
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		require.Equal(t, want, receive())
+	}
+	require.Eventually(t, func() bool { return sub.ConsumeContext() != start }, time.Second, 10*time.Millisecond)
+
+	// the messages following the earlier consume context are delivered again
+	require.NoError(t, sub.Rewind(start))
+	for _, want := range []string{"1", "2", "3"} {
+		require.Equal(t, want, receive())
+	}
+
+	require.Error(t, sub.Rewind(""))
+	require.NoError(t, c.Unsubscribe("rewind-stream"))
+	err = sub.Rewind(start)
+	require.True(t, errors.Is(err, ErrSubscriptionNotFound), "unexpected error: %v", err)
+}
+
+func Test_SubscriptionSnapshot(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	c := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer c.Disconnect()
+
+	handler := func(error, string, map[string]string, []byte) {}
+	require.NoError(t, c.Subscribe("snapshot-stream", handler))
+	snapshot := c.SubscriptionSnapshot()
+	require.Len(t, snapshot, 1)
+	require.Equal(t, "snapshot-stream", snapshot["snapshot-stream"].Stream)
+	require.NotEmpty(t, snapshot["snapshot-stream"].ID)
+
+	// the snapshot is a copy
+	delete(snapshot, "snapshot-stream")
+	require.Len(t, c.SubscriptionSnapshot(), 1)
+
+	// subscribe, activate and unsubscribe while taking snapshots, run with -race
+	done := make(chan struct{})
+	snapshotted := make(chan struct{})
+	go func() {
+		defer close(snapshotted)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for stream, info := range c.SubscriptionSnapshot() {
+				require.Equal(t, stream, info.Stream)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			_ = c.WaitForConsumed(ctx, "snapshot-lazy-0", 1)
+			cancel()
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(stream string) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				require.NoError(t, c.Subscribe(stream, handler, WithLazy()))
+				require.NoError(t, c.Subscription(stream).Activate())
+				require.NoError(t, c.Unsubscribe(stream))
+			}
+		}("snapshot-lazy-" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(done)
+	<-snapshotted
+	require.Len(t, c.SubscriptionSnapshot(), 1)
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// OrderVerifier fails the test when the recorded values deviate from the expected order
+type OrderVerifier struct {
+	t        testing.TB
+	expected []string
+	next     int
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+// ExpectOrder returns an OrderVerifier expecting the values to be recorded in the supplied order,
+// e.g. the payloads received by a subscription callback
+func ExpectOrder(t testing.TB, expected ...string) *OrderVerifier {
+	v := &OrderVerifier{
+		t:        t,
+		expected: expected,
+		done:     make(chan struct{}),
+	}
+	if len(expected) == 0 {
+		close(v.done)
+	}
+	return v
+}
+
+// newOrderVerifiers returns an OrderVerifier for each stream
+func newOrderVerifiers(t testing.TB, order map[string][]string) map[string]*OrderVerifier {
+	verifiers := make(map[string]*OrderVerifier, len(order))
+	for stream, expected := range order {
+		verifiers[stream] = ExpectOrder(t, expected...)
+	}
+	return verifiers
+}
+
+// Record records the next value, the test fails if it's not the expected one
+func (v *OrderVerifier) Record(value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.next >= len(v.expected) {
+		v.t.Errorf("Ordering violation: unexpected value %q after all %d expected values", value, len(v.expected))
+		return
+	}
+	if value != v.expected[v.next] {
+		v.t.Errorf("Ordering violation at position %d: expected %q, got %q", v.next, v.expected[v.next], value)
+	}
+	v.next++
+	if v.next == len(v.expected) {
+		close(v.done)
+	}
+}
+
+// Wait waits until all the expected values are recorded, the test fails on timeout
+func (v *OrderVerifier) Wait(timeout time.Duration) {
+	select {
+	case <-v.done:
+	case <-time.After(timeout):
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.t.Errorf("Timed out waiting for ordered values: recorded %d of %d", v.next, len(v.expected))
+	}
+}
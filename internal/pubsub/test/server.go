@@ -2,13 +2,20 @@ package test
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	rpc2 "github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
 
@@ -19,52 +26,183 @@ import (
 )
 
 type Config struct {
-	PubSubPath        string
-	SubscriptionsPath string
-	RejectConn        bool
-	PublishError      bool
-	ConsumeError      bool
-	ConsumeDrop       bool
+	PubSubPath          string
+	SubscriptionsPath   string
+	StreamsPath         string         // lists the streams in the stream log if set
+	ProtocolVersion     int            // protocol version advertised by the server, no negotiation if 0
+	ClientCAs           *x509.CertPool // clients must present a certificate signed by one of the CAs if set
+	PlainHTTP           bool           // the server doesn't use TLS, ClientCAs is ignored
+	RejectConn          bool
+	RejectReconnect     bool // reject all connections after the first one
+	PublishError        bool
+	PublishFailures     int // number of publish requests that fail before publishing succeeds
+	ConsumeError        bool
+	ConsumeDrop         bool
+	PublishDrop         bool          // publish requests are never answered
+	PublishDrops        int           // number of publish requests never answered before publishing succeeds
+	ConsumeErrorStreams []string      // consume requests for these streams fail
+	ConsumeFailures     int           // number of consume requests that fail before consuming succeeds
+	ConsumeErrorCode    int           // code of the consume errors, -32099 (temporary) if 0
+	ConsumeDuplicate    bool          // consume responses are written twice
+	ConsumeDelay        time.Duration // delay before responding to consume requests
+	ConsumeShuffle      bool          // messages of a consume response are in random order
+	PublishThrottles    int           // number of publish requests throttled before publishing succeeds
+	ThrottleRetryAfter  time.Duration // retry delay of the throttle errors
+	DeleteError         bool          // subscription deletion fails with 500
+	CreateFailures      int           // number of subscription creations that fail with 503 first
+	DeleteFailures      int           // number of subscription deletions that fail with 503 first
+	SubscriptionsDelay  time.Duration // delay before responding to the subscriptions requests
+	ResponseHeaders     http.Header   // headers added to every HTTP response
+	// PublishOrder is the order in which the payloads are expected to be published to each
+	// stream, indexed by stream. The test fails when a payload is published out of order.
+	PublishOrder map[string][]string
+	// ValidAuthToken validates the X-Auth-Token header of every request if set, requests with an
+	// invalid token are rejected with 401 Unauthorized.
+	ValidAuthToken func(token string) bool
+	// OnRequest is invoked with every HTTP request before it's handled if set
+	OnRequest func(r *http.Request)
+	// RejectRequest fails the HTTP requests for which it returns true with 503 if set
+	RejectRequest func(r *http.Request) bool
+	// OpenConns is kept up to date with the number of open WebSocket connections if set
+	OpenConns *int32
+	// State holds the streams and subscriptions of the server. The servers without State share the
+	// same streams and subscriptions.
+	State *State
 }
 
 type sub struct {
 	stream string
 	id     string
-	params []rpc2.PublishParams
+	req    SubscriptionRequest
+	offset int   // offset in the stream log of the next message to consume without consume context
+	grants []int // credits granted by the consume requests that limit the number of messages
+}
+
+// SubscriptionRequest is the body of a subscription creation request
+type SubscriptionRequest struct {
+	GroupID     string   `json:"groupId"`
+	Streams     []string `json:"streams"`
+	Prefetch    int      `json:"prefetch"`
+	OffsetReset string   `json:"offsetReset"`
+
+	SupportedCompressions []string `json:"supportedCompressions"`
 }
 
 func (s *sub) String() string {
-	return fmt.Sprintf("sub{stream:%s, id:%s, params:%+v}", s.stream, s.id, s.params)
+	return fmt.Sprintf("sub{stream:%s, id:%s, offset:%d}", s.stream, s.id, s.offset)
+}
+
+// State holds the streams and subscriptions of servers
+type State struct {
+	subs       map[string]*sub
+	streams    map[string][]rpc2.PublishParams // log of all the messages published to each stream
+	timestamps map[string][]int64              // publish time in Unix milliseconds of each message of the stream log, 0 if unknown
+	mu         sync.Mutex
+}
+
+// NewState returns a State without streams and subscriptions
+func NewState() *State {
+	return &State{
+		subs:       map[string]*sub{},
+		streams:    map[string][]rpc2.PublishParams{},
+		timestamps: map[string][]int64{},
+	}
 }
 
-var subs = map[string]*sub{}
-var subsMu = sync.Mutex{}
+// defaultState is shared by all the servers created without Config.State
+var defaultState = NewState()
 
 // NewRPCServer creates and starts a test HTTP server that talks RPC
-func NewRPCServer(t *testing.T, cfg Config) *httptest.Server {
+func NewRPCServer(t testing.TB, cfg Config) *httptest.Server {
 	r := chi.NewRouter()
+	st := cfg.State
+	if st == nil {
+		st = defaultState
+	}
+	publishFailures := cfg.PublishFailures
+	publishThrottles := cfg.PublishThrottles
+	publishDrops := cfg.PublishDrops
+	createFailures, deleteFailures := cfg.CreateFailures, cfg.DeleteFailures
+	consumeFailures := cfg.ConsumeFailures
+	failuresMu := sync.Mutex{}
+	// fail consumes one of the failures and returns true if there was one left
+	fail := func(failures *int) bool {
+		failuresMu.Lock()
+		defer failuresMu.Unlock()
+		if *failures <= 0 {
+			return false
+		}
+		*failures--
+		return true
+	}
+	conns := 0
+	connsMu := sync.Mutex{}
+	publishOrder := newOrderVerifiers(t, cfg.PublishOrder)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range cfg.ResponseHeaders {
+				w.Header()[k] = v
+			}
+			if cfg.OnRequest != nil {
+				cfg.OnRequest(r)
+			}
+			if cfg.RejectRequest != nil && cfg.RejectRequest(r) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if cfg.ValidAuthToken != nil && !cfg.ValidAuthToken(r.Header.Get("X-Auth-Token")) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
 
 	// pubsub
 	r.Get(cfg.PubSubPath, func(w http.ResponseWriter, r *http.Request) {
-		if cfg.RejectConn {
+		connsMu.Lock()
+		conns++
+		reject := cfg.RejectConn || (cfg.RejectReconnect && conns > 1)
+		connsMu.Unlock()
+		if reject {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		c, err := websocket.Accept(w, r, nil)
+		var acceptOpts *websocket.AcceptOptions
+		if cfg.ProtocolVersion > 0 {
+			acceptOpts = &websocket.AcceptOptions{
+				Subprotocols: []string{"dxhub.pubsub.v" + strconv.Itoa(cfg.ProtocolVersion)},
+			}
+		}
+		c, err := websocket.Accept(w, r, acceptOpts)
 		assert.NoError(t, err)
+		// protocol version 2 frames the RPC messages as binary messages
+		expectedType := websocket.MessageText
+		if c.Subprotocol() == "dxhub.pubsub.v2" {
+			expectedType = websocket.MessageBinary
+		}
 
 		defer c.Close(websocket.StatusNormalClosure, "")
+		if cfg.OpenConns != nil {
+			atomic.AddInt32(cfg.OpenConns, 1)
+			defer atomic.AddInt32(cfg.OpenConns, -1)
+		}
 		ctx := context.Background()
 		for {
 			mt, payload, err := c.Read(ctx)
 			if err != nil {
-				if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				// the client closes with a protocol error if it doesn't support the protocol version
+				// and with going away when it's aborted
+				switch websocket.CloseStatus(err) {
+				case websocket.StatusNormalClosure, websocket.StatusProtocolError, websocket.StatusGoingAway:
+				default:
 					t.Errorf("Read error: %v", err)
 				}
 				break
 			}
 
+			assert.Equal(t, expectedType, mt, "unexpected message type")
 			req, err := rpc2.NewRequestFromBytes(payload)
 			assert.NoError(t, err)
 
@@ -74,77 +212,144 @@ func NewRPCServer(t *testing.T, cfg Config) *httptest.Server {
 				resp = rpc2.NewControlResponse(req.ID, true, rpc2.Error{})
 			case rpc2.MethodPublish:
 				params, _ := req.PublishParams()
-				if cfg.PublishError {
+				if cfg.PublishDrop || fail(&publishDrops) {
+					break
+				}
+				if publishThrottles > 0 {
+					publishThrottles--
+					resp = rpc2.NewThrottleResponse(req.ID, cfg.ThrottleRetryAfter.Milliseconds())
+				} else if cfg.PublishError || publishFailures > 0 {
+					publishFailures--
 					resp = rpc2.NewErrorResponse(req.ID, fmt.Errorf("Publish Error"))
 				} else {
+					st.mu.Lock()
 					for _, p := range params {
-						subsMu.Lock()
-						s := subs[p.Stream]
-						subsMu.Unlock()
-						if s != nil {
-							s.params = append(s.params, *p)
+						if v := publishOrder[p.Stream]; v != nil {
+							payload, _ := base64.StdEncoding.DecodeString(p.Payload)
+							v.Record(string(payload))
 						}
+						st.appendLog(p.Stream, time.Now().UnixNano()/int64(time.Millisecond), *p)
 					}
-					resp = rpc2.NewPublishResponse(req.ID, params[0].MsgID, nil)
+					// the offset is the position of the message in the stream log, there's a single partition
+					offset := int64(len(st.streams[params[0].Stream]) - 1)
+					st.mu.Unlock()
+					resp = rpc2.NewPublishResultResponse(req.ID, rpc2.PublishResult{
+						Method:    rpc2.MethodPublish,
+						MsgID:     params[0].MsgID,
+						Status:    rpc2.ResultStatusSuccess,
+						Offset:    offset,
+						Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+					})
 				}
 			case rpc2.MethodConsume:
 				params, _ := req.ConsumeParams()
-				if cfg.ConsumeError {
-					resp = rpc2.NewErrorResponse(req.ID, fmt.Errorf("Consume Error"))
+				if cfg.ConsumeError || fail(&consumeFailures) {
+					resp = consumeError(req.ID, cfg.ConsumeErrorCode, cfg.ThrottleRetryAfter)
 				} else if cfg.ConsumeDrop {
 					resp = nil
-				} else {
-					subsMu.Lock()
-					for stream, sub := range subs {
-						if sub.id != params.SubscriptionID {
-							continue
-						}
-						msgs := make([]rpc2.ConsumeMessage, 0)
-						for _, p := range sub.params {
-							if p.MsgID == "" {
-								continue
+				} else if cfg.ConsumeDelay > 0 {
+					// respond asynchronously, messages published during the delay are included
+					go func(id string) {
+						time.Sleep(cfg.ConsumeDelay)
+						if r := st.consume(id, params, cfg.ConsumeShuffle); r != nil {
+							if err := c.Write(ctx, mt, r.Bytes()); err != nil {
+								t.Logf("Failed to write delayed consume response: %v", err)
 							}
-							msgs = append(msgs, rpc2.ConsumeMessage{
-								MsgID:   p.MsgID,
-								Payload: p.Payload,
-								Headers: p.Headers,
-							})
 						}
-						// reset the params slice
-						sub.params = make([]rpc2.PublishParams, 0)
-						resp = rpc2.NewConsumeResponse(req.ID, "", sub.id, stream, msgs)
-					}
-					subsMu.Unlock()
+					}(req.ID)
+				} else if st.consumeFails(params, cfg.ConsumeErrorStreams) {
+					resp = consumeError(req.ID, cfg.ConsumeErrorCode, cfg.ThrottleRetryAfter)
+				} else {
+					resp = st.consume(req.ID, params, cfg.ConsumeShuffle)
 				}
 			}
 			if resp != nil {
 				err = c.Write(ctx, mt, resp.Bytes())
 				assert.NoError(t, err)
+				if cfg.ConsumeDuplicate && req.Method == rpc2.MethodConsume {
+					err = c.Write(ctx, mt, resp.Bytes())
+					assert.NoError(t, err)
+				}
 			}
 		}
 	})
 
+	// streams
+	if cfg.StreamsPath != "" {
+		r.Get(cfg.StreamsPath, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			type stream struct {
+				Name string `json:"name"`
+			}
+			resp := []stream{}
+			st.mu.Lock()
+			for name := range st.streams {
+				resp = append(resp, stream{Name: name})
+			}
+			st.mu.Unlock()
+			err := json.NewEncoder(w).Encode(resp)
+			assert.NoError(t, err)
+		})
+		r.Get(cfg.StreamsPath+"/{stream}", func(w http.ResponseWriter, r *http.Request) {
+			st.mu.Lock()
+			msgs, ok := st.streams[chi.URLParam(r, "stream")]
+			st.mu.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			resp := struct {
+				Name         string `json:"name"`
+				LatestOffset int64  `json:"latestOffset"`
+			}{chi.URLParam(r, "stream"), int64(len(msgs) - 1)}
+			err := json.NewEncoder(w).Encode(resp)
+			assert.NoError(t, err)
+		})
+	}
+
 	// subscriptions
 	r.Route(cfg.SubscriptionsPath, func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if cfg.SubscriptionsDelay > 0 {
+					select {
+					case <-time.After(cfg.SubscriptionsDelay):
+					case <-r.Context().Done():
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+
 		// new subscription
 		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			if fail(&createFailures) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			body, err := io.ReadAll(r.Body)
 			assert.NoError(t, err)
 
-			var req struct {
-				GroupID string   `json:"group_id"`
-				Streams []string `json:"streams"`
-			}
+			var req SubscriptionRequest
 			_ = json.Unmarshal(body, &req)
 			t.Logf("Received new subscription request: %+v", req)
 			id := uuid.NewString()
-			subsMu.Lock()
-			subs[req.Streams[0]] = &sub{
+			st.mu.Lock()
+			// new subscriptions start with the messages published after them unless reset to the earliest
+			offset := len(st.streams[req.Streams[0]])
+			if req.OffsetReset == "earliest" {
+				offset = 0
+			}
+			st.subs[req.Streams[0]] = &sub{
 				stream: req.Streams[0],
 				id:     id,
+				req:    req,
+				offset: offset,
 			}
-			subsMu.Unlock()
+			st.mu.Unlock()
 
 			resp := struct {
 				ID string `json:"_id"`
@@ -154,21 +359,265 @@ func NewRPCServer(t *testing.T, cfg Config) *httptest.Server {
 			assert.NoError(t, err)
 		})
 
+		// get subscription
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if !st.HasSubscription(chi.URLParam(r, "id")) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
 		// delete subscription
 		r.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) {
 			id := chi.URLParam(r, "id")
 			t.Logf("Got delete subscription request: %v", id)
+			if cfg.DeleteError {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if fail(&deleteFailures) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
 
-			subsMu.Lock()
-			for stream, s := range subs {
+			st.mu.Lock()
+			for stream, s := range st.subs {
 				if id == s.id {
-					delete(subs, stream)
+					delete(st.subs, stream)
 				}
 			}
-			subsMu.Unlock()
+			st.mu.Unlock()
 			w.WriteHeader(http.StatusNoContent)
 		})
 	})
 
-	return httptest.NewTLSServer(r)
+	if cfg.PlainHTTP {
+		return httptest.NewServer(r)
+	}
+	if cfg.ClientCAs == nil {
+		return httptest.NewTLSServer(r)
+	}
+	s := httptest.NewUnstartedServer(r)
+	s.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  cfg.ClientCAs,
+	}
+	s.StartTLS()
+	return s
+}
+
+// consume returns the consume response with the messages published to the subscription since the
+// consume context of the request, or since the last consume if the request has no consume context.
+// The messages are in random order if shuffle is set.
+func (st *State) consume(id string, params *rpc2.ConsumeParams, shuffle bool) *rpc2.Response {
+	var resp *rpc2.Response
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for stream, sub := range st.subs {
+		if sub.id != params.SubscriptionID {
+			continue
+		}
+		offset := sub.offset
+		if offset > len(st.streams[stream]) {
+			// the stream was deleted
+			offset = len(st.streams[stream])
+		}
+		if params.ConsumeContext != "" {
+			if o, err := strconv.Atoi(params.ConsumeContext); err == nil && o >= 0 && o <= len(st.streams[stream]) {
+				offset = o
+			}
+		}
+		if params.Credits > 0 {
+			sub.grants = append(sub.grants, params.Credits)
+		}
+		msgs := make([]rpc2.ConsumeMessage, 0)
+		end := offset
+		for i, p := range st.streams[stream][offset:] {
+			if params.Credits > 0 && len(msgs) == params.Credits {
+				break
+			}
+			end++
+			if p.MsgID == "" {
+				continue
+			}
+			o := int64(offset + i)
+			msgs = append(msgs, rpc2.ConsumeMessage{
+				MsgID:     p.MsgID,
+				Payload:   p.Payload,
+				Headers:   p.Headers,
+				Timestamp: st.timestamps[stream][offset+i],
+				Offset:    &o,
+			})
+		}
+		if shuffle {
+			rand.Shuffle(len(msgs), func(i, j int) { msgs[i], msgs[j] = msgs[j], msgs[i] })
+		}
+		sub.offset = end
+		resp = rpc2.NewConsumeResponse(id, strconv.Itoa(sub.offset), sub.id, stream, msgs)
+	}
+	return resp
+}
+
+// consumeError returns the error response to a consume request with the code, -32099 if 0. The
+// throttle errors carry the retry delay.
+func consumeError(id string, code int, retryAfter time.Duration) *rpc2.Response {
+	if code == rpc2.CodeThrottled {
+		return rpc2.NewThrottleResponse(id, retryAfter.Milliseconds())
+	}
+	resp := rpc2.NewErrorResponse(id, fmt.Errorf("Consume Error"))
+	if code != 0 {
+		resp.Error.Code = code
+	}
+	return resp
+}
+
+// consumeFails returns true if the consume request is for one of the failing streams
+func (st *State) consumeFails(params *rpc2.ConsumeParams, streams []string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, stream := range streams {
+		if s := st.subs[stream]; s != nil && s.id == params.SubscriptionID {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishRaw adds messages to the subscription of the stream as if they were published, the
+// payloads are sent to the consumer as is. It returns false if the stream isn't subscribed.
+func (st *State) PublishRaw(stream string, msgs ...rpc2.PublishParams) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.subs[stream]
+	if s == nil {
+		return false
+	}
+	st.appendLog(stream, 0, msgs...)
+	return true
+}
+
+// PublishRawAt adds the messages to the stream log like PublishRaw, as if the server accepted them
+// at the supplied time
+func (st *State) PublishRawAt(stream string, at time.Time, msgs ...rpc2.PublishParams) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.subs[stream]
+	if s == nil {
+		return false
+	}
+	st.appendLog(stream, at.UnixNano()/int64(time.Millisecond), msgs...)
+	return true
+}
+
+// appendLog appends the messages to the stream log with their publish time. st.mu must be held.
+func (st *State) appendLog(stream string, timestamp int64, msgs ...rpc2.PublishParams) {
+	st.streams[stream] = append(st.streams[stream], msgs...)
+	for range msgs {
+		st.timestamps[stream] = append(st.timestamps[stream], timestamp)
+	}
+}
+
+// CreateStream adds the stream to the stream log if it doesn't exist yet
+func (st *State) CreateStream(stream string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.streams[stream]; !ok {
+		st.streams[stream] = []rpc2.PublishParams{}
+	}
+}
+
+// DeleteStream removes the stream and its messages from the stream log
+func (st *State) DeleteStream(stream string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.streams, stream)
+	delete(st.timestamps, stream)
+}
+
+// GetSubscriptionRequest returns the request the subscription of the stream was created with. It
+// returns false if the stream isn't subscribed.
+func (st *State) GetSubscriptionRequest(stream string) (SubscriptionRequest, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.subs[stream]
+	if s == nil {
+		return SubscriptionRequest{}, false
+	}
+	return s.req, true
+}
+
+// ExpireSubscription removes the subscription with the supplied ID as if it expired on the server
+func (st *State) ExpireSubscription(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for stream, s := range st.subs {
+		if s.id == id {
+			delete(st.subs, stream)
+		}
+	}
+}
+
+// ConsumeCredits returns the credits granted by the consume requests of the subscription of the
+// stream, in order
+func (st *State) ConsumeCredits(stream string) []int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.subs[stream]
+	if s == nil {
+		return nil
+	}
+	return append([]int(nil), s.grants...)
+}
+
+// HasSubscription returns true if the subscription with the supplied ID exists on the server
+func (st *State) HasSubscription(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, s := range st.subs {
+		if s.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishRaw is State.PublishRaw for the servers without Config.State
+func PublishRaw(stream string, msgs ...rpc2.PublishParams) bool {
+	return defaultState.PublishRaw(stream, msgs...)
+}
+
+// PublishRawAt is State.PublishRawAt for the servers without Config.State
+func PublishRawAt(stream string, at time.Time, msgs ...rpc2.PublishParams) bool {
+	return defaultState.PublishRawAt(stream, at, msgs...)
+}
+
+// CreateStream is State.CreateStream for the servers without Config.State
+func CreateStream(stream string) {
+	defaultState.CreateStream(stream)
+}
+
+// DeleteStream is State.DeleteStream for the servers without Config.State
+func DeleteStream(stream string) {
+	defaultState.DeleteStream(stream)
+}
+
+// GetSubscriptionRequest is State.GetSubscriptionRequest for the servers without Config.State
+func GetSubscriptionRequest(stream string) (SubscriptionRequest, bool) {
+	return defaultState.GetSubscriptionRequest(stream)
+}
+
+// ExpireSubscription is State.ExpireSubscription for the servers without Config.State
+func ExpireSubscription(id string) {
+	defaultState.ExpireSubscription(id)
+}
+
+// ConsumeCredits is State.ConsumeCredits for the servers without Config.State
+func ConsumeCredits(stream string) []int {
+	return defaultState.ConsumeCredits(stream)
+}
+
+// HasSubscription is State.HasSubscription for the servers without Config.State
+func HasSubscription(id string) bool {
+	return defaultState.HasSubscription(id)
 }
@@ -0,0 +1,62 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	json "github.com/goccy/go-json"
+)
+
+// ThrottleState describes the throttling of the publish and consume requests by the server
+type ThrottleState struct {
+	Throttled  bool          // true until RetryAfter elapsed since the last throttle error
+	LastAt     time.Time     // time of the last throttle error, zero if none
+	RetryAfter time.Duration // delay before retrying requested by the last throttle error
+	Count      int64         // number of throttle errors
+}
+
+// throttle holds the throttle errors received by the connection
+type throttle struct {
+	lastAt     time.Time
+	retryAfter time.Duration
+	count      int64
+	sync.Mutex
+}
+
+// newRPCError returns the *RPCError of an RPC error response, recording the throttle errors
+func (c *internalConnection) newRPCError(e rpc.Error) *RPCError {
+	err := &RPCError{Code: e.Code, Message: e.Message}
+	if !err.Throttled() {
+		return err
+	}
+	var data rpc.ThrottleData
+	if len(e.Data) > 0 {
+		if jsonErr := json.Unmarshal(e.Data, &data); jsonErr != nil {
+			c.logger().Warnf("Invalid throttle error data %s: %v", e.Data, jsonErr)
+		}
+	}
+	err.RetryAfter = time.Duration(data.RetryAfter) * time.Millisecond
+
+	c.throttle.Lock()
+	c.throttle.lastAt = time.Now()
+	c.throttle.retryAfter = err.RetryAfter
+	c.throttle.count++
+	c.throttle.Unlock()
+	return err
+}
+
+// ThrottleState returns the state of the throttling of the publish and consume requests by the server
+func (c *internalConnection) ThrottleState() ThrottleState {
+	c.throttle.Lock()
+	defer c.throttle.Unlock()
+	return ThrottleState{
+		Throttled:  !c.throttle.lastAt.IsZero() && time.Since(c.throttle.lastAt) < c.throttle.retryAfter,
+		LastAt:     c.throttle.lastAt,
+		RetryAfter: c.throttle.retryAfter,
+		Count:      c.throttle.count,
+	}
+}
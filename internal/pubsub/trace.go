@@ -0,0 +1,49 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+import "context"
+
+// Propagator propagates the trace context from the publisher to the subscriber through the
+// message headers, e.g. the W3C traceparent and tracestate headers. It keeps the SDK free of a
+// tracing dependency, an OpenTelemetry adapter wraps a propagation.TextMapPropagator using
+// propagation.MapCarrier(headers) as the carrier and a trace.Tracer to start the span.
+type Propagator interface {
+	// Inject adds the trace context carried by ctx to the headers
+	Inject(ctx context.Context, headers map[string]string)
+
+	// Extract returns a copy of ctx with the trace context found in the headers
+	Extract(ctx context.Context, headers map[string]string) context.Context
+
+	// StartConsumeSpan starts the span for delivering a message to the callback. ctx carries the
+	// trace context extracted from the message, the span is expected to be linked to it. The
+	// returned function ends the span once the callback returns.
+	StartConsumeSpan(ctx context.Context, stream string, msgID string) (context.Context, func())
+}
+
+// injectTrace returns a copy of headers with the trace context of ctx added. headers is returned
+// as is if there's no Propagator.
+func (c *internalConnection) injectTrace(ctx context.Context, headers map[string]string) map[string]string {
+	if c.config.Propagator == nil {
+		return headers
+	}
+	h := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		h[k] = v
+	}
+	c.config.Propagator.Inject(ctx, h)
+	return h
+}
+
+// startConsumeSpan extracts the trace context of the message and starts the consume span. The
+// returned function must be invoked once the message was delivered.
+func (sub *subscription) startConsumeSpan(m *Message) func() {
+	if sub.propagator == nil {
+		return func() {}
+	}
+	ctx := sub.propagator.Extract(sub.ctx, m.Headers)
+	ctx, end := sub.propagator.StartConsumeSpan(ctx, sub.stream, m.ID)
+	m.ctx = ctx
+	return end
+}
@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+type traceKey struct{}
+
+type testPropagator struct {
+	spans []string
+	ended int
+	sync.Mutex
+}
+
+func (p *testPropagator) Inject(ctx context.Context, headers map[string]string) {
+	if tp, ok := ctx.Value(traceKey{}).(string); ok {
+		headers["traceparent"] = tp
+	}
+}
+
+func (p *testPropagator) Extract(ctx context.Context, headers map[string]string) context.Context {
+	if tp, ok := headers["traceparent"]; ok {
+		return context.WithValue(ctx, traceKey{}, tp)
+	}
+	return ctx
+}
+
+func (p *testPropagator) StartConsumeSpan(ctx context.Context, stream string, msgID string) (context.Context, func()) {
+	p.Lock()
+	defer p.Unlock()
+	tp, _ := ctx.Value(traceKey{}).(string)
+	p.spans = append(p.spans, stream+":"+tp)
+	return ctx, func() {
+		p.Lock()
+		defer p.Unlock()
+		p.ended++
+	}
+}
+
+func Test_TracePropagation(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	propagator := &testPropagator{}
+	c := newTestConnection(t, s, Config{
+		PollInterval: 10 * time.Millisecond,
+		Propagator:   propagator,
+	})
+	defer c.disconnect()
+
+	type received struct {
+		headers map[string]string
+		trace   interface{}
+	}
+	receivedCh := make(chan received, 2)
+	_, err := c.subscribeWithAck("test-stream", "", func(err error, msg *Message) {
+		require.NoError(t, err)
+		receivedCh <- received{headers: msg.Headers, trace: msg.Context().Value(traceKey{})}
+	})
+	require.NoError(t, err)
+
+	// nil headers
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Publish(context.WithValue(ctx, traceKey{}, "00-trace-1-01"), "test-stream", nil, []byte("test payload"))
+	require.NoError(t, err)
+	r := <-receivedCh
+	require.Equal(t, "00-trace-1-01", r.headers["traceparent"])
+	require.Equal(t, "00-trace-1-01", r.trace)
+
+	// the supplied headers aren't modified
+	headers := map[string]string{"key": "value"}
+	_, err = c.Publish(context.WithValue(ctx, traceKey{}, "00-trace-2-01"), "test-stream", headers, []byte("test payload"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key": "value"}, headers)
+	r = <-receivedCh
+	require.Equal(t, map[string]string{"key": "value", "traceparent": "00-trace-2-01"}, r.headers)
+	require.Equal(t, "00-trace-2-01", r.trace)
+
+	require.Eventually(t, func() bool {
+		propagator.Lock()
+		defer propagator.Unlock()
+		return propagator.ended == 2
+	}, time.Second, 10*time.Millisecond)
+	propagator.Lock()
+	defer propagator.Unlock()
+	require.Equal(t, []string{"test-stream:00-trace-1-01", "test-stream:00-trace-2-01"}, propagator.spans)
+}
+
+func Test_TracePropagationDisabled(t *testing.T) {
+	c := &internalConnection{}
+	headers := map[string]string{"key": "value"}
+	require.Equal(t, headers, c.injectTrace(context.WithValue(context.Background(), traceKey{}, "00-trace-1-01"), headers))
+	require.Nil(t, c.injectTrace(context.Background(), nil))
+}
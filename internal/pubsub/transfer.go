@@ -0,0 +1,104 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+package pubsub
+
+// handOff stops consuming from the stream once the response to the consume request in flight is
+// delivered and removes the subscription without deleting it on the server. The returned
+// subscription holds the consume context to resume from.
+func (c *internalConnection) handOff(stream string) (*subscription, error) {
+	c.subs.Lock()
+	sub, ok := c.subs.table[stream]
+	c.subs.Unlock()
+	if !ok {
+		return nil, &SubscriptionError{Stream: stream, Err: ErrSubscriptionNotFound}
+	}
+	sub.stopConsuming()
+	sub.wg.Wait()
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	if err := c.unsubscribeWithoutLock(c.ctx, stream, false); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// takeOver adds a subscription with the callbacks and options of sub that resumes from its consume
+// context. subscriptionID is reused if set, otherwise a new subscription is created.
+func (c *internalConnection) takeOver(sub *subscription, subscriptionID string) (string, error) {
+	resumed := &subscription{callback: sub.callback, ackCallback: sub.ackCallback, opts: sub.opts}
+	sub.stats.Lock()
+	resumed.stats.consumeCtx = sub.stats.consumeCtx
+	sub.stats.Unlock()
+	return c.addSubscription(c.ctx, sub.stream, subscriptionID, resumed)
+}
+
+// TransferSubscriptions hands the subscriptions of c over to the connection to without a gap, e.g.
+// to replace the connection in process. Each subscription stops consuming on c once the messages in
+// flight are delivered, is re-created on to from the same consume context and is then deleted on
+// the server. A subscription that can't be re-created on to keeps consuming on c. The failures are
+// returned in a *MultiError that lists the streams that weren't transferred. The contexts returned
+// by SubscribeContext end with the transfer.
+func (c *Connection) TransferSubscriptions(to *Connection) error {
+	conn, release, err := c.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+	target, releaseTarget, err := to.use()
+	if err != nil {
+		return err
+	}
+	defer releaseTarget()
+
+	c.mu.Lock()
+	subs := make([]subscriptionParams, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, params := range subs {
+		if err := to.checkOverlap(params.stream); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sub, err := conn.handOff(params.stream)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		id, err := target.takeOver(sub, "")
+		if err != nil {
+			c.logger().Errorf("Failed to transfer subscription for stream %s: %v", params.stream, err)
+			errs = append(errs, err)
+			// resume consuming on c
+			if _, err := conn.takeOver(sub, sub.id); err != nil {
+				c.logger().Errorf("Failed to resume subscription for stream %s: %v", params.stream, err)
+				c.forget([]string{params.stream})
+			}
+			continue
+		}
+		to.mu.Lock()
+		to.subscriptions[params.stream] = subscriptionParams{
+			stream:         params.stream,
+			subscriptionID: id,
+			handler:        params.handler,
+			ackHandler:     params.ackHandler,
+			opts:           params.opts,
+		}
+		to.mu.Unlock()
+		c.forget([]string{params.stream})
+		if sub.id != "" {
+			if err := conn.deleteSubscription(conn.ctx, sub.id); err != nil {
+				c.logger().Warnf("Failed to delete transferred subscription %s for stream %s: %v", sub.id, params.stream, err)
+			}
+		}
+		c.logger().Infof("Transferred subscription for stream %s to %v", params.stream, to)
+	}
+	if len(errs) > 0 {
+		return &MultiError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TransferSubscriptions(t *testing.T) {
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:        apiPaths.pubsub,
+		SubscriptionsPath: apiPaths.subscriptions,
+	})
+	defer s.Close()
+
+	from := newTestPublicConnection(t, s, Config{PollInterval: 10 * time.Millisecond})
+	defer from.Disconnect()
+	to := newTestPublicConnection(t, s, Config{
+		GroupID:      "test-client-2",
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer to.Disconnect()
+
+	received := make(chan string, 10)
+	err := from.Subscribe("test-stream", func(err error, _ string, _ map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- string(payload)
+	})
+	require.NoError(t, err)
+	oldID := from.Subscriptions()[0].ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = from.Publish(ctx, "test-stream", nil, []byte("before"))
+	require.NoError(t, err)
+	require.NoError(t, from.WaitForConsumed(ctx, "test-stream", 1))
+
+	require.NoError(t, from.TransferSubscriptions(to))
+	require.Empty(t, from.Subscriptions())
+	require.Len(t, to.Subscriptions(), 1)
+	require.False(t, test.HasSubscription(oldID))
+
+	// consumption continues on the target connection, each message is delivered once
+	_, err = from.Publish(ctx, "test-stream", nil, []byte("after"))
+	require.NoError(t, err)
+	require.NoError(t, to.WaitForConsumed(ctx, "test-stream", 1))
+	require.Equal(t, "before", <-received)
+	require.Equal(t, "after", <-received)
+	select {
+	case payload := <-received:
+		t.Fatalf("unexpected message %s", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the subscription is restored if the target rejects it
+	require.NoError(t, from.Subscribe("other-stream", func(error, string, map[string]string, []byte) {}))
+	err = from.TransferSubscriptions(to)
+	require.NoError(t, err)
+	require.NoError(t, from.Subscribe("test-stream", func(error, string, map[string]string, []byte) {}))
+	err = from.TransferSubscriptions(to)
+	var multiErr *MultiError
+	require.ErrorAs(t, err, &multiErr)
+	require.Equal(t, []string{"test-stream"}, multiErr.Streams())
+	require.Len(t, from.Subscriptions(), 1)
+}
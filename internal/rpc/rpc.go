@@ -44,6 +44,7 @@ type controlParams struct {
 type ConsumeParams struct {
 	SubscriptionID string `json:"subscriptionId"`
 	ConsumeContext string `json:"consumeContext"`
+	Credits        int    `json:"credits,omitempty"` // maximum number of messages in the response, 0 for no limit
 }
 
 // PublishParams represents the params of a publish request
@@ -92,9 +93,16 @@ func NewCloseRequest(clientId string) (*Request, error) {
 
 // NewConsumeRequest creates and returns a new consume request
 func NewConsumeRequest(subID, consumeCtx string) (*Request, error) {
+	return NewConsumeRequestWithCredits(subID, consumeCtx, 0)
+}
+
+// NewConsumeRequestWithCredits creates and returns a new consume request that grants the server
+// credits messages. 0 grants an unlimited number of messages.
+func NewConsumeRequestWithCredits(subID, consumeCtx string, credits int) (*Request, error) {
 	c := ConsumeParams{
 		SubscriptionID: subID,
 		ConsumeContext: consumeCtx,
+		Credits:        credits,
 	}
 	return newRequest(MethodConsume, c)
 }
@@ -162,9 +170,12 @@ type ControlResult struct {
 
 // ConsumeMessage represents a message received from the server in a consume response
 type ConsumeMessage struct {
-	MsgID   string            `json:"msgId"`
-	Payload string            `json:"payload"`
-	Headers map[string]string `json:"headers"`
+	MsgID     string            `json:"msgId"`
+	Payload   string            `json:"payload"`
+	Headers   map[string]string `json:"headers"`
+	Timestamp int64             `json:"timestamp,omitempty"` // time the server accepted the message, in Unix milliseconds
+
+	Offset *int64 `json:"offset,omitempty"` // offset of the message in the partition, nil if not reported
 }
 
 // ConsumeResult represents the result of a consume request
@@ -176,9 +187,12 @@ type ConsumeResult struct {
 
 // PublishResult represents the result of a publish request
 type PublishResult struct {
-	Status string `json:"status"`
-	MsgID  string `json:"msgId"`
-	Method Method `json:"method"`
+	Status    string `json:"status"`
+	MsgID     string `json:"msgId"`
+	Method    Method `json:"method"`
+	Partition int    `json:"partition,omitempty"` // partition of the stream the message was assigned to
+	Offset    int64  `json:"offset,omitempty"`    // offset of the message in the partition
+	Timestamp int64  `json:"timestamp,omitempty"` // time the server accepted the message, in Unix milliseconds
 }
 
 // NewResponseFromBytes creates a new Response from supplied []byte
@@ -238,11 +252,15 @@ func NewPublishResponse(id, msgID string, err error) *Response {
 		return NewErrorResponse(id, err)
 	}
 
-	result := PublishResult{
+	return NewPublishResultResponse(id, PublishResult{
 		Method: MethodPublish,
 		MsgID:  msgID,
 		Status: ResultStatusSuccess,
-	}
+	})
+}
+
+// NewPublishResultResponse creates a new publish response with the supplied result
+func NewPublishResultResponse(id string, result PublishResult) *Response {
 	b, _ := json.Marshal(&result)
 	resp := &Response{
 		Version: jsonRPCVersion,
@@ -252,6 +270,30 @@ func NewPublishResponse(id, msgID string, err error) *Response {
 	return resp
 }
 
+// CodeThrottled is the code of the error with which the server rejects a request of a client that
+// exceeds its rate. The data of the error is a ThrottleData.
+const CodeThrottled = -32029
+
+// ThrottleData is the data of a CodeThrottled error
+type ThrottleData struct {
+	RetryAfter int64 `json:"retryAfter,omitempty"` // delay before retrying in milliseconds, 0 if unknown
+}
+
+// NewThrottleResponse creates and returns a new CodeThrottled error response asking to retry after
+// retryAfter milliseconds
+func NewThrottleResponse(id string, retryAfter int64) *Response {
+	data, _ := json.Marshal(&ThrottleData{RetryAfter: retryAfter})
+	return &Response{
+		Version: jsonRPCVersion,
+		ID:      id,
+		Error: Error{
+			Code:    CodeThrottled,
+			Message: "throttled",
+			Data:    data,
+		},
+	}
+}
+
 // NewErrorResponse creates and returns a new Error response
 func NewErrorResponse(id string, err error) *Response {
 	return &Response{
@@ -30,7 +30,8 @@ type (
 	LogLevel int
 	// DefaultLogger implements the default logger used by the SDK
 	DefaultLogger struct {
-		Level LogLevel
+		Level  LogLevel
+		prefix string
 	}
 )
 
@@ -87,6 +88,43 @@ func getFileline() string {
 func (d *DefaultLogger) printf(level string, format string, args ...interface{}) {
 	t := time.Now().UTC().Format(time.RFC3339)
 	fileinfo := getFileline()
-	arr := append([]interface{}{t, level, fileinfo}, args...)
-	log.Printf("%s %-6s %s "+format, arr...)
+	// the prefix may contain %, it's not part of the format
+	arr := append([]interface{}{t, level, fileinfo, d.prefix}, args...)
+	log.Printf("%s %-6s %s %s"+format, arr...)
+}
+
+// prefixLogger adds a prefix to the messages of another logger
+type prefixLogger struct {
+	logger SDKLogger
+	prefix string
+}
+
+// WithPrefix returns a logger that adds the prefix to every message logged with logger
+func WithPrefix(logger SDKLogger, prefix string) SDKLogger {
+	if d, ok := logger.(*DefaultLogger); ok {
+		// a copy of the DefaultLogger keeps the file and line of the caller
+		return &DefaultLogger{Level: d.Level, prefix: d.prefix + prefix}
+	}
+	return &prefixLogger{logger: logger, prefix: prefix}
+}
+
+// prefixed returns the args with the prefix first, for the format prefixed with %s
+func (p *prefixLogger) prefixed(args []interface{}) []interface{} {
+	return append([]interface{}{p.prefix}, args...)
+}
+
+func (p *prefixLogger) Infof(format string, args ...interface{}) {
+	p.logger.Infof("%s"+format, p.prefixed(args)...)
+}
+
+func (p *prefixLogger) Errorf(format string, args ...interface{}) {
+	p.logger.Errorf("%s"+format, p.prefixed(args)...)
+}
+
+func (p *prefixLogger) Warnf(format string, args ...interface{}) {
+	p.logger.Warnf("%s"+format, p.prefixed(args)...)
+}
+
+func (p *prefixLogger) Debugf(format string, args ...interface{}) {
+	p.logger.Debugf("%s"+format, p.prefixed(args)...)
 }
@@ -0,0 +1,54 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (r *recordingLogger) Infof(format string, args ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Errorf(format string, args ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Warnf(format string, args ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func Test_WithPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// the prefix isn't interpreted as a format
+	WithPrefix(&DefaultLogger{}, "[group-%d] ").Infof("value %d", 1)
+	require.Contains(t, buf.String(), "[group-%d] value 1\n")
+
+	r := &recordingLogger{}
+	l := WithPrefix(r, "[group-%s] ")
+	l.Infof("value %d", 1)
+	l.Errorf("value %d", 2)
+	l.Warnf("value %d", 3)
+	l.Debugf("value %d", 4)
+	require.Equal(t, []string{
+		"[group-%s] value 1",
+		"[group-%s] value 2",
+		"[group-%s] value 3",
+		"[group-%s] value 4",
+	}, r.messages)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2022, Cisco Systems, Inc.
+// All rights reserved.
+
+// Package testutil provides an in-memory pxGrid Cloud pub/sub server to test apps built with the
+// SDK without a cloud environment.
+//
+// The server speaks the same websocket RPC protocol as pxGrid Cloud. Messages published to a stream
+// are delivered to its subscribers, and the Config knobs inject connection, publish and consume
+// failures. NewApp returns an App that talks to the server.
+//
+// Every server has its own streams and subscriptions, tests running in parallel against different
+// servers don't see each other's messages.
+package testutil
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	cloud "github.com/cisco-pxgrid/cloud-sdk-go"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub/test"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/rpc"
+	"github.com/google/uuid"
+)
+
+const (
+	// PubSubPath is the path of the pub/sub websocket endpoint served by the server
+	PubSubPath = "/api/v2/pubsub"
+	// SubscriptionsPath is the path of the subscriptions endpoint served by the server
+	SubscriptionsPath = "/api/dxhub/v1/registry/subscriptions"
+)
+
+// Config configures the behavior of the server. The zero value is a server that accepts all
+// connections and requests.
+type Config struct {
+	// RejectConn rejects all the websocket connections with 500 Internal Server Error
+	RejectConn bool
+	// RejectReconnect rejects all the websocket connections after the first one
+	RejectReconnect bool
+	// PublishError fails all the publish requests with an RPC error
+	PublishError bool
+	// PublishFailures is the number of publish requests that fail before publishing succeeds
+	PublishFailures int
+	// PublishDrop never answers the publish requests, publishing times out
+	PublishDrop bool
+	// ConsumeError fails all the consume requests with an RPC error
+	ConsumeError bool
+	// ConsumeDrop never answers the consume requests, consuming times out
+	ConsumeDrop bool
+	// ConsumeErrorStreams fails the consume requests for these streams only
+	ConsumeErrorStreams []string
+	// ConsumeDelay delays the responses to the consume requests
+	ConsumeDelay time.Duration
+	// DeleteError fails the subscription deletions with 500 Internal Server Error
+	DeleteError bool
+	// ValidAuthToken validates the X-Auth-Token header of every request if set, requests with an
+	// invalid token are rejected with 401 Unauthorized
+	ValidAuthToken func(token string) bool
+	// PublishOrder is the order in which the payloads are expected to be published to each stream,
+	// indexed by stream. The test fails when a payload is published out of order.
+	PublishOrder map[string][]string
+}
+
+// Server is an in-memory pxGrid Cloud pub/sub server listening on a local TLS address
+type Server struct {
+	*httptest.Server
+	state *test.State
+}
+
+// NewServer starts a server configured with cfg. The server is closed when the test ends.
+func NewServer(t testing.TB, cfg Config) *Server {
+	state := test.NewState()
+	s := test.NewRPCServer(t, test.Config{
+		PubSubPath:          PubSubPath,
+		SubscriptionsPath:   SubscriptionsPath,
+		RejectConn:          cfg.RejectConn,
+		RejectReconnect:     cfg.RejectReconnect,
+		PublishError:        cfg.PublishError,
+		PublishFailures:     cfg.PublishFailures,
+		PublishDrop:         cfg.PublishDrop,
+		ConsumeError:        cfg.ConsumeError,
+		ConsumeDrop:         cfg.ConsumeDrop,
+		ConsumeErrorStreams: cfg.ConsumeErrorStreams,
+		ConsumeDelay:        cfg.ConsumeDelay,
+		DeleteError:         cfg.DeleteError,
+		ValidAuthToken:      cfg.ValidAuthToken,
+		PublishOrder:        cfg.PublishOrder,
+		State:               state,
+	})
+	t.Cleanup(s.Close)
+	return &Server{Server: s, state: state}
+}
+
+// Host returns the host and port the server listens on, to be used as the FQDN of the cloud
+// environment
+func (s *Server) Host() string {
+	u, _ := url.Parse(s.URL)
+	return u.Host
+}
+
+// Transport returns an HTTP transport that trusts the certificate of the server
+func (s *Server) Transport() *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+}
+
+// Publish delivers a message to the subscribers of the stream as if it was published by another
+// client. It returns false if the stream isn't subscribed.
+func (s *Server) Publish(stream string, headers map[string]string, payload []byte) bool {
+	return s.state.PublishRaw(stream, rpc.PublishParams{
+		Stream:  stream,
+		MsgID:   uuid.NewString(),
+		Headers: headers,
+		Payload: base64.StdEncoding.EncodeToString(payload),
+	})
+}
+
+// Subscribed returns true if the stream has a subscription on the server
+func (s *Server) Subscribed(stream string) bool {
+	_, ok := s.state.GetSubscriptionRequest(stream)
+	return ok
+}
+
+// ExpireSubscription removes the subscription with the supplied ID as if it expired on the server
+func (s *Server) ExpireSubscription(id string) {
+	s.state.ExpireSubscription(id)
+}
+
+// NewApp creates an App that uses the server as both its regional and global cloud environment.
+// The FQDNs, Transport and credentials of config are set to talk to the server if they are empty.
+// The App is closed when the test ends.
+func NewApp(t testing.TB, s *Server, config cloud.Config) *cloud.App {
+	t.Helper()
+	if config.ID == "" {
+		config.ID = "test-app"
+	}
+	if config.RegionalFQDN == "" {
+		config.RegionalFQDN = s.Host()
+	}
+	if config.GlobalFQDN == "" {
+		config.GlobalFQDN = s.Host()
+	}
+	if config.ReadStreamID == "" {
+		config.ReadStreamID = "test-read-stream"
+	}
+	if config.WriteStreamID == "" {
+		config.WriteStreamID = "test-write-stream"
+	}
+	if config.Transport == nil {
+		config.Transport = s.Transport()
+	}
+	if config.GetCredentials == nil && config.ApiKey == "" {
+		config.ApiKey = "test-api-key"
+	}
+	app, err := cloud.New(config)
+	if err != nil {
+		t.Fatalf("failed to create app: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = app.Close()
+	})
+	return app
+}
+
+// OrderVerifier fails the test when the recorded values deviate from the expected order, e.g. when
+// the messages of a stream aren't received in the order they were published
+type OrderVerifier struct {
+	v *test.OrderVerifier
+}
+
+// ExpectOrder returns an OrderVerifier expecting the values to be recorded in the supplied order,
+// e.g. the payloads received by a subscription callback
+func ExpectOrder(t testing.TB, expected ...string) *OrderVerifier {
+	return &OrderVerifier{v: test.ExpectOrder(t, expected...)}
+}
+
+// Record records the next value, the test fails if it's not the expected one. It's safe to call
+// Record from the subscription callbacks.
+func (v *OrderVerifier) Record(value string) {
+	v.v.Record(value)
+}
+
+// Wait waits until all the expected values are recorded, the test fails on timeout
+func (v *OrderVerifier) Wait(timeout time.Duration) {
+	v.v.Wait(timeout)
+}
@@ -0,0 +1,204 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cloud "github.com/cisco-pxgrid/cloud-sdk-go"
+	"github.com/cisco-pxgrid/cloud-sdk-go/internal/pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+func newConnection(t *testing.T, s *Server) *pubsub.Connection {
+	c, err := pubsub.NewConnection(pubsub.Config{
+		GroupID: "testutil",
+		Domain:  s.Host(),
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("xyz"), nil
+		},
+		Transport: s.Transport(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+func Test_ServerPublish(t *testing.T) {
+	s := NewServer(t, Config{})
+	c := newConnection(t, s)
+	require.NoError(t, c.Connect(context.Background()))
+
+	stream := "testutil-publish"
+	received := make(chan string, 1)
+	err := c.Subscribe(stream, func(err error, id string, headers map[string]string, payload []byte) {
+		require.NoError(t, err)
+		received <- headers["key"] + ":" + string(payload)
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return s.Subscribed(stream) }, 5*time.Second, 10*time.Millisecond)
+
+	require.True(t, s.Publish(stream, map[string]string{"key": "value"}, []byte("payload")))
+	select {
+	case msg := <-received:
+		require.Equal(t, "value:payload", msg)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "message not received")
+	}
+	require.False(t, s.Publish("testutil-unknown", nil, []byte("payload")))
+}
+
+func Test_ServerRejectConn(t *testing.T) {
+	s := NewServer(t, Config{RejectConn: true})
+	c := newConnection(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Error(t, c.Connect(ctx))
+}
+
+func Test_ServerPublishError(t *testing.T) {
+	s := NewServer(t, Config{PublishError: true})
+	c := newConnection(t, s)
+	require.NoError(t, c.Connect(context.Background()))
+
+	r, err := c.Publish(context.Background(), "testutil-publish-error", nil, []byte("payload"))
+	require.NoError(t, err)
+	require.Error(t, r.Error)
+}
+
+func Test_NewApp(t *testing.T) {
+	s := NewServer(t, Config{})
+	app := NewApp(t, s, cloud.Config{})
+	require.NotNil(t, app)
+}
+
+func Test_ServersIsolated(t *testing.T) {
+	s1 := NewServer(t, Config{})
+	s2 := NewServer(t, Config{})
+	c := newConnection(t, s1)
+	require.NoError(t, c.Connect(context.Background()))
+
+	stream := "testutil-isolated"
+	require.NoError(t, c.Subscribe(stream, func(error, string, map[string]string, []byte) {}))
+	require.Eventually(t, func() bool { return s1.Subscribed(stream) }, 5*time.Second, 10*time.Millisecond)
+	// the subscription of the first server is unknown to the second one
+	require.False(t, s2.Subscribed(stream))
+	require.False(t, s2.Publish(stream, nil, []byte("payload")))
+}
+
+func Test_ServerPublishOrder(t *testing.T) {
+	stream := "testutil-publish-order"
+	s := NewServer(t, Config{
+		PublishOrder: map[string][]string{stream: {"first", "second"}},
+	})
+	c := newConnection(t, s)
+	require.NoError(t, c.Connect(context.Background()))
+
+	for _, payload := range []string{"first", "second"} {
+		r, err := c.Publish(context.Background(), stream, nil, []byte(payload))
+		require.NoError(t, err)
+		require.NoError(t, r.Error)
+	}
+}
+
+func Test_OrderVerifier(t *testing.T) {
+	s := NewServer(t, Config{})
+	c := newConnection(t, s)
+	require.NoError(t, c.Connect(context.Background()))
+
+	stream := "testutil-order"
+	v := ExpectOrder(t, "1", "2", "3")
+	require.NoError(t, c.Subscribe(stream, func(err error, id string, headers map[string]string, payload []byte) {
+		v.Record(string(payload))
+	}))
+	require.Eventually(t, func() bool { return s.Subscribed(stream) }, 5*time.Second, 10*time.Millisecond)
+	for _, payload := range []string{"1", "2", "3"} {
+		require.True(t, s.Publish(stream, nil, []byte(payload)))
+	}
+	v.Wait(5 * time.Second)
+
+	// the deviations are reported to the test
+	ft := &exampleTB{}
+	v = ExpectOrder(ft, "1", "2")
+	v.Record("2")
+	v.Wait(10 * time.Millisecond)
+	require.Equal(t, []string{
+		`Ordering violation at position 0: expected "1", got "2"`,
+		"Timed out waiting for ordered values: recorded 1 of 2",
+	}, ft.errors)
+}
+
+// exampleTB is the testing.TB of the examples, which have no test to fail. It records the failures
+// and runs the cleanup functions when closed.
+type exampleTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (*exampleTB) Helper() {}
+
+func (*exampleTB) Logf(string, ...interface{}) {}
+
+func (t *exampleTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *exampleTB) Fatalf(format string, args ...interface{}) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+func (t *exampleTB) Cleanup(f func()) {
+	t.cleanups = append(t.cleanups, f)
+}
+
+func (t *exampleTB) close() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func ExampleOrderVerifier() {
+	// t is the *testing.T of the test
+	t := &exampleTB{}
+	defer t.close()
+
+	s := NewServer(t, Config{})
+	conn, err := pubsub.NewConnection(pubsub.Config{
+		GroupID: "example",
+		Domain:  s.Host(),
+		APIKeyProvider: func() ([]byte, error) {
+			return []byte("api-key"), nil
+		},
+		Transport: s.Transport(),
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer conn.Disconnect()
+	if err := conn.Connect(context.Background()); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+
+	stream := "example-order"
+	v := ExpectOrder(t, "first", "second", "third")
+	err = conn.Subscribe(stream, func(err error, id string, headers map[string]string, payload []byte) {
+		v.Record(string(payload))
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	for !s.Subscribed(stream) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, payload := range []string{"first", "second", "third"} {
+		s.Publish(stream, nil, []byte(payload))
+	}
+	v.Wait(5 * time.Second)
+	fmt.Println("failures:", len(t.errors))
+	// Output: failures: 0
+}